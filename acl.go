@@ -0,0 +1,267 @@
+package gophirc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// The roles built from the config's admins & ignored users.
+const (
+	RoleAdmin   = "admin"   // has every permission
+	RoleIgnored = "ignored" // the user's events don't reach the callbacks
+)
+
+// AllPermissions is the permission granting every other one.
+const AllPermissions = "*"
+
+// ACL gives roles to the users matching their masks, each role having a set of permissions.
+// A mask is either a "nick!user@host" wildcard mask ("*" & "?" wildcards, partial masks like
+// "*@host" being completed), "$a:account" matching a services account, or a bare nickname.
+// Nicknames & hosts are compared with the server's case mapping.
+type ACL struct {
+	mu    sync.RWMutex
+	roles map[string]*aclRole
+
+	support *ISupport
+}
+
+type aclRole struct {
+	masks       []string
+	permissions map[string]bool
+}
+
+func newACL(support *ISupport) *ACL {
+	return &ACL{roles: make(map[string]*aclRole), support: support}
+}
+
+// load replaces the roles with the ones in the config, the admins & ignored users included.
+func (a *ACL) load(s *config.Server) {
+	for _, mask := range s.Admins {
+		if !strings.ContainsAny(mask, "!@$") {
+			logger.Log.WithField("admin", mask).Warnln("Admin matched by nickname only, anyone can take it")
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.roles = make(map[string]*aclRole)
+	for name, r := range s.Roles {
+		role := a.role(name)
+		role.masks = append(role.masks, r.Masks...)
+		for _, p := range r.Permissions {
+			role.permissions[p] = true
+		}
+	}
+
+	admin := a.role(RoleAdmin)
+	admin.masks = append(admin.masks, s.Admins...)
+	admin.permissions[AllPermissions] = true
+
+	ignored := a.role(RoleIgnored)
+	ignored.masks = append(ignored.masks, s.Ignore...)
+}
+
+func (a *ACL) role(name string) *aclRole {
+	if a.roles[name] == nil {
+		a.roles[name] = &aclRole{permissions: make(map[string]bool)}
+	}
+	return a.roles[name]
+}
+
+// AddMask gives the role to the users matching the mask, creating the role if needed.
+func (a *ACL) AddMask(role, mask string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := a.role(role)
+	for _, m := range r.masks {
+		if m == mask {
+			return
+		}
+	}
+	r.masks = append(r.masks, mask)
+}
+
+// RemoveMask removes the mask from the role, returning whether it was there.
+func (a *ACL) RemoveMask(role, mask string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, ok := a.roles[role]
+	if !ok {
+		return false
+	}
+	for i, m := range r.masks {
+		if m == mask {
+			r.masks = append(r.masks[:i], r.masks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Masks returns the masks of the role.
+func (a *ACL) Masks(role string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if r, ok := a.roles[role]; ok {
+		return append([]string{}, r.masks...)
+	}
+	return nil
+}
+
+// Grant adds the permissions to the role, creating the role if needed.
+func (a *ACL) Grant(role string, permissions ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := a.role(role)
+	for _, p := range permissions {
+		r.permissions[p] = true
+	}
+}
+
+// Revoke removes the permissions from the role.
+func (a *ACL) Revoke(role string, permissions ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r, ok := a.roles[role]; ok {
+		for _, p := range permissions {
+			delete(r.permissions, p)
+		}
+	}
+}
+
+// Roles returns the roles of the user, sorted. The account is the user's services account,
+// empty if not logged in or unknown.
+func (a *ACL) Roles(u *User, account string) []string {
+	if u == nil {
+		return nil
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var roles []string
+	for name, r := range a.roles {
+		if a.matchesAny(r.masks, u, account) {
+			roles = append(roles, name)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// HasRole returns whether the user has the role.
+func (a *ACL) HasRole(u *User, account, role string) bool {
+	if u == nil {
+		return false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	r, ok := a.roles[role]
+	return ok && a.matchesAny(r.masks, u, account)
+}
+
+// Can returns whether one of the user's roles has the permission.
+func (a *ACL) Can(u *User, account, permission string) bool {
+	if u == nil {
+		return false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, r := range a.roles {
+		if (r.permissions[permission] || r.permissions[AllPermissions]) && a.matchesAny(r.masks, u, account) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACL) matchesAny(masks []string, u *User, account string) bool {
+	for _, mask := range masks {
+		if a.matches(mask, u, account) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns whether the user matches the mask.
+func (a *ACL) matches(mask string, u *User, account string) bool {
+	if strings.HasPrefix(mask, "$a:") {
+		return account != "" && wildcardMatch(a.support.Fold(mask[3:]), a.support.Fold(account))
+	}
+
+	hasNick, hasHost := strings.Contains(mask, "!"), strings.Contains(mask, "@")
+	switch {
+	case !hasNick && !hasHost:
+		return wildcardMatch(a.support.Fold(mask), a.support.Fold(u.Nick))
+	case !hasNick:
+		mask = "*!" + mask
+	case !hasHost:
+		mask += "@*"
+	}
+	return wildcardMatch(a.support.Fold(mask), a.support.Fold(u.String()))
+}
+
+// wildcardMatch returns whether the string matches the pattern, "*" matching any number of
+// characters & "?" exactly one.
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case star != -1:
+			p = star + 1
+			next++
+			i = next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// ACL returns the access control list, built from the config & modifiable at runtime.
+func (irc *IRC) ACL() *ACL {
+	return irc.acl
+}
+
+// HasRole returns whether the event's user has the role, matching the account the user was
+// logged in with when the line was read, as the nickname may belong to someone else by now.
+func (irc *IRC) HasRole(e *Event, role string) bool {
+	if e == nil || e.User == nil {
+		return false
+	}
+	return irc.acl.HasRole(e.User, e.Account, role)
+}
+
+// Can returns whether the event's user has the permission, matching the account the user was
+// logged in with when the line was read.
+func (irc *IRC) Can(e *Event, permission string) bool {
+	if e == nil || e.User == nil {
+		return false
+	}
+	return irc.acl.Can(e.User, e.Account, permission)
+}
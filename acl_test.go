@@ -0,0 +1,225 @@
+package gophirc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*!*@host.tld", "nick!user@host.tld", true},
+		{"*!*@*.tld", "nick!user@host.tld", true},
+		{"*!*@*.tld", "nick!user@host.com", false},
+		{"n*k!*@*", "nick!user@host", true},
+		{"n*k!*@*", "nic!user@host", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.s, func(t *testing.T) {
+			if actual := wildcardMatch(test.pattern, test.s); actual != test.expected {
+				t.Errorf("%q ~ %q: expected %v, got %v instead.", test.pattern, test.s, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestACL(t *testing.T) {
+	a := newACL(newISupport())
+	a.load(&config.Server{
+		Admins: []string{"*!*@admin.host", "$a:boss"},
+		Ignore: []string{"spammer"},
+		Roles: map[string]*config.Role{
+			"trusted": {Masks: []string{"*@*.trusted.tld", "friend[1]!*"}, Permissions: []string{"say", "op"}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		user    *User
+		account string
+		roles   []string
+		can     []string
+		cannot  []string
+	}{
+		{"host", &User{"anyone", "u", "ADMIN.host"}, "", []string{RoleAdmin}, []string{"say", "anything"}, nil},
+		{"account", &User{"anyone", "u", "h"}, "Boss", []string{RoleAdmin}, []string{"anything"}, nil},
+		{"other account", &User{"anyone", "u", "h"}, "bosses", nil, nil, []string{"say"}},
+		{"nick only", &User{"Spammer", "u", "h"}, "", []string{RoleIgnored}, nil, []string{"say"}},
+		{"partial host", &User{"n", "u", "x.trusted.tld"}, "", []string{"trusted"}, []string{"say", "op"}, []string{"kick"}},
+		{"partial nick", &User{"FRIEND{1}", "u", "h"}, "", []string{"trusted"}, []string{"say"}, []string{"kick"}},
+		{"nobody", &User{"n", "u", "h"}, "", nil, nil, []string{"say"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := a.Roles(test.user, test.account); !reflect.DeepEqual(actual, test.roles) {
+				t.Errorf("Expected roles %q, got %q instead.", test.roles, actual)
+			}
+			for _, p := range test.can {
+				if !a.Can(test.user, test.account, p) {
+					t.Errorf("%v should have permission %q", test.user, p)
+				}
+			}
+			for _, p := range test.cannot {
+				if a.Can(test.user, test.account, p) {
+					t.Errorf("%v shouldn't have permission %q", test.user, p)
+				}
+			}
+		})
+	}
+
+	u := &User{"n", "u", "new.host"}
+	a.AddMask("trusted", "*!*@new.host")
+	a.Revoke("trusted", "op")
+	if !a.HasRole(u, "", "trusted") || !a.Can(u, "", "say") || a.Can(u, "", "op") {
+		t.Errorf("Runtime changes not applied: %q", a.Roles(u, ""))
+	}
+	if !a.RemoveMask("trusted", "*!*@new.host") || a.HasRole(u, "", "trusted") {
+		t.Error("Mask not removed")
+	}
+	if a.RemoveMask("missing", "*") {
+		t.Error("Missing role shouldn't have masks")
+	}
+}
+
+func TestIRC_ACLAccounts(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"$a:boss"}, Ignore: []string{"$a:spam*"}})
+
+	var events []*Event
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		events = append(events, e)
+	})
+
+	i.ReadEvent(":gophirc!u@h JOIN #chan * :gophirc")
+	i.ReadEvent(":boss!u@h JOIN #chan boss :The Boss")
+	i.ReadEvent(":fake!u@h JOIN #chan * :Not The Boss")
+	i.ReadEvent("@account=spammer :spam!u@h PRIVMSG #chan :buy stuff")
+	i.ReadEvent(":boss!u@h PRIVMSG #chan :hi")
+
+	if len(events) != 1 || events[0].Account != "boss" {
+		t.Fatalf("Expected a single event from the boss account, got %+v", events)
+	}
+	if !i.IsAdmin(&User{"boss", "u", "h"}) || i.IsAdmin(&User{"fake", "u", "h"}) {
+		t.Error("Admin not matched by account")
+	}
+
+	i.ReadEvent(":boss!u@h NICK newboss")
+	if !i.IsAdmin(&User{"newboss", "u", "h"}) {
+		t.Error("Account should follow the nick change")
+	}
+
+	i.ReadEvent(":newboss!u@h ACCOUNT *")
+	if i.IsAdmin(&User{"newboss", "u", "h"}) {
+		t.Error("Account should be forgotten after logging out")
+	}
+
+	i.ReadEvent(":fake!u@h ACCOUNT boss")
+	i.ReadEvent(":fake!u@h QUIT :bye")
+	if i.Account("fake") != "" {
+		t.Error("Account should be forgotten after quitting")
+	}
+}
+
+func TestIRC_ACLNickSwap(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"$a:boss"}})
+	r := i.NewRouter("!")
+	var called bool
+	r.Handle(&Command{Name: "op", Permission: PermissionAdmin, Handler: func(e *CommandEvent) error {
+		called = true
+		return nil
+	}})
+
+	i.ReadEvent(":gophirc!u@h JOIN #chan * :gophirc")
+	i.ReadEvent(":X!u@h JOIN #chan * :Not The Boss")
+	i.ReadEvent(":boss!u@h JOIN #chan boss :The Boss")
+
+	// read, then dispatched once the nicknames were swapped
+	e, _ := i.process(":X!u@h PRIVMSG gophirc :!op")
+	i.ReadEvent(":X!u@h NICK Y")
+	i.ReadEvent(":boss!u@h NICK X")
+
+	if i.HasRole(e, RoleAdmin) {
+		t.Error("Expected the event's account to be matched, got an admin instead.")
+	}
+	if !i.IsAdmin(e.User) {
+		t.Error("Expected the nickname's current account to be matched, got no admin instead.")
+	}
+	i.safeDispatch(e)
+	if called {
+		t.Error("Expected the admin command not to run.")
+	}
+}
+
+func TestIRC_ACLAccountsForgotten(t *testing.T) {
+	tests := []struct {
+		name       string
+		accountTag bool
+		lines      []string // after alice joined #chan logged in as boss
+	}{
+		{"part", false, []string{":alice!u@h PART #chan"}},
+		{"kick", false, []string{":op!u@h KICK #chan alice :bye"}},
+		{"our part", false, []string{":gophirc!u@h PART #chan"}},
+		{"our kick", false, []string{":op!u@h KICK #chan gophirc :bye"}},
+		{"untagged message", true, []string{":alice!u@h PRIVMSG #chan :hi"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"$a:boss"}})
+			if test.accountTag {
+				i.caps.Lock()
+				i.State.Capabilities["account-tag"] = ""
+				i.caps.Unlock()
+			}
+
+			i.ReadEvent("@account=me :gophirc!u@h JOIN #chan me :gophirc")
+			i.ReadEvent("@account=boss :alice!u@h JOIN #chan boss :The Boss")
+			if !i.IsAdmin(&User{"alice", "u", "h"}) {
+				t.Fatal("Expected alice to be an admin, got false instead.")
+			}
+
+			for _, l := range test.lines {
+				i.ReadEvent(l)
+			}
+			// anyone can take the nickname now
+			i.ReadEvent(":alice!x@evil PRIVMSG gophirc :hi")
+			if i.IsAdmin(&User{"alice", "x", "evil"}) {
+				t.Error("Expected the account to be forgotten, got an admin instead.")
+			}
+		})
+	}
+}
+
+func TestIRC_ACLAccountsUnshared(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"$a:boss"}})
+
+	var events []*Event
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		events = append(events, e)
+	})
+
+	i.ReadEvent(":gophirc!u@h JOIN #chan * :gophirc")
+	for n := 0; n < 10; n++ {
+		i.ReadEvent(fmt.Sprintf("@account=boss :user%d!u@h PRIVMSG gophirc :hi", n))
+	}
+
+	if len(events) != 10 || events[9].Account != "boss" || !i.HasRole(events[9], RoleAdmin) {
+		t.Fatalf("Expected the events to carry the tagged account, got %+v", events)
+	}
+	i.tracker.RLock()
+	defer i.tracker.RUnlock()
+	if len(i.tracker.accounts) != 0 {
+		t.Errorf("Expected no accounts kept for the users sharing no channel, got %q", i.tracker.accounts)
+	}
+}
@@ -0,0 +1,112 @@
+package gophirc
+
+import (
+	"sync/atomic"
+)
+
+// Callback is a callback bound to an event code, returned by On, Once & OnFilter to be
+// removed later on.
+type Callback struct {
+	Code string
+
+	fn     func(*Event)
+	filter func(*Event) bool
+	once   bool
+
+	fired   int32 // once callbacks, set when called
+	removed int32
+
+	irc *IRC
+}
+
+// call calls the callback, unless it was removed, the filter rejects the event, or it's a once
+// callback already called.
+func (c *Callback) call(e *Event) {
+	if atomic.LoadInt32(&c.removed) == 1 {
+		return
+	}
+	if c.filter != nil && !c.filter(e) {
+		return
+	}
+	if c.once {
+		if !atomic.CompareAndSwapInt32(&c.fired, 0, 1) {
+			return
+		}
+		c.Remove()
+	}
+	c.fn(e)
+}
+
+// Remove unbinds the callback, returning false if it was already removed.
+// It's safe to call from any callback, including itself.
+func (c *Callback) Remove() bool {
+	if !atomic.CompareAndSwapInt32(&c.removed, 0, 1) {
+		return false
+	}
+
+	irc := c.irc
+	irc.eventsMu.Lock()
+	defer irc.eventsMu.Unlock()
+
+	var kept []*Callback
+	for _, cb := range irc.Events[c.Code] {
+		if cb != c {
+			kept = append(kept, cb)
+		}
+	}
+	if len(kept) == 0 {
+		delete(irc.Events, c.Code)
+	} else {
+		irc.Events[c.Code] = kept
+	}
+	return true
+}
+
+func (irc *IRC) addCallback(c *Callback) *Callback {
+	c.irc = irc
+
+	irc.eventsMu.Lock()
+	defer irc.eventsMu.Unlock()
+
+	// copy on write, the callbacks in progress keep their own slice
+	cbs := irc.Events[c.Code]
+	irc.Events[c.Code] = append(cbs[:len(cbs):len(cbs)], c)
+	return c
+}
+
+// On binds a callback to the event code, returning it so it can be removed.
+func (irc *IRC) On(code string, cb func(*Event)) *Callback {
+	return irc.addCallback(&Callback{Code: code, fn: cb})
+}
+
+// Once binds a callback to the event code, removed after its first call.
+func (irc *IRC) Once(code string, cb func(*Event)) *Callback {
+	return irc.addCallback(&Callback{Code: code, fn: cb, once: true})
+}
+
+// OnFilter binds a callback to the event code, called only for the events the filter accepts.
+func (irc *IRC) OnFilter(code string, filter func(*Event) bool, cb func(*Event)) *Callback {
+	return irc.addCallback(&Callback{Code: code, fn: cb, filter: filter})
+}
+
+// callbacksFor returns the callbacks bound to the event code.
+func (irc *IRC) callbacksFor(code string) []*Callback {
+	irc.eventsMu.RLock()
+	defer irc.eventsMu.RUnlock()
+
+	return irc.Events[code]
+}
+
+// InChannel returns a filter accepting the events sent to the channel, e.g. PRIVMSG or JOIN.
+func (irc *IRC) InChannel(channel string) func(*Event) bool {
+	return func(e *Event) bool {
+		return len(e.Arguments) > 0 && irc.IsChannel(e.Arguments[0]) && irc.EqualFold(e.Arguments[0], channel)
+	}
+}
+
+// FromNick returns a filter accepting the events sent by the user.
+func (irc *IRC) FromNick(nick string) func(*Event) bool {
+	return func(e *Event) bool {
+		return e.User != nil && irc.EqualFold(e.User.Nick, nick)
+	}
+}
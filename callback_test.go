@@ -0,0 +1,100 @@
+package gophirc
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_RemoveCallback(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	var calls []string
+	var second *Callback
+	first := i.On("PRIVMSG", func(e *Event) {
+		calls = append(calls, "first")
+		if e.Message == "remove" {
+			second.Remove() // from inside another callback
+		}
+	})
+	second = i.On("PRIVMSG", func(e *Event) {
+		calls = append(calls, "second")
+	})
+	var self *Callback
+	self = i.On("PRIVMSG", func(e *Event) {
+		calls = append(calls, "self")
+		self.Remove()
+	})
+
+	i.ReadEvent(":user!u@h PRIVMSG #chan :hi")
+	i.ReadEvent(":user!u@h PRIVMSG #chan :remove")
+	i.ReadEvent(":user!u@h PRIVMSG #chan :hi")
+
+	expected := []string{"first", "second", "self", "first", "first"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %q, got %q instead.", expected, calls)
+	}
+
+	if second.Remove() {
+		t.Error("Callback removed twice")
+	}
+	if !first.Remove() {
+		t.Error("Callback should be removed")
+	}
+	if _, ok := i.Events["PRIVMSG"]; ok {
+		t.Errorf("Expected no PRIVMSG callbacks, got %d", len(i.Events["PRIVMSG"]))
+	}
+}
+
+func TestIRC_Once(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	var calls int32
+	i.Once("PRIVMSG", func(e *Event) {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			i.ReadEvent(":user!u@h PRIVMSG #chan :hi")
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected a single call, got %d instead.", calls)
+	}
+	if len(i.Events["PRIVMSG"]) != 0 {
+		t.Error("Once callback should be removed after its call")
+	}
+}
+
+func TestIRC_OnFilter(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	var channel, user []string
+	i.OnFilter("PRIVMSG", i.InChannel("#Chan"), func(e *Event) {
+		channel = append(channel, e.Message)
+	})
+	i.OnFilter("PRIVMSG", i.FromNick("Someone"), func(e *Event) {
+		user = append(user, e.Message)
+	})
+
+	i.ReadEvent(":user!u@h PRIVMSG #chan :one")
+	i.ReadEvent(":someone!u@h PRIVMSG #other :two")
+	i.ReadEvent(":someone!u@h PRIVMSG gophirc :three")
+	i.ReadEvent(":user!u@h PRIVMSG #CHAN :four")
+
+	if expected := []string{"one", "four"}; !reflect.DeepEqual(channel, expected) {
+		t.Errorf("Expected channel messages %q, got %q instead.", expected, channel)
+	}
+	if expected := []string{"two", "three"}; !reflect.DeepEqual(user, expected) {
+		t.Errorf("Expected user messages %q, got %q instead.", expected, user)
+	}
+}
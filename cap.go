@@ -0,0 +1,298 @@
+package gophirc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// capabilities keeps track of the IRCv3 capability negotiation.
+type capabilities struct {
+	sync.RWMutex
+
+	wanted    map[string]bool   // requested by the user, either from the config or RequestCapability
+	available map[string]string // advertised by the server, along with their values
+	ls        map[string]string // multi-line LS reply being accumulated
+
+	negotiating bool // true until we send CAP END
+	pending     int  // REQs sent & not yet answered
+	holds       int  // steps, such as SASL, delaying CAP END
+
+	authenticating bool // SASL authentication in progress
+}
+
+// RequestCapability adds the capabilities to the ones requested during the negotiation.
+// If the negotiation already ended, the ones available are requested right away.
+func (irc *IRC) RequestCapability(names ...string) {
+	irc.caps.Lock()
+	for _, name := range names {
+		irc.caps.wanted[name] = true
+	}
+	var req []string
+	if irc.caps.available != nil && !irc.caps.negotiating {
+		for _, name := range irc.capsToRequest() {
+			for _, n := range names {
+				if name == n {
+					req = append(req, name)
+				}
+			}
+		}
+	}
+	irc.caps.Unlock()
+
+	irc.capRequest(req)
+}
+
+// HasCapability returns whether the capability was acknowledged by the server.
+func (irc *IRC) HasCapability(name string) bool {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	_, ok := irc.State.Capabilities[name]
+	return ok
+}
+
+// CapabilityValue returns the value advertised by the server for the capability (e.g. the SASL
+// mechanisms for "sasl"), and whether the server advertised it at all.
+func (irc *IRC) CapabilityValue(name string) (string, bool) {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	v, ok := irc.caps.available[name]
+	return v, ok
+}
+
+// negotiateCaps starts the negotiation, which suspends the registration until CAP END.
+func (irc *IRC) negotiateCaps() {
+	irc.caps.Lock()
+	irc.caps.available = nil
+	irc.caps.ls = nil
+	irc.caps.negotiating = true
+	irc.caps.pending = 0
+	irc.caps.holds = 0
+	irc.caps.authenticating = false
+	irc.State.Capabilities = make(map[string]string)
+	irc.State.SASL = SASLNone
+	irc.caps.Unlock()
+
+	irc.SendRaw("CAP LS 302")
+}
+
+// capsToRequest returns the wanted capabilities which are available & not enabled yet.
+// Must be called with the lock held.
+func (irc *IRC) capsToRequest() []string {
+	var req []string
+	for name := range irc.caps.wanted {
+		if _, ok := irc.caps.available[name]; !ok {
+			continue
+		}
+		if _, ok := irc.State.Capabilities[name]; ok {
+			continue
+		}
+		req = append(req, name)
+	}
+	sort.Strings(req)
+	return req
+}
+
+// capRequest sends CAP REQ commands, splitting them so the replies fit in a line.
+func (irc *IRC) capRequest(names []string) {
+	var lines []string
+	var line string
+	for _, name := range names {
+		if line != "" && len(line)+len(name) > 400 {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += name
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	irc.caps.Lock()
+	irc.caps.pending += len(lines)
+	irc.caps.Unlock()
+
+	for _, l := range lines {
+		irc.SendRawf("CAP REQ :%s", l)
+	}
+}
+
+// capEnd ends the negotiation, letting the server complete our registration, unless SASL is
+// required & we aren't authenticated, e.g. the server didn't offer or rejected it.
+func (irc *IRC) capEnd() {
+	irc.caps.Lock()
+	if !irc.caps.negotiating {
+		irc.caps.Unlock()
+		return
+	}
+	irc.caps.negotiating = false
+	irc.caps.Unlock()
+
+	if irc.saslMissing() {
+		return
+	}
+	irc.SendRaw("CAP END")
+}
+
+// capUnsupported ends the negotiation without CAP END, the server registering us without it:
+// on ERR_UNKNOWNCOMMAND (421) for CAP, or on RPL_WELCOME (001) if it ignored our CAP LS.
+func (irc *IRC) capUnsupported(e *Event) {
+	if e.Code == "421" && (len(e.Arguments) < 2 || !strings.EqualFold(e.Arguments[1], "CAP")) {
+		return
+	}
+
+	irc.caps.Lock()
+	negotiating := irc.caps.negotiating
+	irc.caps.negotiating = false
+	irc.caps.pending = 0
+	irc.caps.holds = 0
+	irc.caps.Unlock()
+
+	if negotiating {
+		logger.Log.Infoln("Capability negotiation not supported by the server")
+		irc.saslMissing()
+	}
+}
+
+// parseCapList splits a "cap1 cap2=value" list into a map of capabilities & their values.
+func parseCapList(s string) map[string]string {
+	caps := make(map[string]string)
+	for _, c := range strings.Fields(s) {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) == 2 {
+			caps[kv[0]] = kv[1]
+		} else {
+			caps[kv[0]] = ""
+		}
+	}
+	return caps
+}
+
+// handleCap handles the CAP replies, e.g. ":server CAP nick LS * :cap1 cap2=value".
+func (irc *IRC) handleCap(e *Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+	list := parseCapList(e.Arguments[len(e.Arguments)-1])
+	more := len(e.Arguments) > 3 && e.Arguments[2] == "*"
+
+	switch strings.ToUpper(e.Arguments[1]) {
+	case "LS":
+		irc.capLS(list, more)
+	case "ACK":
+		irc.capAck(list)
+	case "NAK":
+		logger.Log.WithField("capabilities", e.Arguments[len(e.Arguments)-1]).Warnln("Capabilities rejected")
+		irc.capAnswered()
+	case "NEW":
+		irc.caps.Lock()
+		if irc.caps.available == nil {
+			irc.caps.available = make(map[string]string)
+		}
+		for k, v := range list {
+			irc.caps.available[k] = v
+		}
+		req := irc.capsToRequest()
+		irc.caps.Unlock()
+		irc.capRequest(req)
+	case "DEL":
+		irc.caps.Lock()
+		for k := range list {
+			delete(irc.caps.available, k)
+			delete(irc.State.Capabilities, k)
+		}
+		irc.caps.Unlock()
+	}
+}
+
+func (irc *IRC) capLS(list map[string]string, more bool) {
+	irc.caps.Lock()
+	if irc.caps.ls == nil {
+		irc.caps.ls = make(map[string]string)
+	}
+	for k, v := range list {
+		irc.caps.ls[k] = v
+	}
+	if more {
+		irc.caps.Unlock()
+		return
+	}
+
+	irc.caps.available, irc.caps.ls = irc.caps.ls, nil
+	negotiating := irc.caps.negotiating
+	req := irc.capsToRequest()
+	irc.caps.Unlock()
+
+	if !negotiating {
+		return
+	}
+	if len(req) == 0 {
+		irc.capEnd()
+		return
+	}
+	irc.capRequest(req)
+}
+
+func (irc *IRC) capAck(list map[string]string) {
+	var sasl bool
+	irc.caps.Lock()
+	for k := range list {
+		if strings.HasPrefix(k, "-") {
+			delete(irc.State.Capabilities, k[1:])
+			continue
+		}
+		irc.State.Capabilities[k] = irc.caps.available[k]
+
+		if k == "sasl" && irc.caps.negotiating && irc.Config().SASLMechanism != "" {
+			sasl = true
+			irc.caps.holds++
+		}
+	}
+	irc.caps.Unlock()
+
+	logger.Log.WithField("capabilities", list).Infoln("Capabilities acknowledged")
+	if sasl {
+		irc.authenticate()
+	}
+	irc.capAnswered()
+}
+
+// capAnswered ends the negotiation once all the requests got a reply.
+func (irc *IRC) capAnswered() {
+	irc.caps.Lock()
+	if irc.caps.pending > 0 {
+		irc.caps.pending--
+	}
+	done := irc.caps.pending == 0 && irc.caps.holds == 0
+	irc.caps.Unlock()
+
+	if done {
+		irc.capEnd()
+	}
+}
+
+// capRelease releases a hold on the negotiation, ending it if nothing else is pending.
+func (irc *IRC) capRelease() {
+	irc.caps.Lock()
+	if irc.caps.holds > 0 {
+		irc.caps.holds--
+	}
+	done := irc.caps.pending == 0 && irc.caps.holds == 0
+	irc.caps.Unlock()
+
+	if done {
+		irc.capEnd()
+	}
+}
+
+// negotiating returns whether the capability negotiation, and thus the registration, is in progress.
+func (irc *IRC) negotiating() bool {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	return irc.caps.negotiating
+}
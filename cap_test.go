@@ -0,0 +1,134 @@
+package gophirc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestParseCapList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected map[string]string
+	}{
+		{"", map[string]string{}},
+		{"multi-prefix sasl", map[string]string{"multi-prefix": "", "sasl": ""}},
+		{"sasl=PLAIN,EXTERNAL  draft/foo=a=b ", map[string]string{"sasl": "PLAIN,EXTERNAL", "draft/foo": "a=b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.list, func(t *testing.T) {
+			actual := parseCapList(test.list)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("%q: expected %v, got %v instead.", test.list, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIRC_CapNegotiation(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		Capabilities: []string{"multi-prefix", "sasl", "unavailable"},
+	})
+
+	i.Register()
+	expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+
+	i.ReadEvent(":irc.server.tld CAP * LS * :multi-prefix away-notify")
+	expectNoLines(t, lines)
+
+	i.ReadEvent(":irc.server.tld CAP * LS :sasl=PLAIN,EXTERNAL")
+	expectLines(t, lines, "CAP REQ :multi-prefix sasl")
+
+	if v, ok := i.CapabilityValue("sasl"); !ok || v != "PLAIN,EXTERNAL" {
+		t.Errorf("Expected sasl value %q, got %q (%v)", "PLAIN,EXTERNAL", v, ok)
+	}
+
+	i.ReadEvent(":irc.server.tld CAP * ACK :multi-prefix sasl")
+	expectLines(t, lines, "CAP END")
+
+	if !i.HasCapability("multi-prefix") || !i.HasCapability("sasl") {
+		t.Errorf("Capabilities not acknowledged: %v", i.State.Capabilities)
+	}
+	if i.HasCapability("away-notify") {
+		t.Error("Capability away-notify was not requested")
+	}
+
+	i.ReadEvent(":irc.server.tld CAP gophirc DEL :sasl")
+	if i.HasCapability("sasl") {
+		t.Error("Capability sasl should be deleted")
+	}
+
+	i.ReadEvent(":irc.server.tld CAP gophirc NEW :unavailable sasl=PLAIN")
+	expectLines(t, lines, "CAP REQ :sasl unavailable")
+
+	i.ReadEvent(":irc.server.tld CAP gophirc ACK :-multi-prefix")
+	expectNoLines(t, lines)
+	if i.HasCapability("multi-prefix") {
+		t.Error("Capability multi-prefix should be disabled")
+	}
+
+	i.RequestCapability("away-notify")
+	expectLines(t, lines, "CAP REQ :away-notify")
+}
+
+func TestIRC_CapNegotiationNothingWanted(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc", Username: "gophirc", Realname: "gophirc"})
+
+	i.Register()
+	expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+
+	i.ReadEvent(":irc.server.tld CAP * LS :multi-prefix sasl")
+	expectLines(t, lines, "CAP END")
+}
+
+func TestIRC_CapNegotiationNAK(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc", Username: "gophirc", Realname: "gophirc"})
+	i.RequestCapability("multi-prefix")
+
+	i.Register()
+	expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+
+	i.ReadEvent(":irc.server.tld CAP * LS :multi-prefix")
+	expectLines(t, lines, "CAP REQ :multi-prefix")
+
+	i.ReadEvent(":irc.server.tld CAP * NAK :multi-prefix")
+	expectLines(t, lines, "CAP END")
+
+	if i.HasCapability("multi-prefix") {
+		t.Error("Capability multi-prefix was rejected")
+	}
+}
+
+func TestIRC_CapNegotiationUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"unknown command", ":irc.server.tld 421 gophirc CAP :Unknown command"},
+		{"ignored", ":irc.server.tld 001 gophirc :Welcome"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc", Username: "gophirc", Realname: "gophirc"})
+
+			i.Register()
+			expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+
+			i.ReadEvent(test.raw)
+			expectNoLines(t, lines)
+			if i.negotiating() {
+				t.Error("Expected the negotiation to end, got true instead.")
+			}
+		})
+	}
+
+	// an unknown command other than CAP doesn't end it
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	i.Register()
+	i.ReadEvent(":irc.server.tld 421 gophirc FOO :Unknown command")
+	if !i.negotiating() {
+		t.Error("Expected the negotiation to go on, got false instead.")
+	}
+}
@@ -0,0 +1,430 @@
+package gophirc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channel is a snapshot of a channel's state, as tracked by the framework.
+// Modes holds the channel modes along with their parameter, if any; list modes (bans,
+// exceptions, etc.) aren't tracked. Members holds the users in the channel, by nickname.
+type Channel struct {
+	Name    string
+	Topic   Topic
+	Modes   map[rune]string
+	Members map[string]Member
+
+	support *ISupport // the server's case mapping, for Member
+}
+
+// Topic is a channel's topic, along with who set it & when, if the server told us.
+type Topic struct {
+	Text  string
+	SetBy string
+	SetAt time.Time
+}
+
+// Member is a user in a channel, with its prefixes ("@" for op, "+" for voice, etc.),
+// ordered from the highest rank to the lowest.
+type Member struct {
+	Nick     string
+	Prefixes string
+
+	ops string // the prefixes of the operators & above, from the server's PREFIX
+}
+
+// Member returns the member with the nickname, compared with the server's case mapping.
+func (c *Channel) Member(nick string) (Member, bool) {
+	support := c.support
+	if support == nil {
+		support = defaultISupport
+	}
+	for n, m := range c.Members {
+		if support.EqualFold(n, nick) {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+// HasPrefix returns whether the member has the prefix, e.g. '@' or '+'.
+func (m Member) HasPrefix(prefix byte) bool {
+	return strings.IndexByte(m.Prefixes, prefix) != -1
+}
+
+// IsOp returns whether the member is a channel operator or higher, as per the server's PREFIX,
+// e.g. '~', '&' or '@'.
+func (m Member) IsOp() bool {
+	ops := m.ops
+	if ops == "" {
+		ops = defaultISupport.OpPrefixes()
+	}
+	return strings.ContainsAny(m.Prefixes, ops)
+}
+
+// IsVoiced returns whether the member has voice ('+').
+func (m Member) IsVoiced() bool {
+	return m.HasPrefix('+')
+}
+
+// chanState is the tracked state of a channel we're in.
+type chanState struct {
+	name    string
+	topic   Topic
+	modes   map[rune]string
+	members map[string]*Member // by folded nickname
+
+	namesDone bool // the last NAMES reply ended, the next one replaces the members
+}
+
+// tracker keeps track of the channels we're in & their members. The nickname prefixes,
+// the channel modes & the case mapping come from ISUPPORT.
+type tracker struct {
+	sync.RWMutex
+	channels map[string]*chanState // by folded channel name
+	accounts map[string]string     // services accounts, by folded nickname
+
+	support *ISupport
+}
+
+func newTracker(support *ISupport) *tracker {
+	return &tracker{
+		channels: make(map[string]*chanState),
+		accounts: make(map[string]string),
+		support:  support,
+	}
+}
+
+func (t *tracker) fold(name string) string {
+	return t.support.Fold(name)
+}
+
+// reset forgets all the channels, used when connecting.
+func (t *tracker) reset() {
+	t.Lock()
+	t.channels = make(map[string]*chanState)
+	t.accounts = make(map[string]string)
+	t.Unlock()
+}
+
+// sortPrefixes orders the prefixes by rank & drops the duplicates.
+func (t *tracker) sortPrefixes(prefixes string) string {
+	_, symbols := t.support.Prefix()
+
+	var sorted []byte
+	for i := 0; i < len(symbols); i++ {
+		if strings.IndexByte(prefixes, symbols[i]) != -1 {
+			sorted = append(sorted, symbols[i])
+		}
+	}
+	return string(sorted)
+}
+
+// splitPrefixes splits a NAMES entry into its prefixes & nickname, dropping the user & host
+// sent with userhost-in-names.
+func (t *tracker) splitPrefixes(name string) (string, string) {
+	_, symbols := t.support.Prefix()
+
+	i := 0
+	for i < len(name) && strings.IndexByte(symbols, name[i]) != -1 {
+		i++
+	}
+	nick := name[i:]
+	if j := strings.IndexByte(nick, '!'); j != -1 {
+		nick = nick[:j]
+	}
+	return name[:i], nick
+}
+
+// update applies an event to the tracked state. self is our nickname, accountTag whether the
+// server tags the users' messages with their account.
+func (t *tracker) update(e *Event, self string, accountTag bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	args := e.Arguments
+	nick := ""
+	if e.User != nil {
+		nick = e.User.Nick
+	}
+
+	// after the channels, so a joining user already shares the channel with us
+	if nick != "" {
+		defer t.trackAccount(e, nick, accountTag)
+	}
+
+	switch e.Code {
+	case "JOIN":
+		if len(args) == 0 || nick == "" {
+			return
+		}
+		if t.support.EqualFold(nick, self) {
+			t.channels[t.fold(args[0])] = &chanState{
+				name:    args[0],
+				modes:   make(map[rune]string),
+				members: map[string]*Member{t.fold(nick): {Nick: nick}},
+			}
+			return
+		}
+		if c, ok := t.channels[t.fold(args[0])]; ok {
+			c.members[t.fold(nick)] = &Member{Nick: nick}
+		}
+	case "PART":
+		if len(args) > 0 {
+			t.leave(args[0], nick, self)
+		}
+	case "KICK":
+		if len(args) > 1 {
+			t.leave(args[0], args[1], self)
+		}
+	case "QUIT":
+		for _, c := range t.channels {
+			delete(c.members, t.fold(nick))
+		}
+	case "NICK":
+		if len(args) == 0 {
+			return
+		}
+		for _, c := range t.channels {
+			if m, ok := c.members[t.fold(nick)]; ok {
+				delete(c.members, t.fold(nick))
+				m.Nick = args[0]
+				c.members[t.fold(args[0])] = m
+			}
+		}
+	case "MODE":
+		if len(args) > 1 {
+			if c, ok := t.channels[t.fold(args[0])]; ok {
+				t.applyModes(c, args[1], args[2:])
+			}
+		}
+	case "TOPIC":
+		if len(args) > 1 {
+			if c, ok := t.channels[t.fold(args[0])]; ok {
+				c.topic = Topic{Text: args[1], SetBy: e.Source, SetAt: time.Now()}
+			}
+		}
+	case "324": // RPL_CHANNELMODEIS: <me> <channel> <modes> [params...]
+		if len(args) > 2 {
+			if c, ok := t.channels[t.fold(args[1])]; ok {
+				c.modes = make(map[rune]string)
+				t.applyModes(c, args[2], args[3:])
+			}
+		}
+	case "332": // RPL_TOPIC: <me> <channel> :<topic>
+		if len(args) > 2 {
+			if c, ok := t.channels[t.fold(args[1])]; ok {
+				c.topic.Text = args[2]
+			}
+		}
+	case "333": // RPL_TOPICWHOTIME: <me> <channel> <setter> <timestamp>
+		if len(args) > 3 {
+			if c, ok := t.channels[t.fold(args[1])]; ok {
+				c.topic.SetBy = args[2]
+				if ts, err := strconv.ParseInt(args[3], 10, 64); err == nil {
+					c.topic.SetAt = time.Unix(ts, 0)
+				}
+			}
+		}
+	case "353": // RPL_NAMREPLY: <me> <symbol> <channel> :<names>
+		if len(args) > 3 {
+			c, ok := t.channels[t.fold(args[2])]
+			if !ok {
+				return
+			}
+			if c.namesDone {
+				c.members = make(map[string]*Member)
+				c.namesDone = false
+			}
+			for _, name := range strings.Fields(args[3]) {
+				prefixes, n := t.splitPrefixes(name)
+				c.members[t.fold(n)] = &Member{Nick: n, Prefixes: t.sortPrefixes(prefixes)}
+			}
+		}
+	case "366": // RPL_ENDOFNAMES: <me> <channel> :End of /NAMES list
+		if len(args) > 1 {
+			if c, ok := t.channels[t.fold(args[1])]; ok {
+				c.namesDone = true
+			}
+		}
+	}
+}
+
+// trackAccount records the services account of the user, from the account-tag, the
+// extended-join & account-notify capabilities. "*" means not logged in, as does a message
+// without the tag once account-tag is enabled. Only the accounts of the users we share a
+// channel with are kept, as we wouldn't see the others quit or log out.
+func (t *tracker) trackAccount(e *Event, nick string, accountTag bool) {
+	account, ok := e.Tags["account"]
+	if !ok && accountTag {
+		account, ok = "", true
+	}
+	switch {
+	case e.Code == "JOIN" && len(e.Arguments) > 2:
+		account, ok = e.Arguments[1], true
+	case e.Code == "ACCOUNT" && len(e.Arguments) > 0:
+		account, ok = e.Arguments[0], true
+	case e.Code == "QUIT":
+		delete(t.accounts, t.fold(nick))
+		return
+	case e.Code == "NICK" && len(e.Arguments) > 0:
+		if a, known := t.accounts[t.fold(nick)]; known {
+			delete(t.accounts, t.fold(nick))
+			t.accounts[t.fold(e.Arguments[0])] = a
+		}
+		return
+	}
+
+	if !ok {
+		return
+	}
+	if account == "*" || account == "" {
+		delete(t.accounts, t.fold(nick))
+		return
+	}
+	if t.shared(nick) {
+		t.accounts[t.fold(nick)] = account
+	}
+}
+
+// leave removes the user from the channel, or the channel itself if we're the one leaving.
+// The accounts of the users we no longer share a channel with are forgotten, as we wouldn't
+// see them quit or log out.
+func (t *tracker) leave(name, nick, self string) {
+	c, ok := t.channels[t.fold(name)]
+	if !ok {
+		return
+	}
+
+	if t.support.EqualFold(nick, self) {
+		delete(t.channels, t.fold(name))
+		for _, m := range c.members {
+			t.forget(m.Nick)
+		}
+		return
+	}
+	delete(c.members, t.fold(nick))
+	t.forget(nick)
+}
+
+// forget drops the user's account if we don't share any channel with them.
+func (t *tracker) forget(nick string) {
+	if !t.shared(nick) {
+		delete(t.accounts, t.fold(nick))
+	}
+}
+
+// shared returns whether the user is in any of our channels.
+func (t *tracker) shared(nick string) bool {
+	for _, c := range t.channels {
+		if _, ok := c.members[t.fold(nick)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyModes applies a mode string like "+o-v+l nick nick 10" to the channel.
+func (t *tracker) applyModes(c *chanState, modes string, params []string) {
+	prefixModes, symbols := t.support.Prefix()
+	lists := t.support.ChanModes()[0]
+
+	for _, m := range t.support.ParseModes(modes, params) {
+		if p := strings.IndexByte(prefixModes, m.Mode); p != -1 {
+			member, ok := c.members[t.fold(m.Param)]
+			if !ok {
+				continue
+			}
+			symbol := string(symbols[p])
+			if m.Set {
+				member.Prefixes = t.sortPrefixes(member.Prefixes + symbol)
+			} else {
+				member.Prefixes = strings.Replace(member.Prefixes, symbol, "", -1)
+			}
+			continue
+		}
+		if strings.IndexByte(lists, m.Mode) != -1 {
+			continue // list modes aren't tracked
+		}
+
+		if m.Set {
+			c.modes[rune(m.Mode)] = m.Param
+		} else {
+			delete(c.modes, rune(m.Mode))
+		}
+	}
+}
+
+// snapshot returns a copy of the channel's state, safe to use outside the lock.
+func (c *chanState) snapshot(support *ISupport) *Channel {
+	s := &Channel{
+		Name:    c.name,
+		Topic:   c.topic,
+		Modes:   make(map[rune]string, len(c.modes)),
+		Members: make(map[string]Member, len(c.members)),
+		support: support,
+	}
+	for k, v := range c.modes {
+		s.Modes[k] = v
+	}
+	ops := support.OpPrefixes()
+	for _, m := range c.members {
+		member := *m
+		member.ops = ops
+		s.Members[m.Nick] = member
+	}
+	return s
+}
+
+// Channel returns a snapshot of the state of a channel we're in.
+func (irc *IRC) Channel(name string) (*Channel, bool) {
+	irc.tracker.RLock()
+	defer irc.tracker.RUnlock()
+
+	c, ok := irc.tracker.channels[irc.tracker.fold(name)]
+	if !ok {
+		return nil, false
+	}
+	return c.snapshot(irc.tracker.support), true
+}
+
+// Channels returns the names of the channels we're in, sorted.
+func (irc *IRC) Channels() []string {
+	irc.tracker.RLock()
+	defer irc.tracker.RUnlock()
+
+	var names []string
+	for _, c := range irc.tracker.channels {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CommonChannels returns the names of the channels we share with the user, sorted.
+func (irc *IRC) CommonChannels(nick string) []string {
+	irc.tracker.RLock()
+	defer irc.tracker.RUnlock()
+
+	var names []string
+	for _, c := range irc.tracker.channels {
+		if _, ok := c.members[irc.tracker.fold(nick)]; ok {
+			names = append(names, c.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Account returns the services account of the user, if logged in & known from the IRCv3
+// account-tag, extended-join or account-notify capabilities. Only the users sharing a
+// channel with us are known.
+func (irc *IRC) Account(nick string) string {
+	irc.tracker.RLock()
+	defer irc.tracker.RUnlock()
+
+	return irc.tracker.accounts[irc.tracker.fold(nick)]
+}
@@ -0,0 +1,129 @@
+package gophirc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_ChannelTracking(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Ignore: []string{"ignored"}})
+
+	for _, raw := range []string{
+		":gophirc!u@h JOIN #chan",
+		":irc.server.tld 332 gophirc #chan :The topic",
+		":irc.server.tld 333 gophirc #chan op!u@h 1500000000",
+		":irc.server.tld 353 gophirc = #chan :gophirc @op +voiced @+both!u@h",
+		":irc.server.tld 353 gophirc = #chan :ignored",
+		":irc.server.tld 366 gophirc #chan :End of /NAMES list.",
+		":irc.server.tld 324 gophirc #chan +ntk key",
+		":gophirc!u@h JOIN #other",
+		":op!u@h JOIN #other",
+		":someone!u@h JOIN #chan",
+		":op!u@h MODE #chan +o-v+lb someone voiced 10 *!*@bad.host",
+		":op!u@h MODE #chan -t+v-k someone key",
+		":both!u@h NICK Renamed",
+		":ignored!u@h QUIT :bye",
+		":op!u@h PART #other :bye",
+	} {
+		i.ReadEvent(raw)
+	}
+
+	c, ok := i.Channel("#CHAN")
+	if !ok {
+		t.Fatal("Channel #chan not tracked")
+	}
+	if c.Name != "#chan" {
+		t.Errorf("Expected name %q, got %q instead.", "#chan", c.Name)
+	}
+
+	expectedTopic := Topic{Text: "The topic", SetBy: "op!u@h", SetAt: time.Unix(1500000000, 0)}
+	if c.Topic != expectedTopic {
+		t.Errorf("Expected topic %+v, got %+v instead.", expectedTopic, c.Topic)
+	}
+
+	expectedModes := map[rune]string{'n': "", 'l': "10"}
+	if !reflect.DeepEqual(c.Modes, expectedModes) {
+		t.Errorf("Expected modes %q, got %q instead.", expectedModes, c.Modes)
+	}
+
+	expectedMembers := map[string]Member{
+		"gophirc": {Nick: "gophirc", ops: "@"},
+		"op":      {Nick: "op", Prefixes: "@", ops: "@"},
+		"voiced":  {Nick: "voiced", ops: "@"},
+		"Renamed": {Nick: "Renamed", Prefixes: "@+", ops: "@"},
+		"someone": {Nick: "someone", Prefixes: "@+", ops: "@"},
+	}
+	if !reflect.DeepEqual(c.Members, expectedMembers) {
+		t.Errorf("Expected members %+v, got %+v instead.", expectedMembers, c.Members)
+	}
+
+	if m, ok := c.Member("someone"); !ok || !m.IsOp() {
+		t.Errorf("Expected someone to be an op, got %+v", m)
+	}
+	if m, ok := c.Member("renamed"); !ok || !m.IsOp() || !m.IsVoiced() {
+		t.Errorf("Expected Renamed to be an op with voice, got %+v (%v)", m, ok)
+	}
+
+	if actual := i.Channels(); !reflect.DeepEqual(actual, []string{"#chan", "#other"}) {
+		t.Errorf("Expected channels %q, got %q instead.", []string{"#chan", "#other"}, actual)
+	}
+	if actual := i.CommonChannels("OP"); !reflect.DeepEqual(actual, []string{"#chan"}) {
+		t.Errorf("Expected common channels %q, got %q instead.", []string{"#chan"}, actual)
+	}
+
+	i.ReadEvent(":op!u@h KICK #chan gophirc :out")
+	if _, ok := i.Channel("#chan"); ok {
+		t.Error("Channel #chan should be forgotten after the kick")
+	}
+	if actual := i.CommonChannels("op"); actual != nil {
+		t.Errorf("Expected no common channels, got %q instead.", actual)
+	}
+}
+
+func TestIRC_ChannelNamesRefresh(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	for _, raw := range []string{
+		":gophirc!u@h JOIN #chan",
+		":irc.server.tld 353 gophirc = #chan :gophirc old",
+		":irc.server.tld 366 gophirc #chan :End of /NAMES list.",
+		":irc.server.tld 353 gophirc = #chan :gophirc @new",
+		":irc.server.tld 366 gophirc #chan :End of /NAMES list.",
+	} {
+		i.ReadEvent(raw)
+	}
+
+	c, _ := i.Channel("#chan")
+	expected := map[string]Member{"gophirc": {Nick: "gophirc", ops: "@"}, "new": {Nick: "new", Prefixes: "@", ops: "@"}}
+	if !reflect.DeepEqual(c.Members, expected) {
+		t.Errorf("Expected members %+v, got %+v instead.", expected, c.Members)
+	}
+
+	i.emit(EventConnected, "irc.server.tld:6667")
+	if actual := i.Channels(); actual != nil {
+		t.Errorf("Expected no channels after reconnecting, got %q instead.", actual)
+	}
+}
+
+func TestIRC_ChannelOpPrefixes(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	for _, raw := range []string{
+		":irc.server.tld 005 gophirc PREFIX=(Yohv)!@%+ :are supported by this server",
+		":gophirc!u@h JOIN #chan",
+		":irc.server.tld 353 gophirc = #chan :gophirc !owner %halfop",
+		":irc.server.tld 366 gophirc #chan :End of /NAMES list.",
+	} {
+		i.ReadEvent(raw)
+	}
+
+	c, _ := i.Channel("#chan")
+	for nick, expected := range map[string]bool{"gophirc": false, "owner": true, "halfop": false} {
+		if m, ok := c.Member(nick); !ok || m.IsOp() != expected {
+			t.Errorf("Expected %s to be an op: %v, got %+v (%v)", nick, expected, m, ok)
+		}
+	}
+}
@@ -7,13 +7,13 @@ import (
 	"github.com/vlad-s/gophirc/logger"
 )
 
-// SendRaw sends a raw string back to the server, appending a CR LF.
+// SendRaw queues a raw string to be sent back to the server, appending a CR LF.
 // It automatically strips carriage returns and line feeds from the string.
+// The lines are sent in order & rate limited, except for PONG and QUIT, which skip the queue.
 func (irc *IRC) SendRaw(s string) {
 	s = strings.Replace(s, "\r", "", -1)
 	s = strings.Replace(s, "\n", "", -1)
-	irc.raw <- s
-	fmt.Fprint(irc.conn, s+"\r\n")
+	irc.send(s)
 }
 
 // SendRawf is simply a wrapper for SendRaw & fmt.Sprintf.
@@ -23,21 +23,28 @@ func (irc *IRC) SendRawf(format string, args ...interface{}) {
 
 // pong sends a PONG command back to server. Used after receiving a PING.
 func (irc *IRC) pong(s string) {
-	irc.SendRawf("PONG %s", s)
+	irc.SendRawf("PONG :%s", s)
 }
 
-// Register sends the USER and NICK commands to the server, and sets the registered state.
+// Register starts the capability negotiation, sends the USER and NICK commands to the server,
+// and sets the registered state. The server completes the registration once we send CAP END.
+// If the nickname is rejected, the alternates are tried next, see CurrentNick.
 func (irc *IRC) Register() {
-	irc.SendRawf("USER %s 8 * %s", irc.Server.Username, irc.Server.Realname)
-	irc.SendRawf("NICK %s", irc.Server.Nickname)
+	irc.resetNick()
+	irc.negotiateCaps()
+	s := irc.Config()
+	irc.SendRawf("USER %s 8 * %s", s.Username, s.Realname)
+	irc.SendRawf("NICK %s", s.Nickname)
 
+	irc.mu.Lock()
 	irc.State.Registered = true
+	irc.mu.Unlock()
 	logger.Log.Infoln("Successfully registered on network")
 }
 
 // Identify sends the NickServ identify command to the server.
 func (irc *IRC) Identify() {
-	ns := irc.Server.NickservPassword
+	ns := irc.Config().NickservPassword
 	if ns == "" {
 		return
 	}
@@ -50,13 +57,16 @@ func (irc *IRC) Join(channel string) {
 }
 
 // Part sents a PART command to the server, requesting to part <channel>.
+// The messages still queued for <channel> are dropped.
 func (irc *IRC) Part(channel string) {
+	irc.DropPending(channel)
 	irc.SendRawf("PART %s", channel)
 }
 
-// PrivMsg sends a PRIVMSG command to the server.
+// PrivMsg sends a PRIVMSG command to the server. Long messages are split into multiple lines
+// to fit the line limit, and each line break starts a new line.
 func (irc *IRC) PrivMsg(replyTo, message string) {
-	irc.SendRawf("PRIVMSG %s :%s", replyTo, message)
+	irc.sendSplit("PRIVMSG", replyTo, "", "", message)
 }
 
 // PrivMsgf is simply a wrapper for Privmsg & fmt.Sprintf.
@@ -64,19 +74,25 @@ func (irc *IRC) PrivMsgf(replyTo, format string, args ...interface{}) {
 	irc.PrivMsg(replyTo, fmt.Sprintf(format, args...))
 }
 
-// Notice sends a NOTICE command to the server.
+// Notice sends a NOTICE command to the server, split like PrivMsg.
 func (irc *IRC) Notice(replyTo, message string) {
-	irc.SendRawf("NOTICE %s :%s", replyTo, message)
+	irc.sendSplit("NOTICE", replyTo, "", "", message)
 }
 
 // Action sends a PRIVMSG command to the server, mimicking the "/me" in IRC clients.
+// It's split like PrivMsg, every line being an action.
 func (irc *IRC) Action(replyTo, message string) {
-	irc.SendRawf("PRIVMSG %s :\001ACTION %s\001", replyTo, message)
+	irc.sendSplit("PRIVMSG", replyTo, "\001ACTION ", "\001", message)
 }
 
-// CTCP sends a notice to the server, with CTCP flag 0x01 appended & prepended.
+// CTCP sends a CTCP reply, a notice with the CTCP flag 0x01 appended & prepended.
+// See CTCPRequest to send a request.
 func (irc *IRC) CTCP(replyTo, ctcp, message string) {
-	irc.Notice(replyTo, fmt.Sprintf("\001%s %s\001", ctcp, message))
+	prefix := "\001" + ctcp
+	if message != "" {
+		prefix += " "
+	}
+	irc.sendSplit("NOTICE", replyTo, prefix, "\001", message)
 }
 
 // Kick sends a KICK command to the server, requesting to kick <nick> from <channel> using <message>.
@@ -3,7 +3,11 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/vlad-s/gophirc/logger"
@@ -12,19 +16,114 @@ import (
 // Server contains the information used to a server, namely the address
 // and the port, along with the client's details, such as nickname, username, realname,
 // NickServ password, channels to join, hardcoded admins & ignored users.
+// Setting TLS connects over TLS, optionally presenting a client certificate for CertFP.
+// Capabilities lists the IRCv3 capabilities requested from the server, if available.
+// SASL authenticates during registration with the PLAIN or EXTERNAL (CertFP) mechanism.
+// Admins, Ignore & the Roles' masks are "nick!user@host" wildcard masks, or "$a:account" to match
+// a services account; a bare nickname still works, but anyone can take it.
 type Server struct {
 	Address string `json:"address"`
 	Port    uint16 `json:"port"`
 
+	TLS           bool   `json:"tls"`
+	TLSCAFile     string `json:"tls_ca_file"`
+	TLSServerName string `json:"tls_server_name"`
+	TLSSkipVerify bool   `json:"tls_skip_verify"`
+	TLSCertFile   string `json:"tls_cert_file"`
+	TLSKeyFile    string `json:"tls_key_file"`
+
+	// Proxy is the "socks5://[user:password@]host:port" or "http://[user:password@]host:port"
+	// proxy the connections go through.
+	Proxy     string    `json:"proxy"`
+	WebSocket WebSocket `json:"websocket"`
+
 	Nickname string `json:"nickname"`
 	Username string `json:"username"`
 	Realname string `json:"realname"`
 
+	AltNicknames []string `json:"alt_nicknames"` // tried in order if the nickname is taken
+
+	QuitMessage string `json:"quit_message"`
+
 	NickservPassword string `json:"nickserv_password"`
 
+	SASLMechanism string `json:"sasl_mechanism"`
+	SASLUsername  string `json:"sasl_username"`
+	SASLPassword  string `json:"sasl_password"`
+	SASLRequired  bool   `json:"sasl_required"`
+
+	defaultSASLUsername bool // SASLUsername was set to the nickname by Check
+
+	Capabilities []string `json:"capabilities"`
+
+	Reconnect Reconnect `json:"reconnect"`
+	Flood     Flood     `json:"flood"`
+	Regain    Regain    `json:"regain"`
+	KeepAlive KeepAlive `json:"keepalive"`
+
+	// Workers is the number of goroutines running the callbacks. The events on the same
+	// channel, or from the same user, are always handled in order.
+	Workers int `json:"workers"`
+
+	// Record is a file every line received & sent is appended to, with its time, to be fed
+	// back to a bot with a Replayer. The file holds the passwords sent, keep it private.
+	Record string `json:"record"`
+
 	Channels []string `json:"channels"`
 	Admins   []string `json:"admins"`
 	Ignore   []string `json:"ignore"`
+
+	Roles map[string]*Role `json:"roles"`
+}
+
+// Role is a named set of permissions, given to the users matching any of its masks.
+type Role struct {
+	Masks       []string `json:"masks"`
+	Permissions []string `json:"permissions"`
+}
+
+// WebSocket connects to the server over WebSocket, at the "ws://" or "wss://" URL instead of the
+// address & port. Binary requests the binary.ircv3.net subprotocol, text.ircv3.net otherwise.
+type WebSocket struct {
+	URL    string `json:"url"`
+	Binary bool   `json:"binary"`
+}
+
+// Reconnect dictates how a dropped connection is re-established. The delay between the attempts
+// starts at Delay and doubles with every attempt up to MaxDelay, being randomly shortened by up to
+// Jitter (a fraction between 0 and 1). The attempts cycle through the server's address and the
+// Alternates ("host" or "host:port"), MaxAttempts being unlimited if 0.
+type Reconnect struct {
+	Disabled    bool     `json:"disabled"`
+	Delay       Duration `json:"delay"`
+	MaxDelay    Duration `json:"max_delay"`
+	Jitter      float64  `json:"jitter"`
+	MaxAttempts int      `json:"max_attempts"`
+	Alternates  []string `json:"alternates"`
+}
+
+// Flood dictates the rate limiting of the outgoing lines: a burst of Burst lines, then one line
+// every Interval. Disabled sends the lines as soon as possible.
+type Flood struct {
+	Disabled bool     `json:"disabled"`
+	Burst    int      `json:"burst"`
+	Interval Duration `json:"interval"`
+}
+
+// KeepAlive dictates how a stalled connection is detected: a PING is sent after Interval without
+// receiving anything, and the connection is dropped & re-established after Timeout.
+type KeepAlive struct {
+	Disabled bool     `json:"disabled"`
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+}
+
+// Regain dictates how the nickname is taken back after connecting with an alternate one:
+// every Interval, when its holder is seen leaving it, and with MONITOR if the server supports it.
+// Once regained, the bot identifies to NickServ again.
+type Regain struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"`
 }
 
 // Config dictates the way the config file should be arranged.
@@ -39,21 +138,103 @@ func (c *Config) Check() error {
 	logger.Log.Infoln("Checking the config for errors")
 
 	for name, server := range c.Servers {
-		if server.Address == "" {
-			return fmt.Errorf("%s: Server address not specified", name)
+		if server.WebSocket.URL != "" {
+			if err := checkURL(server.WebSocket.URL, "ws", "wss"); err != nil {
+				return fmt.Errorf("%s: Invalid WebSocket URL: %s", name, err)
+			}
+		} else {
+			if server.Address == "" {
+				return fmt.Errorf("%s: Server address not specified", name)
+			}
+
+			if server.Port == 0 {
+				return fmt.Errorf("%s: Server port not specified", name)
+			}
 		}
 
-		if server.Port == 0 {
-			return fmt.Errorf("%s: Server port not specified", name)
+		if server.Proxy != "" {
+			if err := checkURL(server.Proxy, "socks5", "socks5h", "http"); err != nil {
+				return fmt.Errorf("%s: Invalid proxy: %s", name, err)
+			}
+		}
+
+		if (server.TLSCertFile == "") != (server.TLSKeyFile == "") {
+			return fmt.Errorf("%s: TLS client certificate & key must be specified together", name)
 		}
 
 		if server.Nickname == "" {
 			server.Nickname = "gophirc"
 		}
 
+		if err := server.Reconnect.check(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		if server.Flood.Burst < 0 || server.Flood.Interval < 0 {
+			return fmt.Errorf("%s: Flood burst & interval can't be negative", name)
+		}
+		if server.Flood.Burst == 0 {
+			server.Flood.Burst = 5
+		}
+		if server.Flood.Interval == 0 {
+			server.Flood.Interval = Duration(2 * time.Second)
+		}
+
+		if server.KeepAlive.Interval < 0 || server.KeepAlive.Timeout < 0 {
+			return fmt.Errorf("%s: Keep-alive interval & timeout can't be negative", name)
+		}
+		if server.KeepAlive.Interval == 0 {
+			server.KeepAlive.Interval = Duration(time.Minute)
+		}
+		if server.KeepAlive.Timeout == 0 {
+			server.KeepAlive.Timeout = Duration(3 * time.Minute)
+		}
+		if server.KeepAlive.Timeout <= server.KeepAlive.Interval {
+			return fmt.Errorf("%s: Keep-alive timeout must be longer than the interval", name)
+		}
+
+		if server.Workers < 0 {
+			return fmt.Errorf("%s: Number of workers can't be negative", name)
+		}
+		if server.Workers == 0 {
+			server.Workers = 4
+		}
+
 		if len(server.Nickname) > 0 && len(server.Nickname) < 3 {
 			return fmt.Errorf("%s: Nickname is too short", name)
 		}
+		for _, nick := range server.AltNicknames {
+			if strings.TrimSpace(nick) == "" || strings.ContainsAny(nick, " ,*?!@") {
+				return fmt.Errorf("%s: Invalid alternate nickname %q", name, nick)
+			}
+		}
+
+		if server.Regain.Interval < 0 {
+			return fmt.Errorf("%s: Regain interval can't be negative", name)
+		}
+		if server.Regain.Interval == 0 {
+			server.Regain.Interval = Duration(time.Minute)
+		}
+
+		server.SASLMechanism = strings.ToUpper(server.SASLMechanism)
+		switch server.SASLMechanism {
+		case "":
+		case "PLAIN":
+			if server.SASLPassword == "" {
+				return fmt.Errorf("%s: SASL PLAIN requires a password", name)
+			}
+		case "EXTERNAL":
+			if server.TLSCertFile == "" {
+				return fmt.Errorf("%s: SASL EXTERNAL requires a TLS client certificate", name)
+			}
+		default:
+			return fmt.Errorf("%s: Unknown SASL mechanism %q", name, server.SASLMechanism)
+		}
+
+		if server.SASLUsername == "" {
+			server.SASLUsername = server.Nickname
+			server.defaultSASLUsername = true
+		}
 
 		if server.Username == "" {
 			server.Username = "gophirc"
@@ -62,15 +243,83 @@ func (c *Config) Check() error {
 		if server.Realname == "" {
 			server.Realname = "gophirc"
 		}
+
+		for role, r := range server.Roles {
+			if role == "" || r == nil {
+				return fmt.Errorf("%s: Invalid role %q", name, role)
+			}
+			for _, mask := range r.Masks {
+				if strings.TrimSpace(mask) == "" {
+					return fmt.Errorf("%s: Empty mask in role %q", name, role)
+				}
+			}
+		}
+
+		if server.QuitMessage == "" {
+			server.QuitMessage = "Leaving"
+		}
 	}
 
 	return nil
 }
 
-var conf *Config
+// check validates the reconnect policy & sets the default delays.
+func (r *Reconnect) check() error {
+	if r.Delay == 0 {
+		r.Delay = Duration(2 * time.Second)
+	}
+	if r.MaxDelay == 0 {
+		r.MaxDelay = Duration(5 * time.Minute)
+	}
+	if r.MaxDelay < r.Delay {
+		return errors.New("Reconnect max delay is shorter than the delay")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return errors.New("Reconnect jitter must be between 0 and 1")
+	}
+	if r.MaxAttempts < 0 {
+		return errors.New("Reconnect max attempts can't be negative")
+	}
+	return nil
+}
+
+var (
+	conf *Config
+	mu   sync.Mutex // guards conf, replaced by Reload
+)
+
+// checkURL returns an error if the URL can't be parsed, has no host, or has another scheme.
+func checkURL(raw string, schemes ...string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("No host in %q", raw)
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("Scheme of %q must be one of %s", raw, strings.Join(schemes, ", "))
+}
 
 // Parse reads and parses the config from the specified path.
 func Parse(path string) (*Config, error) {
+	c, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	conf = c
+	return conf, nil
+}
+
+// read reads and parses the config from the path, without touching the parsed one.
+func read(path string) (*Config, error) {
 	logger.Log.Infof("Reading %q", path)
 
 	f, err := os.Open(path)
@@ -79,16 +328,19 @@ func Parse(path string) (*Config, error) {
 	}
 	defer f.Close()
 
-	conf = new(Config)
-	err = json.NewDecoder(f).Decode(conf)
+	c := new(Config)
+	err = json.NewDecoder(f).Decode(c)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error decoding the config file")
 	}
-	return conf, nil
+	return c, nil
 }
 
 // Get returns the parsed config, or a new Config{} if it's nil.
 func Get() *Config {
+	mu.Lock()
+	defer mu.Unlock()
+
 	if conf == nil {
 		conf = &Config{
 			Servers: make(map[string]*Server),
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration which can be decoded from a JSON string such as "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration from a string, using time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "Duration must be a string")
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrap(err, "Error parsing the duration")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json       string
+		expected   time.Duration
+		shouldFail bool
+	}{
+		{`"1m30s"`, 90 * time.Second, false},
+		{`"250ms"`, 250 * time.Millisecond, false},
+		{`"forever"`, 0, true},
+		{`30`, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.json, func(t *testing.T) {
+			var d Duration
+			err := json.Unmarshal([]byte(test.json), &d)
+			if (test.shouldFail && err == nil) || (!test.shouldFail && err != nil) {
+				t.Fatalf("Duration %s - should fail: %v, got err %q\n", test.json, test.shouldFail, err)
+			}
+			if time.Duration(d) != test.expected {
+				t.Errorf("Duration %s - expected %v, got %v\n", test.json, test.expected, time.Duration(d))
+			}
+		})
+	}
+}
+
+func TestDuration_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(Duration(90 * time.Second))
+	if err != nil || string(b) != `"1m30s"` {
+		t.Errorf("Expected %q, got %q (%v)\n", `"1m30s"`, b, err)
+	}
+}
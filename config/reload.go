@@ -0,0 +1,188 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// ServerDiff is what changed between two configs of a server, see Server.Diff.
+type ServerDiff struct {
+	Join []string // channels added
+	Part []string // channels removed
+
+	Nickname  bool // the nickname changed
+	ACL       bool // the admins, the ignored users or the roles changed
+	Reconnect bool // the settings used to connect & register changed
+}
+
+// Empty returns whether nothing applied live changed.
+func (d ServerDiff) Empty() bool {
+	return len(d.Join) == 0 && len(d.Part) == 0 && !d.Nickname && !d.ACL && !d.Reconnect
+}
+
+// Diff returns what changed from the server's config to the new one, both having been checked.
+// The channel names are compared as is, the server's case mapping being unknown here.
+// Connecting & registering again is needed when the address, the transport (TLS, proxy,
+// WebSocket), the username, the realname, the SASL settings or the capabilities change.
+func (s *Server) Diff(n *Server) ServerDiff {
+	return ServerDiff{
+		Join:      missing(n.Channels, s.Channels),
+		Part:      missing(s.Channels, n.Channels),
+		Nickname:  s.Nickname != n.Nickname,
+		ACL:       !equal(s.Admins, n.Admins) || !equal(s.Ignore, n.Ignore) || !equalRoles(s.Roles, n.Roles),
+		Reconnect: !reflect.DeepEqual(s.connection(), n.connection()) || !equal(s.Capabilities, n.Capabilities),
+	}
+}
+
+// connection returns the settings used to connect & register, the SASL ones only if enabled.
+// The SASL username is left out when Check defaulted it to the nickname, a new nickname being
+// asked for live.
+func (s *Server) connection() Server {
+	c := Server{
+		Address: s.Address,
+		Port:    s.Port,
+
+		TLS:           s.TLS,
+		TLSCAFile:     s.TLSCAFile,
+		TLSServerName: s.TLSServerName,
+		TLSSkipVerify: s.TLSSkipVerify,
+		TLSCertFile:   s.TLSCertFile,
+		TLSKeyFile:    s.TLSKeyFile,
+
+		Proxy:     s.Proxy,
+		WebSocket: s.WebSocket,
+
+		Username: s.Username,
+		Realname: s.Realname,
+	}
+	if s.SASLMechanism != "" {
+		c.SASLMechanism = s.SASLMechanism
+		if !s.defaultSASLUsername {
+			c.SASLUsername = s.SASLUsername
+		}
+		c.SASLPassword = s.SASLPassword
+		c.SASLRequired = s.SASLRequired
+	}
+	return c
+}
+
+// missing returns the channels of a which aren't in b.
+func missing(a, b []string) []string {
+	var m []string
+	for _, c := range a {
+		found := false
+		for _, v := range b {
+			if c == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			m = append(m, c)
+		}
+	}
+	return m
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalRoles(a, b map[string]*Role) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, r := range a {
+		if !reflect.DeepEqual(r, b[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reload reads, parses & checks the config from the path. It replaces the config returned by
+// Get only if it's valid, the previous one being kept otherwise.
+func Reload(path string) (*Config, error) {
+	c, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Check(); err != nil {
+		return nil, errors.Wrap(err, "Error checking the config")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	conf = c
+	return conf, nil
+}
+
+// Watch reloads the config from the path on SIGHUP, and when the file changes if interval isn't
+// 0, checking it every interval. Each valid config is passed to apply; the invalid ones are
+// logged & skipped. Watch blocks until the context is cancelled.
+func Watch(ctx context.Context, path string, interval time.Duration, apply func(*Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changed <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		changed = t.C
+	}
+
+	last := stat(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Log.Infoln("Received SIGHUP, reloading the config")
+		case <-changed:
+			s := stat(path)
+			if s.modified.Equal(last.modified) && s.size == last.size {
+				continue
+			}
+			last = s
+			logger.Log.Infoln("Config file changed, reloading it")
+		}
+
+		c, err := Reload(path)
+		if err != nil {
+			logger.Log.Errorln("Can't reload the config:", err)
+			continue
+		}
+		apply(c)
+	}
+}
+
+// fileState is what tells a file changed.
+type fileState struct {
+	modified time.Time
+	size     int64
+}
+
+// stat returns the file's state, or the zero value if it can't be read.
+func stat(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{fi.ModTime(), fi.Size()}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServer_Diff(t *testing.T) {
+	base := func() *Server {
+		return &Server{
+			Address:      "irc.server.tld",
+			Port:         6697,
+			Nickname:     "gophirc",
+			SASLUsername: "gophirc",
+			Channels:     []string{"#first", "#second"},
+			Admins:       []string{"admin!*@*"},
+			Roles:        map[string]*Role{"trusted": {Masks: []string{"*!*@trusted"}}},
+		}
+	}
+	tests := []struct {
+		name     string
+		change   func(s *Server)
+		expected ServerDiff
+	}{
+		{"nothing", func(s *Server) {}, ServerDiff{}},
+		{"live only", func(s *Server) { s.QuitMessage, s.Flood.Burst = "Bye", 10 }, ServerDiff{}},
+		{"channels", func(s *Server) { s.Channels = []string{"#SECOND", "#third"} },
+			ServerDiff{Join: []string{"#SECOND", "#third"}, Part: []string{"#first", "#second"}}},
+		{"nickname", func(s *Server) { s.Nickname, s.SASLUsername = "other", "other" }, ServerDiff{Nickname: true}},
+		{"admins", func(s *Server) { s.Admins = nil }, ServerDiff{ACL: true}},
+		{"ignore", func(s *Server) { s.Ignore = []string{"spammer"} }, ServerDiff{ACL: true}},
+		{"roles", func(s *Server) { s.Roles["trusted"] = &Role{Permissions: []string{"say"}} }, ServerDiff{ACL: true}},
+		{"address", func(s *Server) { s.Address = "irc.other.tld" }, ServerDiff{Reconnect: true}},
+		{"tls", func(s *Server) { s.TLS = true }, ServerDiff{Reconnect: true}},
+		{"websocket", func(s *Server) { s.WebSocket.URL = "wss://irc.server.tld" }, ServerDiff{Reconnect: true}},
+		{"capabilities", func(s *Server) { s.Capabilities = []string{"away-notify"} }, ServerDiff{Reconnect: true}},
+		{"sasl", func(s *Server) { s.SASLMechanism, s.SASLPassword = "PLAIN", "secret" }, ServerDiff{Reconnect: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := base()
+			test.change(n)
+			if d := base().Diff(n); !reflect.DeepEqual(d, test.expected) {
+				t.Errorf("Expected %+v, got %+v instead.", test.expected, d)
+			}
+		})
+	}
+}
+
+func TestServer_DiffSASLUsername(t *testing.T) {
+	check := func(nick, username string) *Server {
+		s := &Server{
+			Address: "irc.server.tld", Port: 6667, Nickname: nick,
+			SASLMechanism: "PLAIN", SASLUsername: username, SASLPassword: "secret",
+		}
+		if err := (&Config{Servers: map[string]*Server{"first": s}}).Check(); err != nil {
+			t.Fatal("Error checking the config", err)
+		}
+		return s
+	}
+
+	if d := check("gophirc", "").Diff(check("other", "")); !reflect.DeepEqual(d, ServerDiff{Nickname: true}) {
+		t.Errorf("Defaulted SASL username: expected only the nickname to change, got %+v instead.", d)
+	}
+	if d := check("gophirc", "account").Diff(check("gophirc", "other")); !d.Reconnect {
+		t.Errorf("Explicit SASL username: expected a reconnect, got %+v instead.", d)
+	}
+}
+
+// writeConfig writes a config with the server's nickname to the path.
+func writeConfig(t *testing.T, path, nick string) {
+	config := `{"servers": {"first": {"address": "irc.server.tld", "port": 6667, "nickname": "` + nick + `"}}}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal("Can't write the config", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, "reloaded")
+
+	c, err := Reload(path)
+	if err != nil {
+		t.Fatal("Can't reload the config", err)
+	}
+	if Get() != c {
+		t.Error("Expected the reloaded config to replace the parsed one.")
+	}
+	if s := c.Servers["first"]; s.Nickname != "reloaded" || s.QuitMessage != "Leaving" {
+		t.Errorf("Expected a checked config, got %+v instead.", s)
+	}
+
+	// an invalid config keeps the previous one
+	writeConfig(t, path, "no")
+	if _, err := Reload(path); err == nil {
+		t.Error("Expected an error reloading an invalid config, got nil instead.")
+	}
+	if Get() != c {
+		t.Error("Expected the previous config to be kept.")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, "first")
+
+	reloads := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, path, 10*time.Millisecond, func(c *Config) {
+			reloads <- c.Servers["first"].Nickname
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	expect := func(nick string) {
+		t.Helper()
+		select {
+		case n := <-reloads:
+			if n != nick {
+				t.Errorf("Expected %q, got %q instead.", nick, n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a reload with %q, got nothing.", nick)
+		}
+	}
+
+	// the file changing, then a SIGHUP, once the watcher is surely listening for it
+	time.Sleep(20 * time.Millisecond)
+	writeConfig(t, path, "second_")
+	expect("second_")
+	p, _ := os.FindProcess(os.Getpid())
+	p.Signal(syscall.SIGHUP)
+	expect("second_")
+
+	// an invalid config isn't applied
+	writeConfig(t, path, "no")
+	select {
+	case n := <-reloads:
+		t.Errorf("Expected no reload, got %q instead.", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
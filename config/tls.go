@@ -0,0 +1,45 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig builds the *tls.Config used to connect to the server, loading the
+// custom CA bundle and the client certificate (used for CertFP) if specified.
+func (s *Server) TLSConfig() (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName:         s.Address,
+		InsecureSkipVerify: s.TLSSkipVerify,
+	}
+
+	if s.TLSServerName != "" {
+		c.ServerName = s.TLSServerName
+	}
+
+	if s.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(s.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading the CA file")
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %q", s.TLSCAFile)
+		}
+	}
+
+	if s.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLSCertFile, s.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error loading the client certificate")
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestServer_TLSConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		server     Server
+		shouldFail bool
+	}{
+		{"defaults", Server{Address: "irc.server.tld"}, false},
+		{"ca", Server{Address: "localhost", TLSCAFile: "testdata/cert.pem"}, false},
+		{"inexistent ca", Server{Address: "localhost", TLSCAFile: "testdata/inexistent.pem"}, true},
+		{"invalid ca", Server{Address: "localhost", TLSCAFile: "config.json.example"}, true},
+		{"client cert", Server{Address: "localhost", TLSCertFile: "testdata/cert.pem", TLSKeyFile: "testdata/key.pem"}, false},
+		{"missing key", Server{Address: "localhost", TLSCertFile: "testdata/cert.pem"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.server.TLSConfig()
+			if (test.shouldFail && err == nil) || (!test.shouldFail && err != nil) {
+				t.Errorf("Server %+v - should fail: %v, got err %q\n", test.server, test.shouldFail, err)
+			}
+		})
+	}
+}
+
+func TestServer_TLSConfigValues(t *testing.T) {
+	s := Server{
+		Address:       "127.0.0.1",
+		TLSServerName: "localhost",
+		TLSSkipVerify: true,
+		TLSCertFile:   "testdata/cert.pem",
+		TLSKeyFile:    "testdata/key.pem",
+	}
+
+	c, err := s.TLSConfig()
+	if err != nil {
+		t.Fatal("Error building the TLS config", err)
+	}
+
+	if c.ServerName != "localhost" {
+		t.Errorf("Wrong server name, got %q, expected %q\n", c.ServerName, "localhost")
+	}
+
+	if !c.InsecureSkipVerify {
+		t.Error("Skip verify should be true")
+	}
+
+	if len(c.Certificates) != 1 {
+		t.Errorf("Expected 1 client certificate, got %d\n", len(c.Certificates))
+	}
+}
@@ -0,0 +1,194 @@
+package gophirc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// SourceURL is the framework's repository, sent as the default CTCP SOURCE reply.
+const SourceURL = "https://github.com/vlad-s/gophirc"
+
+// The CTCP replies we send are limited to a burst of ctcpBurst, then one every ctcpInterval,
+// so we can't be used to flood someone, or to get ourselves kicked for flooding.
+const (
+	ctcpBurst    = 3
+	ctcpInterval = 2 * time.Second
+)
+
+// CTCPEvent is a CTCP request, received in a PRIVMSG, or a CTCP reply, received in a NOTICE.
+// The requests are dispatched with CTCPCode, e.g. "ctcp:VERSION", and the replies with
+// CTCPReplyCode, e.g. "ctcp-reply:VERSION".
+type CTCPEvent struct {
+	*Event
+	Verb   string
+	Params string // the text after the verb
+	Reply  bool
+}
+
+// CTCPHandler returns the reply to a CTCP request, or "" not to reply.
+type CTCPHandler func(*CTCPEvent) string
+
+// ctcp holds the handlers answering the CTCP requests & the limit on the replies.
+type ctcp struct {
+	sync.Mutex
+	handlers map[string]CTCPHandler
+	limit    *bucket
+}
+
+// CTCPCode returns the code the requests with the verb are dispatched with. It contains a
+// colon, so a request can't pass for a command received from the server, e.g. a KICK.
+func CTCPCode(verb string) string {
+	return "ctcp:" + strings.ToUpper(verb)
+}
+
+// CTCPReplyCode returns the code the replies to the verb are dispatched with. It contains a
+// colon, so it can't clash with the commands received from the server.
+func CTCPReplyCode(verb string) string {
+	return "ctcp-reply:" + strings.ToUpper(verb)
+}
+
+// AsCTCP returns the event as a CTCPEvent, false if it isn't a CTCP request or reply.
+func AsCTCP(e *Event) (*CTCPEvent, bool) {
+	if !e.CTCP {
+		return nil, false
+	}
+
+	reply := strings.HasPrefix(e.Code, CTCPReplyCode(""))
+	verb := strings.TrimPrefix(strings.TrimPrefix(e.Code, CTCPReplyCode("")), CTCPCode(""))
+	c := &CTCPEvent{Event: e, Verb: verb, Reply: reply}
+	if fields := strings.SplitN(e.Message, " ", 2); len(fields) > 1 {
+		c.Params = fields[1]
+	}
+	return c, true
+}
+
+// parseCTCP rewrites a CTCP request or reply into an event with CTCPCode as its code, or
+// CTCPReplyCode for the replies, the CTCP parameters as its arguments.
+func parseCTCP(e *Event, reply bool) {
+	message := strings.Trim(e.Arguments[1], "\001")
+	messageArgs := strings.Split(message, " ")
+
+	e.CTCP = true
+	e.Code = CTCPCode(messageArgs[0])
+	if reply {
+		e.Code = CTCPReplyCode(messageArgs[0])
+	}
+	e.Arguments = messageArgs[1:]
+	e.Message = strings.TrimSpace(message)
+}
+
+// defaultCTCPHandlers returns the handlers answering VERSION, PING, TIME, CLIENTINFO & SOURCE.
+func (irc *IRC) defaultCTCPHandlers() map[string]CTCPHandler {
+	return map[string]CTCPHandler{
+		"VERSION": func(*CTCPEvent) string { return "gophirc - " + SourceURL },
+		"PING":    func(e *CTCPEvent) string { return e.Params },
+		"TIME":    func(*CTCPEvent) string { return time.Now().Format(time.RFC1123Z) },
+		"SOURCE":  func(*CTCPEvent) string { return SourceURL },
+		"CLIENTINFO": func(*CTCPEvent) string {
+			return strings.Join(irc.CTCPVerbs(), " ")
+		},
+	}
+}
+
+// HandleCTCP sets the handler answering the CTCP requests with the verb, replacing the default
+// one if any. A nil handler stops answering the verb.
+func (irc *IRC) HandleCTCP(verb string, h CTCPHandler) {
+	irc.ctcp.Lock()
+	defer irc.ctcp.Unlock()
+
+	if h == nil {
+		delete(irc.ctcp.handlers, strings.ToUpper(verb))
+		return
+	}
+	irc.ctcp.handlers[strings.ToUpper(verb)] = h
+}
+
+// CTCPVerbs returns the verbs we answer, ACTION included, sorted; the CLIENTINFO reply.
+func (irc *IRC) CTCPVerbs() []string {
+	irc.ctcp.Lock()
+	defer irc.ctcp.Unlock()
+
+	verbs := []string{"ACTION"}
+	for v := range irc.ctcp.handlers {
+		verbs = append(verbs, v)
+	}
+	sort.Strings(verbs)
+	return verbs
+}
+
+// ctcpMiddleware answers the CTCP requests sent to us, before passing them on to the callbacks.
+func (irc *IRC) ctcpMiddleware(e *Event, next func(*Event)) {
+	if c, ok := AsCTCP(e); ok && !c.Reply && e.User != nil {
+		irc.answerCTCP(c)
+	}
+	next(e)
+}
+
+// answerCTCP replies to the request with its handler, if any & if the limit allows it.
+func (irc *IRC) answerCTCP(e *CTCPEvent) {
+	irc.ctcp.Lock()
+	h, ok := irc.ctcp.handlers[strings.ToUpper(e.Verb)]
+	irc.ctcp.Unlock()
+	if !ok {
+		return
+	}
+
+	reply := h(e)
+	if reply == "" {
+		return
+	}
+
+	irc.ctcp.Lock()
+	limited := irc.ctcp.limit.wait() > 0
+	irc.ctcp.Unlock()
+	if limited {
+		logger.Log.WithFields(logger.Fields(map[string]interface{}{
+			"user": e.User.Nick, "verb": e.Verb,
+		})).Debugln("Too many CTCP requests, not replying")
+		return
+	}
+
+	irc.CTCP(e.User.Nick, e.Verb, reply)
+}
+
+// CTCPRequest sends a CTCP request to the nick & waits for its reply, returning the reply's
+// parameters. If the nick isn't online, it returns a ReplyError with the code 401.
+func (irc *IRC) CTCPRequest(ctx context.Context, nick, verb, params string) (string, error) {
+	verb = strings.ToUpper(verb)
+	request := "\001" + verb
+	if params != "" {
+		request += " " + params
+	}
+
+	events, err := irc.Query(ctx, Query{
+		Command: "PRIVMSG " + nick + " :" + request + "\001",
+		End:     []string{CTCPReplyCode(verb)},
+		Errors:  []string{"401"},
+		Match: func(e *Event) bool {
+			if e.Code == "401" {
+				return irc.argument(e, 1, nick)
+			}
+			return e.User != nil && irc.EqualFold(e.User.Nick, nick)
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range events {
+		if c, ok := AsCTCP(e); ok {
+			return c.Params, nil
+		}
+	}
+	return "", nil
+}
+
+func newCTCPLimit() *bucket {
+	return newBucket(config.Flood{Burst: ctcpBurst, Interval: config.Duration(ctcpInterval)})
+}
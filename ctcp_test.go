@@ -0,0 +1,180 @@
+package gophirc
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_ParseCTCP(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	tests := []struct {
+		raw      string
+		code     string
+		expected *CTCPEvent
+	}{
+		{":a!b@c PRIVMSG gophirc :\001VERSION\001", "ctcp:VERSION", &CTCPEvent{Verb: "VERSION"}},
+		{":a!b@c PRIVMSG gophirc :\001PING 123 456\001", "ctcp:PING", &CTCPEvent{Verb: "PING", Params: "123 456"}},
+		{":a!b@c NOTICE gophirc :\001VERSION someclient 1.0\001", "ctcp-reply:VERSION", &CTCPEvent{Verb: "VERSION", Params: "someclient 1.0", Reply: true}},
+		{":a!b@c NOTICE gophirc :not a ctcp", "NOTICE", nil},
+		{":a!b@c PRIVMSG gophirc :hi", "PRIVMSG", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			e, _ := i.ParseToEvent(test.raw)
+			if e.Code != test.code {
+				t.Errorf("Expected code %q, got %q instead.", test.code, e.Code)
+			}
+
+			c, ok := AsCTCP(e)
+			if ok != (test.expected != nil) {
+				t.Fatalf("Expected a CTCP: %v, got %v instead.", test.expected != nil, ok)
+			}
+			if !ok {
+				return
+			}
+			c.Event = nil
+			if !reflect.DeepEqual(c, test.expected) {
+				t.Errorf("Expected %+v, got %+v instead.", test.expected, c)
+			}
+		})
+	}
+}
+
+func TestIRC_DefaultCTCPReplies(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc", Ignore: []string{"ignored"}})
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected string // prefix of the reply, empty for none
+	}{
+		{"version", ":user!u@h PRIVMSG gophirc :\001VERSION\001", "NOTICE user :\001VERSION gophirc - " + SourceURL + "\001"},
+		{"ping", ":user!u@h PRIVMSG gophirc :\001PING 1234\001", "NOTICE user :\001PING 1234\001"},
+		{"time", ":user!u@h PRIVMSG #chan :\001TIME\001", "NOTICE user :\001TIME "},
+		{"action", ":user!u@h PRIVMSG #chan :\001ACTION waves\001", ""},
+		{"unknown", ":user!u@h PRIVMSG gophirc :\001FINGER\001", ""},
+		{"reply", ":user!u@h NOTICE gophirc :\001VERSION someclient\001", ""},
+		{"ignored", ":ignored!u@h PRIVMSG gophirc :\001VERSION\001", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i.ctcp.limit = newCTCPLimit()
+			i.ReadEvent(test.raw)
+			if test.expected == "" {
+				expectNoLines(t, lines)
+				return
+			}
+			if l := <-lines; !strings.HasPrefix(l, test.expected) {
+				t.Errorf("Expected %q, got %q instead.", test.expected, l)
+			}
+		})
+	}
+}
+
+func TestIRC_HandleCTCP(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	i.HandleCTCP("version", func(*CTCPEvent) string { return "mybot 1.0" })
+	i.HandleCTCP("FINGER", func(e *CTCPEvent) string { return "hi " + e.User.Nick })
+	i.HandleCTCP("TIME", nil)
+
+	expected := []string{"ACTION", "CLIENTINFO", "FINGER", "PING", "SOURCE", "VERSION"}
+	if verbs := i.CTCPVerbs(); !reflect.DeepEqual(verbs, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, verbs)
+	}
+
+	i.ReadEvent(":user!u@h PRIVMSG gophirc :\001VERSION\001")
+	i.ReadEvent(":user!u@h PRIVMSG gophirc :\001FINGER\001")
+	i.ReadEvent(":user!u@h PRIVMSG gophirc :\001CLIENTINFO\001")
+	expectLines(t, lines,
+		"NOTICE user :\001VERSION mybot 1.0\001",
+		"NOTICE user :\001FINGER hi user\001",
+		"NOTICE user :\001CLIENTINFO ACTION CLIENTINFO FINGER PING SOURCE VERSION\001",
+	)
+
+	// the burst is spent, the next replies are dropped
+	i.ReadEvent(":user!u@h PRIVMSG gophirc :\001VERSION\001")
+	expectNoLines(t, lines)
+}
+
+func TestIRC_CTCPRequest(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	type result struct {
+		reply string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := i.CTCPRequest(context.Background(), "someone", "version", "")
+		done <- result{r, err}
+	}()
+
+	answer(t, i, lines, "PRIVMSG someone :\001VERSION\001",
+		":other!u@h NOTICE gophirc :\001VERSION not this one\001",
+		":someone!u@h NOTICE gophirc :\001PING 1\001",
+		":Someone!u@h NOTICE gophirc :\001VERSION someclient 1.0\001",
+	)
+	if r := <-done; r.err != nil || r.reply != "someclient 1.0" {
+		t.Errorf("Expected %q, got %q (err %v) instead.", "someclient 1.0", r.reply, r.err)
+	}
+
+	go func() {
+		r, err := i.CTCPRequest(context.Background(), "nobody", "PING", "123")
+		done <- result{r, err}
+	}()
+	answer(t, i, lines, "PRIVMSG nobody :\001PING 123\001",
+		":irc.server.tld 401 gophirc nobody :No such nick/channel",
+	)
+	r := <-done
+	if err, ok := r.err.(*ReplyError); !ok || err.Code != "401" {
+		t.Errorf("Expected a 401 error, got %v instead.", r.err)
+	}
+}
+
+func TestIRC_CTCPSpoof(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	i.ReadEvent(":irc.server.tld 001 gophirc :Welcome")
+	i.ReadEvent(":gophirc!u@h JOIN #chan")
+	expectNoLines(t, lines)
+
+	// requests named after the server's commands don't change our state
+	i.ReadEvent(":evil!u@h PRIVMSG gophirc :\001KICK #chan gophirc\001")
+	i.ReadEvent(":evil!u@h PRIVMSG gophirc :\001INVITE gophirc #spam\001")
+	i.ReadEvent(":evil!u@h PRIVMSG gophirc :\001001 pwned\001")
+	expectNoLines(t, lines)
+
+	if nick := i.CurrentNick(); nick != "gophirc" {
+		t.Errorf("Expected %q, got %q instead.", "gophirc", nick)
+	}
+	if _, ok := i.Channel("#chan"); !ok {
+		t.Error("Expected to still be in #chan, got false instead.")
+	}
+	if channels := i.rejoinChannels(); !reflect.DeepEqual(channels, []string{"#chan"}) {
+		t.Errorf("Expected to rejoin %q, got %q instead.", []string{"#chan"}, channels)
+	}
+
+	// nor answer a query
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := i.Whois(ctx, "someone")
+		done <- err
+	}()
+	answer(t, i, lines, "WHOIS someone", ":evil!u@h PRIVMSG gophirc :\001318 gophirc someone :End of WHOIS\001")
+	select {
+	case err := <-done:
+		t.Errorf("Expected the WHOIS to wait for the server, got %v instead.", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected %v, got %v instead.", context.Canceled, err)
+	}
+}
@@ -0,0 +1,183 @@
+package gophirc
+
+import (
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// dispatcher runs the callbacks on a fixed pool of workers. The events sharing a key (the
+// channel, the user sending it, or the server) are dispatched in the order read: each event
+// waits for the previous ones with any of its keys, e.g. a user's message on a channel waits
+// for the channel's events & the user's events read before it.
+type dispatcher struct {
+	irc     *IRC
+	workers []*eventQueue
+	wg      sync.WaitGroup
+
+	mu   sync.Mutex
+	last map[string]chan struct{} // closed once the last event pushed with the key is dispatched
+}
+
+// queuedEvent is an event waiting for a worker, & for the events it comes after.
+type queuedEvent struct {
+	*Event
+	keys  []string
+	after []chan struct{}
+	done  chan struct{}
+}
+
+// eventQueue holds the events waiting for a worker.
+type eventQueue struct {
+	sync.Mutex
+	events []*queuedEvent
+	closed bool
+	wake   chan struct{}
+}
+
+func (q *eventQueue) push(e *queuedEvent) {
+	q.Lock()
+	q.events = append(q.events, e)
+	q.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next event, waiting for one, or false once the queue is closed & empty.
+func (q *eventQueue) next() (*queuedEvent, bool) {
+	for {
+		q.Lock()
+		if len(q.events) > 0 {
+			e := q.events[0]
+			q.events = q.events[1:]
+			q.Unlock()
+			return e, true
+		}
+		closed := q.closed
+		q.Unlock()
+
+		if closed {
+			return nil, false
+		}
+		<-q.wake
+	}
+}
+
+func (q *eventQueue) close() {
+	q.Lock()
+	q.closed = true
+	q.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startDispatcher starts the workers, as many as set in the config, at least one.
+func (irc *IRC) startDispatcher() *dispatcher {
+	n := irc.Config().Workers
+	if n < 1 {
+		n = 1
+	}
+
+	d := &dispatcher{irc: irc, last: make(map[string]chan struct{})}
+	for i := 0; i < n; i++ {
+		q := &eventQueue{wake: make(chan struct{}, 1)}
+		d.workers = append(d.workers, q)
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				e, ok := q.next()
+				if !ok {
+					return
+				}
+				// the events waited for were pushed before, so they're ahead in their queues
+				for _, c := range e.after {
+					<-c
+				}
+				irc.safeDispatch(e.Event)
+				d.done(e)
+				irc.handlers.Done()
+			}
+		}()
+	}
+	return d
+}
+
+// push queues the event on the worker for its first key, after the events with the same keys.
+func (d *dispatcher) push(e *Event) {
+	q := &queuedEvent{Event: e, keys: d.irc.dispatchKeys(e), done: make(chan struct{})}
+
+	d.mu.Lock()
+	for _, k := range q.keys {
+		if c, ok := d.last[k]; ok {
+			q.after = append(q.after, c)
+		}
+		d.last[k] = q.done
+	}
+	d.mu.Unlock()
+
+	h := fnv.New32a()
+	h.Write([]byte(q.keys[0]))
+
+	d.irc.handlers.Add(1)
+	d.workers[h.Sum32()%uint32(len(d.workers))].push(q)
+}
+
+// done releases the events waiting for the dispatched one.
+func (d *dispatcher) done(e *queuedEvent) {
+	d.mu.Lock()
+	for _, k := range e.keys {
+		if d.last[k] == e.done {
+			delete(d.last, k)
+		}
+	}
+	d.mu.Unlock()
+	close(e.done)
+}
+
+// stop stops the workers, after the events queued are dispatched.
+func (d *dispatcher) stop() {
+	for _, q := range d.workers {
+		q.close()
+	}
+	d.wg.Wait()
+}
+
+// dispatchKeys returns the keys ordering the event: the channel it's sent to, if any, & the user
+// sending it, or the server. The channels' keys keep their prefix, so they can't clash with
+// the nicknames.
+func (irc *IRC) dispatchKeys(e *Event) []string {
+	var keys []string
+	if len(e.Arguments) > 0 && irc.IsChannel(e.Arguments[0]) {
+		keys = append(keys, irc.isupport.Fold(e.Arguments[0]))
+	}
+	if e.User != nil {
+		return append(keys, irc.isupport.Fold(e.User.Nick))
+	}
+	if len(keys) == 0 {
+		keys = append(keys, "")
+	}
+	return keys
+}
+
+// safeDispatch dispatches the event, recovering & logging a panic from a callback or a
+// middleware.
+func (irc *IRC) safeDispatch(e *Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.WithFields(logger.Fields(map[string]interface{}{
+				"code": e.Code, "raw": e.Raw,
+			})).Errorf("Recovered from a panic in a callback: %v\n%s", r, debug.Stack())
+		}
+	}()
+	irc.dispatch(e)
+}
@@ -0,0 +1,193 @@
+package gophirc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_DispatchKey(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+	}{
+		{"channel", ":user!u@h PRIVMSG #Chan :hi", []string{"#chan", "user"}},
+		{"same channel", ":other!u@h JOIN #CHAN", []string{"#chan", "other"}},
+		{"private message", ":User!u@h PRIVMSG gophirc :hi", []string{"user"}},
+		{"user", ":User[1]!u@h NICK other", []string{"user{1}"}},
+		{"server on a channel", ":irc.server.tld MODE #chan +o user", []string{"#chan"}},
+		{"server", ":irc.server.tld 001 gophirc :Welcome", []string{""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, ok := i.ParseToEvent(test.raw)
+			if !ok {
+				t.Fatalf("Can't parse %q", test.raw)
+			}
+			if keys := i.dispatchKeys(e); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("Expected keys %q, got %q instead.", test.expected, keys)
+			}
+		})
+	}
+}
+
+func TestDispatcher_Order(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Workers: 4})
+
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		time.Sleep(time.Millisecond) // give the other workers a chance to overtake
+		mu.Lock()
+		received[e.ReplyTo] = append(received[e.ReplyTo], e.Message)
+		mu.Unlock()
+	})
+
+	d := i.startDispatcher()
+	expected := make(map[string][]string)
+	for n := 0; n < 20; n++ {
+		for _, c := range []string{"#a", "#b", "#c", "#d", "#e"} {
+			message := fmt.Sprint(n)
+			expected[c] = append(expected[c], message)
+			e, _ := i.ParseToEvent(fmt.Sprintf(":user!u@h PRIVMSG %s :%s", c, message))
+			d.push(e)
+		}
+	}
+	i.handlers.Wait()
+	d.stop()
+
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, got %v instead.", expected, received)
+	}
+}
+
+func TestDispatcher_UserOrder(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Workers: 4})
+
+	var mu sync.Mutex
+	var received []string
+	record := func(e *Event) {
+		if e.Code == "PRIVMSG" {
+			time.Sleep(5 * time.Millisecond) // give the other workers a chance to overtake
+		}
+		mu.Lock()
+		received = append(received, e.Code+" "+e.User.Nick)
+		mu.Unlock()
+	}
+	i.AddEventCallback("PRIVMSG", record)
+	i.AddEventCallback("QUIT", record)
+
+	d := i.startDispatcher()
+	var expected []string
+	for n := 0; n < 10; n++ {
+		nick := fmt.Sprint("user", n)
+		for _, raw := range []string{
+			fmt.Sprintf(":%s!u@h PRIVMSG #chan%d :hi", nick, n),
+			fmt.Sprintf(":%s!u@h PRIVMSG gophirc :hi", nick),
+			fmt.Sprintf(":%s!u@h QUIT :bye", nick),
+		} {
+			e, _ := i.ParseToEvent(raw)
+			expected = append(expected, e.Code+" "+nick)
+			d.push(e)
+		}
+	}
+	i.handlers.Wait()
+	d.stop()
+
+	// each user's events in order, the users' interleaving not mattering
+	byUser := func(events []string) map[string][]string {
+		m := make(map[string][]string)
+		for _, e := range events {
+			m[strings.Fields(e)[1]] = append(m[strings.Fields(e)[1]], e)
+		}
+		return m
+	}
+	if !reflect.DeepEqual(byUser(received), byUser(expected)) {
+		t.Errorf("Expected %q, got %q instead.", byUser(expected), byUser(received))
+	}
+	if len(d.last) != 0 {
+		t.Errorf("Expected the keys to be released, got %d left.", len(d.last))
+	}
+}
+
+func TestDispatcher_Workers(t *testing.T) {
+	tests := []struct {
+		name     string
+		workers  int
+		expected int
+	}{
+		{"unset", 0, 1},
+		{"single", 1, 1},
+		{"pool", 3, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Workers: test.workers})
+
+			var mu sync.Mutex
+			running, max := 0, 0
+			i.AddEventCallback("PRIVMSG", func(e *Event) {
+				mu.Lock()
+				running++
+				if running > max {
+					max = running
+				}
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})
+
+			d := i.startDispatcher()
+			for n := 0; n < 30; n++ {
+				e, _ := i.ParseToEvent(fmt.Sprintf(":user!u@h PRIVMSG #chan%d :hi", n))
+				d.push(e)
+			}
+			i.handlers.Wait()
+			d.stop()
+
+			if max > test.expected {
+				t.Errorf("Expected at most %d callbacks at once, got %d instead.", test.expected, max)
+			}
+		})
+	}
+}
+
+func TestDispatcher_Recover(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Workers: 1})
+
+	var messages []string
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		if e.Message == "panic" {
+			panic("faulty callback")
+		}
+		messages = append(messages, e.Message)
+	})
+
+	d := i.startDispatcher()
+	for _, m := range []string{"before", "panic", "after"} {
+		e, _ := i.ParseToEvent(":user!u@h PRIVMSG #chan :" + m)
+		d.push(e)
+	}
+	i.handlers.Wait()
+	d.stop()
+
+	expected := []string{"before", "after"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, messages)
+	}
+
+	// ReadEvent recovers too
+	i.ReadEvent(":user!u@h PRIVMSG #chan :panic")
+}
@@ -0,0 +1,341 @@
+package gophirc
+
+// Major numerics, to use with OnNumeric.
+const (
+	RplWelcome          = "001"
+	RplISupport         = "005"
+	RplAway             = "301"
+	RplChannelModeIs    = "324"
+	RplNoTopic          = "331"
+	RplTopic            = "332"
+	RplTopicWhoTime     = "333"
+	RplInviting         = "341"
+	RplNamReply         = "353"
+	RplEndOfNames       = "366"
+	RplEndOfMotd        = "376"
+	ErrNoSuchNick       = "401"
+	ErrNoSuchChannel    = "403"
+	ErrCannotSend       = "404"
+	ErrNoMotd           = "422"
+	ErrNicknameInUse    = "433"
+	ErrNotOnChannel     = "442"
+	ErrChannelFull      = "471"
+	ErrInviteOnly       = "473"
+	ErrBannedFromChan   = "474"
+	ErrBadChannelKey    = "475"
+	ErrChanOPrivsNeeded = "482"
+)
+
+// JoinEvent is a user joining a channel. With extended-join, the account (empty if not logged
+// in) & the realname are known.
+type JoinEvent struct {
+	*Event
+	Channel  string
+	Account  string
+	Realname string
+}
+
+// PartEvent is a user leaving a channel.
+type PartEvent struct {
+	*Event
+	Channel string
+	Reason  string
+}
+
+// KickEvent is a user kicked from a channel by the event's user.
+type KickEvent struct {
+	*Event
+	Channel string
+	Nick    string // the user kicked
+	Reason  string
+}
+
+// QuitEvent is a user quitting the network.
+type QuitEvent struct {
+	*Event
+	Reason string
+}
+
+// NickEvent is a user changing its nickname.
+type NickEvent struct {
+	*Event
+	Old string
+	New string
+}
+
+// ModeEvent is a mode change on a channel or a user.
+type ModeEvent struct {
+	*Event
+	Target  string // the channel, or the nickname
+	Modes   string // e.g. "+o-v"
+	Params  []string
+	Changes []ModeChange // the modes parsed along with their parameters
+}
+
+// TopicEvent is a channel's topic changed by the event's user.
+type TopicEvent struct {
+	*Event
+	Channel string
+	Topic   string
+}
+
+// InviteEvent is an invitation to a channel sent by the event's user.
+type InviteEvent struct {
+	*Event
+	Nick    string // the user invited, us unless invite-notify tells about the others
+	Channel string
+}
+
+// MessageEvent is a PRIVMSG or a NOTICE, CTCP messages excluded.
+type MessageEvent struct {
+	*Event
+	Target  string // the channel or the nickname the message was sent to
+	Text    string
+	Private bool // sent to us rather than to a channel
+}
+
+// NumericEvent is a numeric reply. The first argument, our nickname, is left out of Params;
+// Text is the last parameter, usually the human readable one.
+type NumericEvent struct {
+	*Event
+	Params []string
+	Text   string
+}
+
+// defaultISupport holds the RFC 1459 defaults, used to parse the events outside of a connection.
+var defaultISupport = newISupport()
+
+// AsJoin returns the JOIN event as a JoinEvent, false if it isn't a valid one.
+func AsJoin(e *Event) (*JoinEvent, bool) {
+	if e.CTCP || e.Code != "JOIN" || len(e.Arguments) == 0 {
+		return nil, false
+	}
+	j := &JoinEvent{Event: e, Channel: e.Arguments[0], Account: e.Account}
+	if len(e.Arguments) > 2 {
+		j.Account, j.Realname = e.Arguments[1], e.Arguments[2]
+		if j.Account == "*" {
+			j.Account = ""
+		}
+	}
+	return j, true
+}
+
+// AsPart returns the PART event as a PartEvent, false if it isn't a valid one.
+func AsPart(e *Event) (*PartEvent, bool) {
+	if e.CTCP || e.Code != "PART" || len(e.Arguments) == 0 {
+		return nil, false
+	}
+	return &PartEvent{Event: e, Channel: e.Arguments[0], Reason: argAt(e, 1)}, true
+}
+
+// AsKick returns the KICK event as a KickEvent, false if it isn't a valid one.
+func AsKick(e *Event) (*KickEvent, bool) {
+	if e.CTCP || e.Code != "KICK" || len(e.Arguments) < 2 {
+		return nil, false
+	}
+	return &KickEvent{Event: e, Channel: e.Arguments[0], Nick: e.Arguments[1], Reason: argAt(e, 2)}, true
+}
+
+// AsQuit returns the QUIT event as a QuitEvent, false if it isn't a valid one.
+func AsQuit(e *Event) (*QuitEvent, bool) {
+	if e.CTCP || e.Code != "QUIT" || e.User == nil {
+		return nil, false
+	}
+	return &QuitEvent{Event: e, Reason: argAt(e, 0)}, true
+}
+
+// AsNick returns the NICK event as a NickEvent, false if it isn't a valid one.
+func AsNick(e *Event) (*NickEvent, bool) {
+	if e.CTCP || e.Code != "NICK" || e.User == nil || len(e.Arguments) == 0 {
+		return nil, false
+	}
+	return &NickEvent{Event: e, Old: e.User.Nick, New: e.Arguments[0]}, true
+}
+
+// AsMode returns the MODE event as a ModeEvent, false if it isn't a valid one. The modes are
+// parsed with the RFC 1459 defaults, see IRC.OnMode for the server's ones.
+func AsMode(e *Event) (*ModeEvent, bool) {
+	return asMode(e, defaultISupport)
+}
+
+func asMode(e *Event, s *ISupport) (*ModeEvent, bool) {
+	if e.CTCP || e.Code != "MODE" || len(e.Arguments) < 2 {
+		return nil, false
+	}
+	m := &ModeEvent{Event: e, Target: e.Arguments[0], Modes: e.Arguments[1], Params: e.Arguments[2:]}
+	if s.IsChannel(m.Target) {
+		m.Changes = s.ParseModes(m.Modes, m.Params)
+	} else {
+		// user modes don't take parameters
+		m.Changes = s.ParseModes(m.Modes, nil)
+		for i := range m.Changes {
+			m.Changes[i].Param = ""
+		}
+	}
+	return m, true
+}
+
+// AsTopic returns the TOPIC event as a TopicEvent, false if it isn't a valid one.
+func AsTopic(e *Event) (*TopicEvent, bool) {
+	if e.CTCP || e.Code != "TOPIC" || len(e.Arguments) < 2 {
+		return nil, false
+	}
+	return &TopicEvent{Event: e, Channel: e.Arguments[0], Topic: e.Arguments[1]}, true
+}
+
+// AsInvite returns the INVITE event as an InviteEvent, false if it isn't a valid one.
+func AsInvite(e *Event) (*InviteEvent, bool) {
+	if e.CTCP || e.Code != "INVITE" || len(e.Arguments) < 2 {
+		return nil, false
+	}
+	return &InviteEvent{Event: e, Nick: e.Arguments[0], Channel: e.Arguments[1]}, true
+}
+
+// AsMessage returns the PRIVMSG or NOTICE event as a MessageEvent, false if it isn't a valid
+// one. The channels are told apart with the RFC 1459 defaults, see IRC.OnPrivMsg.
+func AsMessage(e *Event) (*MessageEvent, bool) {
+	return asMessage(e, defaultISupport)
+}
+
+func asMessage(e *Event, s *ISupport) (*MessageEvent, bool) {
+	if e.CTCP || (e.Code != "PRIVMSG" && e.Code != "NOTICE") || len(e.Arguments) < 2 {
+		return nil, false
+	}
+	target := e.Arguments[0]
+	return &MessageEvent{Event: e, Target: target, Text: e.Arguments[1], Private: !s.IsChannel(target)}, true
+}
+
+// AsNumeric returns the numeric event as a NumericEvent, false if it isn't a numeric.
+func AsNumeric(e *Event) (*NumericEvent, bool) {
+	if e.CTCP || !isNumeric(e.Code) {
+		return nil, false
+	}
+	n := &NumericEvent{Event: e}
+	if len(e.Arguments) > 1 {
+		n.Params = e.Arguments[1:]
+	}
+	if len(e.Arguments) > 0 {
+		n.Text = e.Arguments[len(e.Arguments)-1]
+	}
+	return n, true
+}
+
+func isNumeric(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// argAt returns the event's argument at the index, or "" if missing.
+func argAt(e *Event, index int) string {
+	if index < len(e.Arguments) {
+		return e.Arguments[index]
+	}
+	return ""
+}
+
+// OnJoin binds a callback to the JOIN events.
+func (irc *IRC) OnJoin(cb func(*JoinEvent)) *Callback {
+	return irc.On("JOIN", func(e *Event) {
+		if j, ok := AsJoin(e); ok {
+			cb(j)
+		}
+	})
+}
+
+// OnPart binds a callback to the PART events.
+func (irc *IRC) OnPart(cb func(*PartEvent)) *Callback {
+	return irc.On("PART", func(e *Event) {
+		if p, ok := AsPart(e); ok {
+			cb(p)
+		}
+	})
+}
+
+// OnKick binds a callback to the KICK events.
+func (irc *IRC) OnKick(cb func(*KickEvent)) *Callback {
+	return irc.On("KICK", func(e *Event) {
+		if k, ok := AsKick(e); ok {
+			cb(k)
+		}
+	})
+}
+
+// OnQuit binds a callback to the QUIT events.
+func (irc *IRC) OnQuit(cb func(*QuitEvent)) *Callback {
+	return irc.On("QUIT", func(e *Event) {
+		if q, ok := AsQuit(e); ok {
+			cb(q)
+		}
+	})
+}
+
+// OnNick binds a callback to the NICK events.
+func (irc *IRC) OnNick(cb func(*NickEvent)) *Callback {
+	return irc.On("NICK", func(e *Event) {
+		if n, ok := AsNick(e); ok {
+			cb(n)
+		}
+	})
+}
+
+// OnMode binds a callback to the MODE events, the modes being parsed with the ones advertised
+// by the server.
+func (irc *IRC) OnMode(cb func(*ModeEvent)) *Callback {
+	return irc.On("MODE", func(e *Event) {
+		if m, ok := asMode(e, irc.isupport); ok {
+			cb(m)
+		}
+	})
+}
+
+// OnTopic binds a callback to the TOPIC events.
+func (irc *IRC) OnTopic(cb func(*TopicEvent)) *Callback {
+	return irc.On("TOPIC", func(e *Event) {
+		if t, ok := AsTopic(e); ok {
+			cb(t)
+		}
+	})
+}
+
+// OnInvite binds a callback to the INVITE events.
+func (irc *IRC) OnInvite(cb func(*InviteEvent)) *Callback {
+	return irc.On("INVITE", func(e *Event) {
+		if i, ok := AsInvite(e); ok {
+			cb(i)
+		}
+	})
+}
+
+// OnPrivMsg binds a callback to the PRIVMSG events, CTCP messages excluded.
+func (irc *IRC) OnPrivMsg(cb func(*MessageEvent)) *Callback {
+	return irc.On("PRIVMSG", func(e *Event) {
+		if m, ok := asMessage(e, irc.isupport); ok {
+			cb(m)
+		}
+	})
+}
+
+// OnNotice binds a callback to the NOTICE events.
+func (irc *IRC) OnNotice(cb func(*MessageEvent)) *Callback {
+	return irc.On("NOTICE", func(e *Event) {
+		if m, ok := asMessage(e, irc.isupport); ok {
+			cb(m)
+		}
+	})
+}
+
+// OnNumeric binds a callback to a numeric reply, e.g. RplWelcome.
+func (irc *IRC) OnNumeric(code string, cb func(*NumericEvent)) *Callback {
+	return irc.On(code, func(e *Event) {
+		if n, ok := AsNumeric(e); ok {
+			cb(n)
+		}
+	})
+}
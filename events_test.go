@@ -0,0 +1,172 @@
+package gophirc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestAsTypedEvents(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	tests := []struct {
+		name     string
+		raw      string
+		as       func(*Event) (interface{}, bool)
+		expected interface{}
+	}{
+		{"join", ":user!u@h JOIN #chan", func(e *Event) (interface{}, bool) {
+			j, ok := AsJoin(e)
+			j.Event = nil
+			return *j, ok
+		}, JoinEvent{Channel: "#chan"}},
+		{"extended join", ":user!u@h JOIN #chan account :Real Name", func(e *Event) (interface{}, bool) {
+			j, ok := AsJoin(e)
+			j.Event = nil
+			return *j, ok
+		}, JoinEvent{Channel: "#chan", Account: "account", Realname: "Real Name"}},
+		{"extended join, no account", ":user!u@h JOIN #chan * :Real Name", func(e *Event) (interface{}, bool) {
+			j, ok := AsJoin(e)
+			j.Event = nil
+			return *j, ok
+		}, JoinEvent{Channel: "#chan", Realname: "Real Name"}},
+		{"part", ":user!u@h PART #chan :bye", func(e *Event) (interface{}, bool) {
+			p, ok := AsPart(e)
+			p.Event = nil
+			return *p, ok
+		}, PartEvent{Channel: "#chan", Reason: "bye"}},
+		{"kick", ":op!u@h KICK #chan user :behave", func(e *Event) (interface{}, bool) {
+			k, ok := AsKick(e)
+			k.Event = nil
+			return *k, ok
+		}, KickEvent{Channel: "#chan", Nick: "user", Reason: "behave"}},
+		{"quit", ":user!u@h QUIT :Ping timeout", func(e *Event) (interface{}, bool) {
+			q, ok := AsQuit(e)
+			q.Event = nil
+			return *q, ok
+		}, QuitEvent{Reason: "Ping timeout"}},
+		{"nick", ":user!u@h NICK other", func(e *Event) (interface{}, bool) {
+			n, ok := AsNick(e)
+			n.Event = nil
+			return *n, ok
+		}, NickEvent{Old: "user", New: "other"}},
+		{"channel mode", ":op!u@h MODE #chan +ol-v user 10 other", func(e *Event) (interface{}, bool) {
+			m, ok := AsMode(e)
+			return m.Changes, ok
+		}, []ModeChange{{true, 'o', "user"}, {true, 'l', "10"}, {false, 'v', "other"}}},
+		{"user mode", ":gophirc MODE gophirc :+iw", func(e *Event) (interface{}, bool) {
+			m, ok := AsMode(e)
+			return m.Changes, ok
+		}, []ModeChange{{true, 'i', ""}, {true, 'w', ""}}},
+		{"topic", ":user!u@h TOPIC #chan :new topic", func(e *Event) (interface{}, bool) {
+			tp, ok := AsTopic(e)
+			tp.Event = nil
+			return *tp, ok
+		}, TopicEvent{Channel: "#chan", Topic: "new topic"}},
+		{"invite", ":user!u@h INVITE gophirc #chan", func(e *Event) (interface{}, bool) {
+			inv, ok := AsInvite(e)
+			inv.Event = nil
+			return *inv, ok
+		}, InviteEvent{Nick: "gophirc", Channel: "#chan"}},
+		{"channel message", ":user!u@h PRIVMSG #chan :hi there", func(e *Event) (interface{}, bool) {
+			m, ok := AsMessage(e)
+			m.Event = nil
+			return *m, ok
+		}, MessageEvent{Target: "#chan", Text: "hi there"}},
+		{"private notice", ":user!u@h NOTICE gophirc :hi", func(e *Event) (interface{}, bool) {
+			m, ok := AsMessage(e)
+			m.Event = nil
+			return *m, ok
+		}, MessageEvent{Target: "gophirc", Text: "hi", Private: true}},
+		{"numeric", ":irc.server.tld 474 gophirc #chan :Cannot join channel (+b)", func(e *Event) (interface{}, bool) {
+			n, ok := AsNumeric(e)
+			n.Event = nil
+			return *n, ok
+		}, NumericEvent{Params: []string{"#chan", "Cannot join channel (+b)"}, Text: "Cannot join channel (+b)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := i.ParseToEvent(test.raw)
+			got, ok := test.as(e)
+			if !ok {
+				t.Fatalf("Can't convert %q", test.raw)
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Expected %+v, got %+v instead.", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestAsTypedEvents_Invalid(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	tests := []struct {
+		name string
+		raw  string
+		as   func(*Event) bool
+	}{
+		{"join without channel", ":user!u@h JOIN", func(e *Event) bool { _, ok := AsJoin(e); return ok }},
+		{"kick without user", ":op!u@h KICK #chan", func(e *Event) bool { _, ok := AsKick(e); return ok }},
+		{"quit from server", ":irc.server.tld QUIT", func(e *Event) bool { _, ok := AsQuit(e); return ok }},
+		{"mode without modes", ":op!u@h MODE #chan", func(e *Event) bool { _, ok := AsMode(e); return ok }},
+		{"invite without channel", ":user!u@h INVITE gophirc", func(e *Event) bool { _, ok := AsInvite(e); return ok }},
+		{"other code", ":user!u@h PART #chan", func(e *Event) bool { _, ok := AsKick(e); return ok }},
+		{"not a numeric", ":user!u@h PRIVMSG #chan :hi", func(e *Event) bool { _, ok := AsNumeric(e); return ok }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := i.ParseToEvent(test.raw)
+			if test.as(e) {
+				t.Errorf("Expected %q not to convert.", test.raw)
+			}
+		})
+	}
+}
+
+func TestIRC_OnTyped(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	i.ReadEvent(":irc.server.tld 005 gophirc CHANTYPES=#! PREFIX=(qov)~@+ :are supported by this server")
+
+	var got []string
+	i.OnKick(func(k *KickEvent) {
+		got = append(got, "kick:"+k.Nick)
+	})
+	i.OnMode(func(m *ModeEvent) {
+		for _, c := range m.Changes {
+			got = append(got, "mode:"+string(c.Mode)+c.Param)
+		}
+	})
+	i.OnPrivMsg(func(m *MessageEvent) {
+		if m.Private {
+			got = append(got, "private:"+m.Text)
+		} else {
+			got = append(got, "channel:"+m.Text)
+		}
+	})
+	i.OnNumeric(ErrBannedFromChan, func(n *NumericEvent) {
+		got = append(got, "banned:"+n.Params[0])
+	})
+	cb := i.OnNick(func(n *NickEvent) {
+		got = append(got, "nick:"+n.New)
+	})
+	cb.Remove()
+
+	for _, raw := range []string{
+		":op!u@h KICK #chan user :behave",
+		":op!u@h KICK #chan",
+		":op!u@h MODE !chan +q user",
+		":user!u@h PRIVMSG !chan :hi",
+		":user!u@h PRIVMSG gophirc :psst",
+		":irc.server.tld 474 gophirc #chan :Cannot join channel (+b)",
+		":user!u@h NICK other",
+	} {
+		i.ReadEvent(raw)
+	}
+
+	expected := []string{"kick:user", "mode:quser", "channel:hi", "private:psst", "banned:#chan"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, got)
+	}
+}
@@ -1,18 +1,17 @@
 package gophirc
 
+import "strings"
+
 // IsCTCP returns whether a string is a CTCP action based on the 0x01 flag.
 func IsCTCP(s string) bool {
-	if s[0] == '\001' && s[len(s)-1:][0] == '\001' {
+	if len(s) > 1 && s[0] == '\001' && s[len(s)-1] == '\001' {
 		return true
 	}
 	return false
 }
 
-// IsChannel returns whether a string is a channel or not.
+// IsChannel returns whether a string is a channel or not, using the default channel types "#&".
+// Use IRC.IsChannel for the channel types advertised by the server.
 func IsChannel(s string) bool {
-	// todo: add regex for better matching
-	if s[0] == '#' {
-		return true
-	}
-	return false
+	return s != "" && strings.IndexByte("#&", s[0]) != -1
 }
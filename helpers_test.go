@@ -27,7 +27,9 @@ func TestIsChannel(t *testing.T) {
 		expected bool
 	}{
 		{"#test", true},
+		{"&test", true},
 		{"test", false},
+		{"", false},
 	}
 	for _, test := range tests {
 		t.Run(test.channel, func(t *testing.T) {
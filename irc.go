@@ -2,6 +2,7 @@ package gophirc
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -14,278 +15,580 @@ import (
 )
 
 // State keeps track of the framework's states, as the name implies.
+// Capabilities holds the IRCv3 capabilities acknowledged by the server, along with their values;
+// use HasCapability to query it from callbacks. SASL holds the result of the SASL authentication;
+// use SASLAuthenticated from callbacks.
 type State struct {
 	Registered   bool
 	Disconnected struct {
 		Value     bool
 		Requested bool
 	}
+	Capabilities map[string]string
+	SASL         SASLResult
+	Lag          time.Duration // round-trip time of our last PING, see IRC.Lag
 }
 
 // Event contains the raw event received from the server along with the parsed data.
-// The framework parses CTCP messages as event, changing the Code to the CTCP action received.
+// The framework parses CTCP messages as event, changing the Code to CTCPCode for the CTCP
+// requests, or to CTCPReplyCode for the CTCP replies.
 // In case we receive an event from a user (and not the server), we parse it and store it into
 // the `User` variable.
 type Event struct {
-	Raw       string   // Raw line received from the server
-	Code      string   // Event code received or parsed from a CTCP message
-	Source    string   // Source of the event, user or server
-	Arguments []string // Arguments of the event
+	Raw       string            // Raw line received from the server
+	Code      string            // Event code received or parsed from a CTCP message
+	Source    string            // Source of the event, user or server
+	Arguments []string          // Arguments of the event, the trailing one without its colon
+	Tags      map[string]string // IRCv3 message tags, if any
 
 	User    *User  // If the source is a user, parse it & store it
 	Message string // If the event is a PRIVMSG, store the message here
 	ReplyTo string // Store the user or the channel to reply to
+	Account string // Services account of the user, if logged in & known
+	CTCP    bool   // The event is a CTCP request or reply, see AsCTCP
+
+	stopped bool // a callback stopped the propagation
 }
 
 // IRC is the main structure containing the connection, server, state, event callbacks, etc.
 type IRC struct {
-	conn   net.Conn
-	Server *config.Server
+	mu    sync.Mutex // guards conn, queue, err, hooked, stop, State.Registered, State.Disconnected & State.Lag
+	conn  net.Conn
+	queue *sendQueue // lines waiting to be written to conn
+	err   error      // reason of a disconnect we initiated, returned by Run
+
+	dialer Dialer // the transport under TLS & WebSocket, see SetDialer
+
+	Server   *config.Server // replaced by Reload, read it with Config while running
+	serverMu sync.RWMutex   // guards Server
+
+	State    State
+	Events   map[string][]*Callback // callbacks by event code, see On
+	eventsMu sync.RWMutex           // guards Events & the middleware
+
+	middleware     []Middleware            // run for every event, see Use
+	codeMiddleware map[string][]Middleware // run for the events with a code, see UseFor
+
+	caps     capabilities
+	session  session
+	tracker  *tracker  // channels & members, see Channel
+	isupport *ISupport // features advertised by the server
+	acl      *ACL
+	queries  queries // waiting for their replies, see Query
+	ctcp     ctcp
+	nicks    nickState
+	pings    keepAlive // our own PINGs, see Lag
+
+	handlers sync.WaitGroup // events queued or being dispatched
+
+	raw    chan rawLine  // every line received & sent, logged in debug mode & recorded
+	hooked chan struct{} // closed once Run stopped reading raw, nil before Run
+	stop   chan struct{} // closed on Disconnect
+}
 
-	State  State
-	Events map[string][]func(*Event)
+// ErrConnectionClosed is returned by Run when the server closes the connection & reconnecting is disabled.
+var ErrConnectionClosed = errors.New("Connection closed by the server")
 
-	Waiter *sync.WaitGroup
+// quitTimeout is how long we wait for the server to close the connection after a QUIT.
+const quitTimeout = 5 * time.Second
 
-	raw  chan string
-	quit chan struct{}
-}
-
-// Connect tries to connect to the server with the address & port specified in the config.
-// It has a 5 second timeout on the dialing.
+// Connect tries to connect to the server with the address & port specified in the config,
+// or the WebSocket URL, through the proxy if any. It has a 5 second timeout on the dialing.
+// If TLS is enabled in the config, the connection is wrapped in TLS, presenting the client
+// certificate if one is specified. See SetDialer & ConnectWith to use other transports.
 func (irc *IRC) Connect() error {
-	dest := fmt.Sprintf("%s:%d", irc.Server.Address, irc.Server.Port)
-	c, err := net.DialTimeout("tcp", dest, 5*time.Second)
+	dest := irc.addresses()[0]
+	c, err := irc.dial(dest)
 	if err != nil {
-		return errors.Wrap(err, "Error dialing the host")
+		return err
 	}
-	irc.Waiter.Add(1)
-	irc.conn = c
+	irc.connected(c, dest)
+	return nil
+}
 
-	irc.State.Disconnected = struct {
-		Value     bool
-		Requested bool
-	}{Value: false, Requested: false}
+// connected switches to the new connection to the destination, resetting the connection state.
+func (irc *IRC) connected(c net.Conn, dest string) {
+	irc.setConn(c)
 
-	return nil
+	irc.mu.Lock()
+	irc.stop = make(chan struct{})
+	irc.err = nil
+	irc.State.Registered = false
+	irc.State.Disconnected.Value = false
+	irc.State.Disconnected.Requested = false
+	irc.mu.Unlock()
+
+	irc.emit(EventConnected, dest)
+}
+
+// registered returns whether we sent our USER & NICK on this connection.
+func (irc *IRC) registered() bool {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+	return irc.State.Registered
+}
+
+// setConn switches to a new connection, starting its writer & dropping the lines still
+// queued for the previous one.
+func (irc *IRC) setConn(c net.Conn) {
+	q := newSendQueue()
+
+	irc.mu.Lock()
+	if irc.queue != nil {
+		irc.queue.close()
+	}
+	irc.conn, irc.queue = c, q
+	irc.mu.Unlock()
+
+	go irc.writer(c, q)
 }
 
-// Disconnect sends a QUIT command to the server, and closes the connection.
+// send queues a line to be written to the current connection.
+func (irc *IRC) send(line string) {
+	irc.mu.Lock()
+	q := irc.queue
+	irc.mu.Unlock()
+
+	if q != nil {
+		q.push(line)
+	}
+}
+
+func (irc *IRC) connection() net.Conn {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+	return irc.conn
+}
+
+// Disconnect sends a QUIT command to the server, which then closes the connection, ending Run.
 func (irc *IRC) Disconnect(s string) {
-	fmt.Fprint(irc.conn, fmt.Sprintf("QUIT :%s\r\n", s))
+	irc.disconnect(nil, s)
+}
 
-	irc.State.Disconnected = struct {
-		Value     bool
-		Requested bool
-	}{Value: true, Requested: true}
+// disconnect quits with the message, err being the reason returned by Run, if any.
+func (irc *IRC) disconnect(err error, s string) {
+	irc.send("QUIT :" + s)
+
+	irc.mu.Lock()
+	irc.State.Disconnected.Value = true
+	irc.State.Disconnected.Requested = true
+	if irc.err == nil {
+		irc.err = err
+	}
+	select {
+	case <-irc.stop:
+	default:
+		close(irc.stop)
+	}
+	irc.mu.Unlock()
+}
+
+func (irc *IRC) setDisconnected(value, requested bool) {
+	irc.mu.Lock()
+	irc.State.Disconnected.Value = value
+	irc.State.Disconnected.Requested = requested
+	irc.mu.Unlock()
+}
 
-	//irc.conn.Close()
-	irc.Waiter.Done()
+// requested returns whether we initiated the disconnect.
+func (irc *IRC) requested() bool {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+	return irc.State.Disconnected.Requested
 }
 
-// Loop keeps the connection active, getting the raw text from the server.
-// It also handles the quitting & debug logging.
-func (irc *IRC) Loop() {
-	var gracefulExit bool
+// disconnectErr returns the reason of the disconnect we initiated.
+func (irc *IRC) disconnectErr() error {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+	return irc.err
+}
+
+// Run connects to the server, unless already connected with Connect, and blocks until the
+// connection ends for good, reconnecting according to the server's reconnect policy. The first
+// connection follows the policy too, trying the alternate addresses with the backoff.
+// Cancelling the context stops the attempts to connect; once connected, it quits with the server's
+// quit message, closing the connection if the server doesn't within 5 seconds.
+// Run waits for the callbacks in progress before returning nil if Disconnect was called,
+// the context's error if it was cancelled, or the error which ended the connection.
+func (irc *IRC) Run(ctx context.Context) error {
+	irc.mu.Lock()
+	connected := irc.conn != nil && !irc.State.Disconnected.Value
+	if !connected {
+		// a Disconnect from a previous run mustn't stop this one
+		select {
+		case <-irc.stop:
+			irc.stop = make(chan struct{})
+			irc.State.Disconnected.Requested = false
+			irc.err = nil
+		default:
+		}
+	}
+	irc.mu.Unlock()
+	if !connected {
+		if err := irc.retryConnect(ctx, true); err != nil {
+			if irc.requested() {
+				return irc.disconnectErr()
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrap(err, "Can't (re)connect to server")
+		}
+	}
+
+	rec, err := openRecord(irc.Config().Record)
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		defer rec.Close()
+	}
+
+	done := make(chan struct{})
+	hooked := make(chan struct{}) // closed once the lines are logged & recorded
+	irc.mu.Lock()
+	irc.hooked = hooked
+	irc.mu.Unlock()
+	defer func() {
+		close(done)
+		<-hooked
+	}()
+	defer func() {
+		irc.mu.Lock()
+		irc.queue.close()
+		irc.mu.Unlock()
+	}()
 
 	go func() {
+		defer close(hooked)
+		cancelled := ctx.Done()
 		for {
 			select {
-			case <-irc.quit:
-				gracefulExit = true
-				irc.Disconnect("SIGINT")
+			case <-done:
 				return
-			case s := <-irc.raw:
+			case <-cancelled:
+				cancelled = nil
+				irc.disconnect(ctx.Err(), irc.Config().QuitMessage)
+				c := irc.connection()
+				time.AfterFunc(quitTimeout, func() { c.Close() })
+			case l := <-irc.raw:
 				if config.Get().Debug {
-					logger.Log.Debugf("%s:%d - %q", irc.Server.Address, irc.Server.Port, s)
+					logger.Log.Debugf("%s:%d - %q", irc.Config().Address, irc.Config().Port, l.line)
+				}
+				if rec != nil {
+					writeRecord(rec, l)
 				}
 			}
 		}
 	}()
 
-	s := bufio.NewScanner(irc.conn)
-	for s.Scan() {
-		go irc.ReadEvent(s.Text())
-	}
+	d := irc.startDispatcher()
+	defer d.stop()
 
-	if gracefulExit {
-		return
+	for {
+		err := irc.read(d)
+		// the callbacks waiting for replies won't get them anymore
+		irc.queries.fail(ErrConnectionClosed)
+		irc.handlers.Wait()
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		}
+		irc.emit(EventDisconnected, reason)
+
+		if irc.requested() {
+			return irc.disconnectErr()
+		}
+
+		irc.setDisconnected(true, false)
+
+		if err != nil {
+			err = errors.Wrap(err, "Error while looping")
+		} else {
+			err = ErrConnectionClosed
+		}
+		logger.Log.Errorln(err)
+
+		if irc.Config().Reconnect.Disabled {
+			return err
+		}
+
+		if rErr := irc.reconnect(ctx); rErr != nil {
+			if irc.requested() {
+				return irc.disconnectErr()
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrap(rErr, "Can't (re)connect to server")
+		}
 	}
+}
 
-	irc.State.Disconnected = struct {
-		Value     bool
-		Requested bool
-	}{Value: true, Requested: false}
+// read scans the lines from the connection until it ends. The framework's state is updated
+// as each line is read, then the event is queued for the callbacks.
+// While reading, we PING the server when the connection is idle, and the connection is closed
+// if nothing is received within the keep-alive timeout.
+func (irc *IRC) read(d *dispatcher) error {
+	c := irc.connection()
+	irc.resetKeepAlive()
+
+	done := make(chan struct{})
+	defer close(done)
+	go irc.pinger(done)
+
+	ka := irc.Config().KeepAlive
+	s := bufio.NewScanner(c)
+	for {
+		if !ka.Disabled && ka.Timeout > 0 {
+			c.SetReadDeadline(time.Now().Add(time.Duration(ka.Timeout)))
+		}
+		if !s.Scan() {
+			break
+		}
+		irc.touch()
 
-	if s.Err() != nil {
-		logger.Log.Errorln(errors.Wrap(s.Err(), "Error while looping"))
-	} else {
-		logger.Log.Errorf("Looping stopped, no bufio error - state: %#v", irc.State)
+		if e, ok := irc.process(s.Text()); ok {
+			d.push(e)
+		}
 	}
 
-	err := irc.Connect()
-	if err != nil {
-		logger.Log.Errorln(errors.Wrap(err, "Can't (re)connect to server"))
+	if err, ok := s.Err().(net.Error); ok && err.Timeout() {
+		c.Close()
+		return errors.Errorf("No data received for %s", time.Duration(ka.Timeout))
 	}
+	return s.Err()
 }
 
 // AddEventCallback adds a callback function to the Events map on the specified reply code.
+// Use On to get the callback back & remove it later on.
 func (irc *IRC) AddEventCallback(code string, cb func(*Event)) *IRC {
-	irc.Events[code] = append(irc.Events[code], cb)
+	irc.On(code, cb)
 	return irc
 }
 
 // ParseToEvent reads and parses a raw string to an Event struct.
+// It returns false if the line can't be parsed as an IRC message.
 func (irc *IRC) ParseToEvent(raw string) (event *Event, ok bool) {
-	irc.raw <- raw
+	irc.hook(rawLine{line: raw})
 	event = &Event{Raw: raw}
-	if raw[0] != ':' {
+
+	m, err := ParseMessage(raw)
+	if err != nil {
 		return
 	}
 
-	raw = raw[1:]
-	split := strings.Split(raw, " ")
-
-	event.Source = split[0]
-	event.Code = split[1]
-	event.Arguments = split[2:]
+	event.Tags = m.Tags
+	event.Source = m.Source
+	event.Code = m.Command
+	event.Arguments = m.Params
 
 	if u, ok := ParseUser(event.Source); ok {
 		event.User = u
 	}
 
-	if event.Code == "PRIVMSG" {
-		message := strings.Join(event.Arguments[1:], " ")[1:]
-		if IsCTCP(message) {
-			message = strings.Trim(message, "\001")
-			messageArgs := strings.Split(message, " ")
-			event.Code = messageArgs[0]
-			event.Arguments = messageArgs[1:]
+	if event.Code == "PRIVMSG" && len(event.Arguments) > 1 {
+		target := event.Arguments[0]
+		if irc.IsChannel(target) {
+			event.ReplyTo = target
+		} else if irc.isMe(target) && event.User != nil {
+			event.ReplyTo = event.User.Nick
 		}
-		event.Message = strings.TrimSpace(message)
 
-		if len(event.Arguments) > 0 {
-			if IsChannel(event.Arguments[0]) {
-				event.ReplyTo = event.Arguments[0]
-			} else if event.Arguments[0] == irc.Server.Nickname {
-				event.ReplyTo = event.User.Nick
-			}
+		if IsCTCP(event.Arguments[1]) {
+			parseCTCP(event, false)
+		} else {
+			event.Message = strings.TrimSpace(event.Arguments[1])
 		}
 	}
 
+	if event.Code == "NOTICE" && len(event.Arguments) > 1 && IsCTCP(event.Arguments[1]) {
+		parseCTCP(event, true)
+	}
+
 	return event, true
 }
 
-// ReadEvent reads a parsed Event, calls the callbacks defined, and adds some basic logging.
+// ReadEvent reads a parsed Event, runs it through the middleware, calls the callbacks defined,
+// and adds some basic logging. Unlike the events read by Run, the callbacks run right away.
 func (irc *IRC) ReadEvent(raw string) {
+	if e, ok := irc.process(raw); ok {
+		irc.safeDispatch(e)
+	}
+}
+
+// process parses the raw line & updates the framework's state: answers a PING, tracks the
+// channels & the accounts, and hands the replies to the queries waiting for them.
+// It returns false if there's nothing to dispatch.
+func (irc *IRC) process(raw string) (*Event, bool) {
 	e, ok := irc.ParseToEvent(raw)
 	if !ok {
-		split := strings.Split(e.Raw, " ")
-		if split[0] == "PING" {
-			irc.pong(split[1])
-			return
-		}
+		return nil, false
 	}
 
-	if irc.IsIgnored(e.User) {
-		return
+	// a CTCP is sent by a user, it can't change our state; its code only matches the queries
+	// waiting for a CTCP reply
+	if e.CTCP {
+		irc.eventAccount(e)
+		irc.queries.offer(e)
+		return e, true
 	}
 
-	if e.User != nil && e.User.Nick == irc.Server.Nickname {
-		// our own event
-		//return
+	if e.Code == "PING" && len(e.Arguments) > 0 {
+		irc.pong(e.Arguments[0])
+		return nil, false
 	}
+	if e.Code == "PONG" {
+		irc.handlePong(e)
+	}
+
+	// keep track of our nickname & the channels even for the ignored users
+	irc.trackNick(e)
+	irc.tracker.update(e, irc.CurrentNick(), irc.HasCapability("account-tag"))
+	irc.eventAccount(e)
+
+	irc.queries.offer(e)
+	return e, true
+}
+
+// eventAccount sets the account of the event's user: the message's account tag, or the tracked
+// account, as the users we share no channel with aren't tracked.
+func (irc *IRC) eventAccount(e *Event) {
+	if e.User == nil {
+		return
+	}
+	if a, ok := e.Tags["account"]; ok && a != "*" && a != "" {
+		e.Account = a
+		return
+	}
+	e.Account = irc.Account(e.User.Nick)
+}
 
-	for _, callback := range irc.Events[e.Code] {
-		callback(e)
+// logEvent adds some basic logging, after the callbacks.
+func (irc *IRC) logEvent(e *Event) {
+	if len(e.Arguments) < 2 {
+		return
 	}
 
 	switch e.Code {
 	case "404":
-		logger.Log.WithField("channel", e.Arguments[0]).Warnln("Can't send to channel")
+		logger.Log.WithField("channel", e.Arguments[1]).Warnln("Can't send to channel")
 	case "474":
-		logger.Log.WithField("channel", e.Arguments[0]).Warnln("Can't join channel")
+		logger.Log.WithField("channel", e.Arguments[1]).Warnln("Can't join channel")
 	case "KICK":
-		if e.Arguments[1] == irc.Server.Nickname {
+		if k, ok := AsKick(e); ok && irc.isMe(k.Nick) {
 			logger.Log.WithFields(logger.Fields(map[string]interface{}{
-				"user": e.User.Nick, "channel": e.Arguments[0],
+				"source": k.Source, "channel": k.Channel, "reason": k.Reason,
 			})).Warnln("We got kicked from a channel")
 		}
 	}
 }
 
 func (irc *IRC) addBasicCallbacks() {
-	irc.AddEventCallback("NOTICE", func(e *Event) {
-		go func(e *Event) {
-			message := strings.Join(e.Arguments[1:], " ")
-
-			if strings.Contains(e.Raw, "*** Looking up") && e.User == nil {
-				irc.Register()
-			}
+	irc.AddEventCallback(EventConnected, func(e *Event) {
+		irc.isupport.reset()
+		irc.tracker.reset()
+	}).AddEventCallback(EventDisconnected, func(e *Event) {
+		irc.queries.fail(ErrConnectionClosed)
+		irc.resetNick()
+	}).AddEventCallback("005", func(e *Event) {
+		irc.isupport.update(e)
+	}).AddEventCallback("NOTICE", func(e *Event) {
+		if strings.Contains(e.Raw, "*** Looking up") && e.User == nil && !irc.registered() {
+			irc.Register()
+		}
 
-			if e.User == nil {
-				return
-			}
+		if e.User == nil || len(e.Arguments) < 2 {
+			return
+		}
 
-			if e.User.Nick == "NickServ" && strings.HasPrefix(message, ":Password accepted") {
-				go irc.autojoin(e)
-			}
-		}(e)
+		if e.User.Nick == "NickServ" && strings.HasPrefix(e.Arguments[1], "Password accepted") {
+			logger.Log.Infoln("Successfully identified to NickServ")
+			irc.autojoin(e)
+		}
+	}).AddEventCallback("CAP", func(e *Event) {
+		irc.handleCap(e)
+	}).AddEventCallback("421", func(e *Event) {
+		irc.capUnsupported(e)
+	}).AddEventCallback("AUTHENTICATE", func(e *Event) {
+		irc.handleAuthenticate(e)
 	}).AddEventCallback("001", func(e *Event) {
 		logger.Log.Infoln("Successfully connected to server")
+		irc.capUnsupported(e)
+		if irc.saslMissing() {
+			return
+		}
+		if irc.SASLAuthenticated() || irc.Config().NickservPassword == "" {
+			irc.autojoin(e)
+			return
+		}
 		irc.Identify()
 	}).AddEventCallback("900", func(e *Event) {
-		go irc.autojoin(e)
-	}).AddEventCallback("INVITE", func(e *Event) {
-		go func(e *Event) {
-			channel := e.Arguments[1][1:]
-			irc.Join(channel)
-			irc.PrivMsg(channel, fmt.Sprintf("Hi %s, %s invited me here.", channel, e.User.Nick))
-		}(e)
+		if irc.saslLoggedIn() {
+			return // we join on 001
+		}
+		logger.Log.Infoln("Successfully identified")
+		irc.autojoin(e)
+	}).AddEventCallback("JOIN", func(e *Event) {
+		irc.trackChannels(e)
+		irc.trackSelf(e)
+	}).AddEventCallback("396", func(e *Event) {
+		irc.trackSelf(e)
+	}).AddEventCallback("CHGHOST", func(e *Event) {
+		irc.trackSelf(e)
+	}).AddEventCallback("PART", func(e *Event) {
+		irc.trackChannels(e)
+	}).AddEventCallback("KICK", func(e *Event) {
+		irc.trackChannels(e)
+	})
+
+	irc.OnInvite(func(i *InviteEvent) {
+		// with invite-notify, we're told about the others' invites too
+		if i.User == nil || !irc.isMe(i.Nick) {
+			return
+		}
+		irc.Join(i.Channel)
+		irc.PrivMsg(i.Channel, fmt.Sprintf("Hi %s, %s invited me here.", i.Channel, i.User.Nick))
 	})
+
+	for code := range saslNumerics {
+		irc.AddEventCallback(code, irc.handleSASLResult)
+	}
+	for code := range nickErrors {
+		irc.AddEventCallback(code, irc.handleNickError)
+	}
+	for _, code := range []string{"QUIT", "NICK", "731"} {
+		irc.AddEventCallback(code, irc.handleRegain)
+	}
+	// the end of the MOTD, after ISUPPORT, telling us if MONITOR is supported
+	for _, code := range []string{"376", "422"} {
+		irc.AddEventCallback(code, func(e *Event) { irc.startRegain() })
+	}
 }
 
 func (irc *IRC) autojoin(e *Event) {
-	logger.Log.Infoln("Successfully identified to Nickserv")
-	for _, v := range irc.Server.Channels {
+	for _, v := range irc.rejoinChannels() {
 		logger.Log.Infof("Joining channel %q", v)
 		irc.Join(v)
 	}
 }
 
-// Quit provides a wrapper to sending a value into the `quit` channel.
-func (irc *IRC) Quit() {
-	irc.quit <- struct{}{}
-}
-
-// IsAdmin returns whether or not the specified user is an admin.
+// IsAdmin returns whether or not the specified user is an admin, i.e. has the admin role, matching
+// the account currently known for the nickname. In a callback, use HasRole with the event.
 func (irc *IRC) IsAdmin(u *User) bool {
-	if u == nil {
-		return false
-	}
-	for _, v := range irc.Server.Admins {
-		if u.Nick == v {
-			return true
-		}
-	}
-	return false
+	return u != nil && irc.acl.HasRole(u, irc.Account(u.Nick), RoleAdmin)
 }
 
-// IsIgnored returns whether or not the specified user is ignored.
+// IsIgnored returns whether or not the specified user is ignored, i.e. has the ignored role,
+// matching the account currently known for the nickname. In a callback, use HasRole with the event.
 func (irc *IRC) IsIgnored(u *User) bool {
-	if u == nil {
-		return false
-	}
-	for _, v := range irc.Server.Ignore {
-		if u.Nick == v {
-			return true
-		}
-	}
-	return false
+	return u != nil && irc.acl.HasRole(u, irc.Account(u.Nick), RoleIgnored)
 }
 
-// New returns a pointer to a new IRC struct using the server & wait group specified.
-func New(server *config.Server, wg *sync.WaitGroup) *IRC {
+// New returns a pointer to a new IRC struct using the server specified.
+func New(server *config.Server) *IRC {
 	logger.Log.WithFields(logger.Fields(map[string]interface{}{
 		"server": server.Address, "port": server.Port,
 	})).Infoln("Generating new server connection")
@@ -293,14 +596,37 @@ func New(server *config.Server, wg *sync.WaitGroup) *IRC {
 	i := &IRC{
 		Server: server,
 
-		Events: make(map[string][]func(*Event)),
+		Events: make(map[string][]*Callback),
+
+		codeMiddleware: make(map[string][]Middleware),
+
+		raw:  make(chan rawLine),
+		stop: make(chan struct{}),
+	}
 
-		Waiter: wg,
+	i.session.channels = make(map[string]string)
+	i.isupport = newISupport()
+	i.tracker = newTracker(i.isupport)
+	i.acl = newACL(i.isupport)
+	i.acl.load(server)
 
-		raw:  make(chan string),
-		quit: make(chan struct{}, 1),
+	i.State.Capabilities = make(map[string]string)
+	i.caps.wanted = make(map[string]bool)
+	for _, c := range server.Capabilities {
+		i.caps.wanted[c] = true
+	}
+	if server.SASLMechanism != "" {
+		i.caps.wanted["sasl"] = true
 	}
+	// used to match the "$a:account" masks
+	for _, c := range []string{"account-notify", "account-tag", "extended-join"} {
+		i.caps.wanted[c] = true
+	}
+
+	i.ctcp.handlers = i.defaultCTCPHandlers()
+	i.ctcp.limit = newCTCPLimit()
 
+	i.Use(i.ignoreMiddleware).Use(i.ctcpMiddleware)
 	i.addBasicCallbacks()
 
 	return i
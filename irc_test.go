@@ -1,29 +1,89 @@
 package gophirc
 
 import (
-	"strconv"
+	"bufio"
+	"context"
+	"net"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/irctest"
 )
 
-var wg sync.WaitGroup
 var irc *IRC
+var cancel context.CancelFunc
+var run = make(chan error, 1)
 
-var channel = "#gophirc_test" + strconv.Itoa(time.Now().Second())
+// server is the in-process IRC server the connection tests run against.
+var server *irctest.Server
+
+var channel = "#gophirc_test"
+
+// pipeIRC returns an IRC connected to one end of an in-memory pipe,
+// along with a channel receiving the lines sent by the client.
+func pipeIRC(t *testing.T, server *config.Server) (*IRC, <-chan string) {
+	client, srv := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	i := New(server)
+	i.setConn(client)
+
+	lines := make(chan string, 100)
+	go func() {
+		s := bufio.NewScanner(srv)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+
+	return i, lines
+}
+
+// expectLines fails the test if the next lines sent by the client aren't the expected ones.
+func expectLines(t *testing.T, lines <-chan string, expected ...string) {
+	t.Helper()
+	for _, e := range expected {
+		select {
+		case l := <-lines:
+			if l != e {
+				t.Fatalf("Expected line %q, got %q instead.", e, l)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected line %q, got nothing.", e)
+		}
+	}
+}
+
+// expectNoLines fails the test if the client sends anything in the next 50 milliseconds.
+func expectNoLines(t *testing.T, lines <-chan string) {
+	t.Helper()
+	select {
+	case l := <-lines:
+		t.Fatalf("Expected no lines, got %q.", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
 
 func TestNew(t *testing.T) {
 	// no error checking, config tests imply this is working
 	conf, _ := config.Parse("config/config.json.example")
 	conf.Check()
 
-	server, ok := conf.Servers["freenode"]
+	s, ok := conf.Servers["freenode"]
 	if !ok {
 		t.Fatal("Can't find specified server")
 	}
-	irc = New(server, &wg)
+
+	var err error
+	if server, err = irctest.NewServer(); err != nil {
+		t.Fatal("Can't start the test server", err)
+	}
+	s.Address, s.Port = server.Host(), server.Port()
+	irc = New(s)
 
 	if irc.Server == nil {
 		t.Fatalf("Server is nil: %+v\n", irc)
@@ -55,8 +115,12 @@ func TestIRC_AddEventCallback(t *testing.T) {
 	}
 }
 
-func TestIRC_Loop(t *testing.T) {
-	go irc.Loop()
+func TestIRC_Run(t *testing.T) {
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		run <- irc.Run(ctx)
+	}()
 }
 
 func TestIRC_Identify(t *testing.T) {
@@ -69,17 +133,21 @@ func TestIRC_Identify(t *testing.T) {
 func TestIRC_Join(t *testing.T) {
 	var w sync.WaitGroup
 
-	w.Add(1)
-	irc.AddEventCallback("376", func(event *Event) {
-		irc.Join(channel)
-		w.Done()
-	})
+	// the server answers the lines in order, the JOIN can follow the end of the registration
+	if _, err := server.Expect("CAP END", 5*time.Second); err != nil {
+		t.Fatal("Bot did not register", err)
+	}
 
 	w.Add(1)
 	irc.AddEventCallback("JOIN", func(event *Event) { // 366
 		w.Done()
 	})
+	irc.Join(channel)
 	w.Wait()
+
+	if members := server.Members(channel); len(members) != 1 || members[0] != "@"+irc.Server.Nickname {
+		t.Errorf("Expected the bot in %s, got %q instead.", channel, members)
+	}
 }
 
 func TestIRC_PrivMsgf(t *testing.T) {
@@ -114,8 +182,10 @@ func TestIRC_Part(t *testing.T) {
 }
 
 func TestIRC_Quit(t *testing.T) {
-	irc.Quit()
-	wg.Wait()
+	cancel()
+	if err := <-run; err != context.Canceled {
+		t.Errorf("Expected %q, got %q", context.Canceled, err)
+	}
 
 	if irc.State.Registered == false {
 		t.Error("Bot did not register")
@@ -144,10 +214,17 @@ func TestIRC_Disconnect(t *testing.T) {
 		t.Error("Disconnected should not be requested")
 	}
 
-	go irc.Loop()
-	time.Sleep(3 * time.Second)
+	go func() {
+		run <- irc.Run(context.Background())
+	}()
+	if _, err := server.Expect("CAP END", 5*time.Second); err != nil {
+		t.Fatal("Bot did not register again", err)
+	}
 
 	irc.Disconnect("")
+	if err := <-run; err != nil {
+		t.Error("Run should return nil after Disconnect", err)
+	}
 
 	if irc.State.Disconnected.Value == false {
 		t.Error("Disconnected state should not be false")
@@ -156,4 +233,176 @@ func TestIRC_Disconnect(t *testing.T) {
 	if irc.State.Disconnected.Requested == false {
 		t.Error("Disconnected should be requested")
 	}
+	server.Close()
+}
+
+func TestIRC_ParseToEvent(t *testing.T) {
+	i := New(&config.Server{Nickname: "gophirc"})
+
+	tests := []struct {
+		raw       string
+		ok        bool
+		code      string
+		arguments []string
+		message   string
+		replyTo   string
+	}{
+		{"", false, "", nil, "", ""},
+		{"PING :irc.server.tld", true, "PING", []string{"irc.server.tld"}, "", ""},
+		{"ERROR :Closing Link", true, "ERROR", []string{"Closing Link"}, "", ""},
+		{":a!b@c PRIVMSG #chan :hello  there ", true, "PRIVMSG", []string{"#chan", "hello  there "}, "hello  there", "#chan"},
+		{":a!b@c PRIVMSG gophirc :hi", true, "PRIVMSG", []string{"gophirc", "hi"}, "hi", "a"},
+		{":a!b@c PRIVMSG #chan :", true, "PRIVMSG", []string{"#chan", ""}, "", "#chan"},
+		{":a!b@c PRIVMSG gophirc :\001VERSION\001", true, "ctcp:VERSION", []string{}, "VERSION", "a"},
+		{":a!b@c PRIVMSG #chan :\001ACTION waves\001", true, "ctcp:ACTION", []string{"waves"}, "ACTION waves", "#chan"},
+		{":a!b@c INVITE gophirc :#chan", true, "INVITE", []string{"gophirc", "#chan"}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			e, ok := i.ParseToEvent(test.raw)
+			if ok != test.ok {
+				t.Fatalf("%q: expected ok %v, got %v instead.", test.raw, test.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if e.Code != test.code || e.Message != test.message || e.ReplyTo != test.replyTo {
+				t.Errorf("%q: expected %q/%q/%q, got %q/%q/%q instead.", test.raw,
+					test.code, test.message, test.replyTo, e.Code, e.Message, e.ReplyTo)
+			}
+			if !reflect.DeepEqual(e.Arguments, test.arguments) {
+				t.Errorf("%q: expected arguments %q, got %q instead.", test.raw, test.arguments, e.Arguments)
+			}
+		})
+	}
+}
+
+func TestIRC_RunCancel(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(l.Addr().(*net.TCPAddr).Port), QuitMessage: "bye",
+	})
+
+	started := make(chan struct{})
+	var finished bool
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		finished = true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(ctx)
+	}()
+
+	c, _ := l.Accept()
+	c.Write([]byte(":a!b@c PRIVMSG #chan :hello\r\n"))
+	<-started
+	cancel()
+
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	if line != "QUIT :bye\r\n" {
+		t.Errorf("Expected the quit message, got %q", line)
+	}
+	c.Close()
+
+	if err := <-run; err != context.Canceled {
+		t.Errorf("Expected %q, got %q", context.Canceled, err)
+	}
+	if !finished {
+		t.Error("Run returned before the callback finished")
+	}
+}
+
+func TestIRC_RunConnectionClosed(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(l.Addr().(*net.TCPAddr).Port),
+		Reconnect: config.Reconnect{Disabled: true},
+	})
+
+	go func() {
+		c, _ := l.Accept()
+		c.Close()
+	}()
+
+	if err := i.Run(context.Background()); err != ErrConnectionClosed {
+		t.Errorf("Expected %q, got %q", ErrConnectionClosed, err)
+	}
+	if !i.State.Disconnected.Value || i.State.Disconnected.Requested {
+		t.Errorf("Expected an unrequested disconnect, got %+v", i.State.Disconnected)
+	}
+}
+
+func TestIRC_RunDialError(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	l.Close()
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(l.Addr().(*net.TCPAddr).Port),
+		Reconnect: config.Reconnect{Disabled: true},
+	})
+	if err := i.Run(context.Background()); err == nil {
+		t.Error("Expected a dial error, got nil")
+	}
+}
+
+func TestIRC_TestServer(t *testing.T) {
+	server, err := irctest.NewServer()
+	if err != nil {
+		t.Fatal("Can't start the test server", err)
+	}
+	defer server.Close()
+
+	c, err := server.Config("bot")
+	if err != nil {
+		t.Fatal("Invalid test server config", err)
+	}
+	i := New(c)
+	i.OnPrivMsg(func(m *MessageEvent) {
+		if m.Text == "!ping" {
+			i.PrivMsg(m.ReplyTo, "pong")
+		}
+	})
+	if err := i.Connect(); err != nil {
+		t.Fatal("Couldn't connect to the test server", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(ctx)
+	}()
+
+	if _, err := server.Expect("CAP END", time.Second); err != nil {
+		t.Fatal("Bot did not register", err)
+	}
+	i.Join("#chan")
+	if _, err := server.Expect("JOIN #chan", time.Second); err != nil {
+		t.Fatal("Bot did not join", err)
+	}
+
+	server.Send("bot", ":someone!user@host PRIVMSG bot :!ping")
+	if _, err := server.Expect("PRIVMSG someone :pong", time.Second); err != nil {
+		t.Error("Bot did not answer in private", err)
+	}
+
+	server.Send("bot", ":someone!user@host PRIVMSG #chan :!ping")
+	if _, err := server.Expect("PRIVMSG #chan :pong", time.Second); err != nil {
+		t.Error("Bot did not answer in the channel", err)
+	}
+
+	cancel()
+	if err := <-run; err != context.Canceled {
+		t.Errorf("Expected %q, got %q instead.", context.Canceled, err)
+	}
+	if _, err := server.Expect("QUIT", time.Second); err != nil {
+		t.Error("Bot did not quit", err)
+	}
 }
@@ -0,0 +1,775 @@
+// Package irctest provides an in-process IRC server listening on a local port, to test the bots
+// offline. It speaks enough of the protocol to drive an IRC end-to-end: the registration, JOIN,
+// PART, PRIVMSG & NOTICE fan-out, NAMES, MODE, TOPIC, KICK, PING & QUIT.
+// The tests can send their own lines to the clients & wait for the lines the clients sent.
+package irctest
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vlad-s/gophirc/config"
+)
+
+// ErrNoSuchNick is returned by Send when no client uses the nickname.
+var ErrNoSuchNick = errors.New("No such nick")
+
+// ErrTimeout is returned by Expect when no matching line is received in time.
+var ErrTimeout = errors.New("Timed out waiting for the line")
+
+// Line is a line sent by a client to the server.
+type Line struct {
+	Nick string // the client's nickname when it sent the line, "*" before it chose one
+	Raw  string
+}
+
+// Server is an IRC server for the tests. The clients are registered once they send NICK & USER,
+// and CAP END if they started the capability negotiation; no capabilities are offered.
+type Server struct {
+	Name    string // the server's name, the source of its lines
+	Network string // advertised in ISUPPORT
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	clients  map[*client]bool
+	nicks    map[string]*client // by folded nickname
+	channels map[string]*channel
+	lines    []Line
+	next     int           // first line Expect hasn't looked at yet
+	notify   chan struct{} // closed & replaced on every line received
+	closed   bool
+}
+
+// client is a connection to the server.
+type client struct {
+	conn net.Conn
+	wmu  sync.Mutex
+
+	nick, user, realname, host string
+	negotiating                bool // CAP LS or REQ received, waiting for CAP END
+	registered                 bool
+}
+
+// channel is a channel on the server, with its members & their prefixes ("@", "+").
+type channel struct {
+	name    string
+	topic   string
+	modes   map[byte]string
+	members map[*client]string
+}
+
+// NewServer starts a server listening on a random local port.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't listen on a local port")
+	}
+
+	s := &Server{
+		Name:     "irc.test",
+		Network:  "IRCTest",
+		listener: l,
+		clients:  make(map[*client]bool),
+		nicks:    make(map[string]*client),
+		channels: make(map[string]*channel),
+		notify:   make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Host returns the address the server listens on.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Config returns a checked server config connecting to this server with the nickname, the flood
+// protection disabled so the tests don't wait on it. It fails if the nickname isn't valid.
+func (s *Server) Config(nick string) (*config.Server, error) {
+	server := &config.Server{
+		Address: s.Host(), Port: s.Port(),
+		Nickname: nick, Username: nick, Realname: nick,
+		Flood: config.Flood{Disabled: true},
+	}
+
+	c := &config.Config{Servers: map[string]*config.Server{s.Network: server}}
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Close stops listening & closes the connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Send sends a raw line to the client using the nickname, e.g. ":someone!u@h PRIVMSG bot :hi".
+func (s *Server) Send(nick, line string) error {
+	s.mu.Lock()
+	c, ok := s.nicks[fold(nick)]
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrNoSuchNick
+	}
+	c.send(line)
+	return nil
+}
+
+// Broadcast sends a raw line to every client connected.
+func (s *Server) Broadcast(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		c.send(line)
+	}
+}
+
+// Lines returns all the lines received from the clients, in order.
+func (s *Server) Lines() []Line {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Line{}, s.lines...)
+}
+
+// Expect waits for a line starting with the prefix, received after the last line returned by
+// Expect, & returns it. The lines skipped over aren't looked at again.
+func (s *Server) Expect(prefix string, timeout time.Duration) (Line, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		for s.next < len(s.lines) {
+			l := s.lines[s.next]
+			s.next++
+			if strings.HasPrefix(l.Raw, prefix) {
+				s.mu.Unlock()
+				return l, nil
+			}
+		}
+		notify := s.notify
+		s.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return Line{}, errors.Wrapf(ErrTimeout, "%q", prefix)
+		}
+	}
+}
+
+// Members returns the nicknames in the channel, with their prefixes, sorted.
+func (s *Server) Members(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[fold(name)]
+	if !ok {
+		return nil
+	}
+	var members []string
+	for c, prefix := range ch.members {
+		members = append(members, prefix+c.nick)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{conn: conn, nick: "*", host: "localhost"}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve reads the client's lines until it quits or the connection ends.
+func (s *Server) serve(c *client) {
+	defer s.wg.Done()
+	defer c.conn.Close()
+
+	c.send(":" + s.Name + " NOTICE * :*** Looking up your hostname...")
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.mu.Lock()
+		s.lines = append(s.lines, Line{Nick: c.nick, Raw: line})
+		close(s.notify)
+		s.notify = make(chan struct{})
+		quit := s.handle(c, line)
+		s.mu.Unlock()
+
+		if quit {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.quit(c, "Connection closed")
+	s.mu.Unlock()
+}
+
+func (c *client) send(line string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+func (c *client) prefix() string {
+	return c.nick + "!" + c.user + "@" + c.host
+}
+
+// numeric sends a numeric reply to the client, the last parameter being the trailing one.
+func (s *Server) numeric(c *client, code string, params ...string) {
+	if len(params) > 0 {
+		params[len(params)-1] = ":" + params[len(params)-1]
+	}
+	c.send(strings.Join(append([]string{":" + s.Name, code, c.nick}, params...), " "))
+}
+
+// parse splits a line into its command & parameters, dropping the tags & the source.
+func parse(line string) (string, []string) {
+	if strings.HasPrefix(line, "@") {
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			line = strings.TrimLeft(line[i:], " ")
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			line = strings.TrimLeft(line[i:], " ")
+		}
+	}
+
+	var params []string
+	for line != "" {
+		if line[0] == ':' {
+			params = append(params, line[1:])
+			break
+		}
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			params = append(params, line)
+			break
+		}
+		params = append(params, line[:i])
+		line = strings.TrimLeft(line[i:], " ")
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+// fold returns the name in lower case, with the rfc1459 case mapping.
+func fold(name string) string {
+	return strings.NewReplacer("[", "{", "]", "}", `\`, "|", "~", "^").Replace(strings.ToLower(name))
+}
+
+// handle handles a line from the client, returning true if the client quit.
+// The server must be locked.
+func (s *Server) handle(c *client, line string) bool {
+	cmd, params := parse(line)
+
+	switch cmd {
+	case "":
+		return false
+	case "QUIT":
+		c.send("ERROR :Closing Link: " + c.host + " (Quit: " + last(params) + ")")
+		s.quit(c, "Quit: "+last(params))
+		return true
+	case "PING":
+		c.send(":" + s.Name + " PONG " + s.Name + " :" + last(params))
+		return false
+	case "PONG", "PASS":
+		return false
+	case "CAP":
+		s.handleCap(c, params)
+		return false
+	case "NICK":
+		s.handleNick(c, params)
+		return false
+	case "USER":
+		if len(params) < 4 {
+			s.numeric(c, "461", "USER", "Not enough parameters")
+			return false
+		}
+		c.user, c.realname = params[0], params[3]
+		s.register(c)
+		return false
+	}
+
+	if !c.registered {
+		s.numeric(c, "451", "You have not registered")
+		return false
+	}
+
+	switch cmd {
+	case "JOIN":
+		s.handleJoin(c, params)
+	case "PART":
+		s.handlePart(c, params)
+	case "PRIVMSG", "NOTICE":
+		s.handleMessage(c, cmd, params)
+	case "NAMES":
+		if len(params) > 0 {
+			s.names(c, params[0])
+		}
+	case "MODE":
+		s.handleMode(c, params)
+	case "TOPIC":
+		s.handleTopic(c, params)
+	case "KICK":
+		s.handleKick(c, params)
+	default:
+		s.numeric(c, "421", cmd, "Unknown command")
+	}
+	return false
+}
+
+// last returns the last parameter, or "" if there are none.
+func last(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	return params[len(params)-1]
+}
+
+func (s *Server) handleCap(c *client, params []string) {
+	if len(params) == 0 {
+		return
+	}
+	switch strings.ToUpper(params[0]) {
+	case "LS":
+		c.negotiating = true
+		c.send(":" + s.Name + " CAP " + c.nick + " LS :")
+	case "LIST":
+		c.send(":" + s.Name + " CAP " + c.nick + " LIST :")
+	case "REQ":
+		c.negotiating = true
+		c.send(":" + s.Name + " CAP " + c.nick + " NAK :" + last(params))
+	case "END":
+		c.negotiating = false
+		s.register(c)
+	}
+}
+
+func (s *Server) handleNick(c *client, params []string) {
+	if len(params) == 0 || params[0] == "" {
+		s.numeric(c, "431", "No nickname given")
+		return
+	}
+	nick := params[0]
+	if strings.ContainsAny(nick, " ,*?!@#&:") {
+		s.numeric(c, "432", nick, "Erroneous Nickname")
+		return
+	}
+	if other, ok := s.nicks[fold(nick)]; ok && other != c {
+		s.numeric(c, "433", nick, "Nickname is already in use")
+		return
+	}
+
+	if c.registered {
+		line := ":" + c.prefix() + " NICK :" + nick
+		for other := range s.common(c) {
+			other.send(line)
+		}
+	}
+	if c.nick != "*" {
+		delete(s.nicks, fold(c.nick))
+	}
+	c.nick = nick
+	s.nicks[fold(nick)] = c
+	s.register(c)
+}
+
+// register completes the registration once we have the client's nickname & user, and the
+// capability negotiation is over.
+func (s *Server) register(c *client) {
+	if c.registered || c.nick == "*" || c.user == "" || c.negotiating {
+		return
+	}
+	c.registered = true
+
+	s.numeric(c, "001", "Welcome to the "+s.Network+" IRC Network "+c.prefix())
+	s.numeric(c, "002", "Your host is "+s.Name)
+	s.numeric(c, "005", "CHANTYPES=#", "PREFIX=(ov)@+", "CHANMODES=b,k,l,imnpst", "CASEMAPPING=rfc1459",
+		"NICKLEN=30", "NETWORK="+s.Network, "are supported by this server")
+	s.numeric(c, "375", "- "+s.Name+" Message of the day -")
+	s.numeric(c, "372", "- Welcome!")
+	s.numeric(c, "376", "End of /MOTD command.")
+}
+
+// common returns the clients sharing a channel with the client, the client included.
+func (s *Server) common(c *client) map[*client]bool {
+	clients := map[*client]bool{c: true}
+	for _, ch := range s.channels {
+		if _, ok := ch.members[c]; ok {
+			for m := range ch.members {
+				clients[m] = true
+			}
+		}
+	}
+	return clients
+}
+
+// quit removes the client, telling the clients sharing a channel with it.
+func (s *Server) quit(c *client, reason string) {
+	if !s.clients[c] {
+		return
+	}
+
+	line := ":" + c.prefix() + " QUIT :" + reason
+	for other := range s.common(c) {
+		if other != c {
+			other.send(line)
+		}
+	}
+	for key, ch := range s.channels {
+		delete(ch.members, c)
+		if len(ch.members) == 0 {
+			delete(s.channels, key)
+		}
+	}
+	if s.nicks[fold(c.nick)] == c {
+		delete(s.nicks, fold(c.nick))
+	}
+	delete(s.clients, c)
+}
+
+// broadcast sends the line to the channel's members.
+func (ch *channel) broadcast(line string, except *client) {
+	for m := range ch.members {
+		if m != except {
+			m.send(line)
+		}
+	}
+}
+
+func (s *Server) handleJoin(c *client, params []string) {
+	if len(params) == 0 {
+		s.numeric(c, "461", "JOIN", "Not enough parameters")
+		return
+	}
+
+	for _, name := range strings.Split(params[0], ",") {
+		if !strings.HasPrefix(name, "#") {
+			s.numeric(c, "403", name, "No such channel")
+			continue
+		}
+
+		ch, ok := s.channels[fold(name)]
+		if !ok {
+			ch = &channel{name: name, modes: make(map[byte]string), members: make(map[*client]string)}
+			s.channels[fold(name)] = ch
+		}
+		if _, in := ch.members[c]; in {
+			continue
+		}
+
+		if len(ch.members) == 0 {
+			ch.members[c] = "@"
+		} else {
+			ch.members[c] = ""
+		}
+		ch.broadcast(":"+c.prefix()+" JOIN "+ch.name, nil)
+
+		if ch.topic != "" {
+			s.numeric(c, "332", ch.name, ch.topic)
+		}
+		s.names(c, ch.name)
+	}
+}
+
+func (s *Server) handlePart(c *client, params []string) {
+	if len(params) == 0 {
+		s.numeric(c, "461", "PART", "Not enough parameters")
+		return
+	}
+
+	reason := ""
+	if len(params) > 1 {
+		reason = " :" + params[1]
+	}
+	for _, name := range strings.Split(params[0], ",") {
+		ch, ok := s.channels[fold(name)]
+		if !ok {
+			s.numeric(c, "403", name, "No such channel")
+			continue
+		}
+		if _, in := ch.members[c]; !in {
+			s.numeric(c, "442", ch.name, "You're not on that channel")
+			continue
+		}
+
+		ch.broadcast(":"+c.prefix()+" PART "+ch.name+reason, nil)
+		delete(ch.members, c)
+		if len(ch.members) == 0 {
+			delete(s.channels, fold(name))
+		}
+	}
+}
+
+func (s *Server) handleMessage(c *client, cmd string, params []string) {
+	if len(params) < 2 || params[1] == "" {
+		if cmd == "PRIVMSG" {
+			s.numeric(c, "412", "No text to send")
+		}
+		return
+	}
+
+	for _, target := range strings.Split(params[0], ",") {
+		line := ":" + c.prefix() + " " + cmd + " " + target + " :" + params[1]
+		if strings.HasPrefix(target, "#") {
+			ch, ok := s.channels[fold(target)]
+			if !ok {
+				if cmd == "PRIVMSG" {
+					s.numeric(c, "403", target, "No such channel")
+				}
+				continue
+			}
+			if _, in := ch.members[c]; !in {
+				if cmd == "PRIVMSG" {
+					s.numeric(c, "404", ch.name, "Cannot send to channel")
+				}
+				continue
+			}
+			ch.broadcast(line, c)
+			continue
+		}
+
+		other, ok := s.nicks[fold(target)]
+		if !ok {
+			if cmd == "PRIVMSG" {
+				s.numeric(c, "401", target, "No such nick/channel")
+			}
+			continue
+		}
+		other.send(line)
+	}
+}
+
+// names sends the channel's members to the client.
+func (s *Server) names(c *client, name string) {
+	if ch, ok := s.channels[fold(name)]; ok {
+		var names []string
+		for m, prefix := range ch.members {
+			names = append(names, prefix+m.nick)
+		}
+		sort.Strings(names)
+		s.numeric(c, "353", "=", ch.name, strings.Join(names, " "))
+	}
+	s.numeric(c, "366", name, "End of /NAMES list.")
+}
+
+func (s *Server) handleMode(c *client, params []string) {
+	if len(params) == 0 {
+		s.numeric(c, "461", "MODE", "Not enough parameters")
+		return
+	}
+
+	target := params[0]
+	if !strings.HasPrefix(target, "#") {
+		if fold(target) != fold(c.nick) {
+			s.numeric(c, "502", "Can't change mode for other users")
+			return
+		}
+		if len(params) > 1 {
+			c.send(":" + c.nick + " MODE " + c.nick + " :" + params[1])
+		}
+		return
+	}
+
+	ch, ok := s.channels[fold(target)]
+	if !ok {
+		s.numeric(c, "403", target, "No such channel")
+		return
+	}
+	if len(params) == 1 {
+		modes, args := ch.modeString()
+		s.numeric(c, "324", append([]string{ch.name, modes}, args...)...)
+		return
+	}
+	if (params[1] == "b" || params[1] == "+b") && len(params) == 2 {
+		s.numeric(c, "368", ch.name, "End of channel ban list")
+		return
+	}
+	if !strings.Contains(ch.members[c], "@") {
+		s.numeric(c, "482", ch.name, "You're not channel operator")
+		return
+	}
+
+	args := params[2:]
+	set := true
+	for i := 0; i < len(params[1]); i++ {
+		mode := params[1][i]
+		switch mode {
+		case '+', '-':
+			set = mode == '+'
+		case 'o', 'v':
+			if len(args) == 0 {
+				continue
+			}
+			nick := args[0]
+			args = args[1:]
+			m, ok := s.nicks[fold(nick)]
+			if _, in := ch.members[m]; !ok || !in {
+				s.numeric(c, "441", nick, ch.name, "They aren't on that channel")
+				return
+			}
+			prefix := map[byte]string{'o': "@", 'v': "+"}[mode]
+			ch.members[m] = strings.Replace(ch.members[m], prefix, "", -1)
+			if set {
+				ch.members[m] = sortPrefixes(ch.members[m] + prefix)
+			}
+		case 'k', 'l':
+			if set && len(args) > 0 {
+				ch.modes[mode], args = args[0], args[1:]
+			} else {
+				delete(ch.modes, mode)
+				if mode == 'k' && len(args) > 0 {
+					args = args[1:]
+				}
+			}
+		case 'b':
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		default:
+			if set {
+				ch.modes[mode] = ""
+			} else {
+				delete(ch.modes, mode)
+			}
+		}
+	}
+	ch.broadcast(":"+c.prefix()+" MODE "+strings.Join(params, " "), nil)
+}
+
+// sortPrefixes orders the prefixes from the highest rank to the lowest, dropping duplicates.
+func sortPrefixes(prefixes string) string {
+	sorted := ""
+	for _, p := range "@+" {
+		if strings.ContainsRune(prefixes, p) {
+			sorted += string(p)
+		}
+	}
+	return sorted
+}
+
+// modeString returns the channel's modes & their parameters, e.g. "+nl", "10".
+func (ch *channel) modeString() (string, []string) {
+	var modes []byte
+	for m := range ch.modes {
+		modes = append(modes, m)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i] < modes[j] })
+
+	var args []string
+	for _, m := range modes {
+		if v := ch.modes[m]; v != "" {
+			args = append(args, v)
+		}
+	}
+	return "+" + string(modes), args
+}
+
+func (s *Server) handleTopic(c *client, params []string) {
+	if len(params) == 0 {
+		s.numeric(c, "461", "TOPIC", "Not enough parameters")
+		return
+	}
+
+	ch, ok := s.channels[fold(params[0])]
+	if !ok {
+		s.numeric(c, "403", params[0], "No such channel")
+		return
+	}
+	if len(params) == 1 {
+		if ch.topic == "" {
+			s.numeric(c, "331", ch.name, "No topic is set")
+		} else {
+			s.numeric(c, "332", ch.name, ch.topic)
+		}
+		return
+	}
+	if _, in := ch.members[c]; !in {
+		s.numeric(c, "442", ch.name, "You're not on that channel")
+		return
+	}
+	if _, protected := ch.modes['t']; protected && !strings.Contains(ch.members[c], "@") {
+		s.numeric(c, "482", ch.name, "You're not channel operator")
+		return
+	}
+
+	ch.topic = params[1]
+	ch.broadcast(":"+c.prefix()+" TOPIC "+ch.name+" :"+ch.topic, nil)
+}
+
+func (s *Server) handleKick(c *client, params []string) {
+	if len(params) < 2 {
+		s.numeric(c, "461", "KICK", "Not enough parameters")
+		return
+	}
+
+	ch, ok := s.channels[fold(params[0])]
+	if !ok {
+		s.numeric(c, "403", params[0], "No such channel")
+		return
+	}
+	if !strings.Contains(ch.members[c], "@") {
+		s.numeric(c, "482", ch.name, "You're not channel operator")
+		return
+	}
+	m, ok := s.nicks[fold(params[1])]
+	if _, in := ch.members[m]; !ok || !in {
+		s.numeric(c, "441", params[1], ch.name, "They aren't on that channel")
+		return
+	}
+
+	reason := c.nick
+	if len(params) > 2 {
+		reason = params[2]
+	}
+	ch.broadcast(":"+c.prefix()+" KICK "+ch.name+" "+m.nick+" :"+reason, nil)
+	delete(ch.members, m)
+}
@@ -0,0 +1,254 @@
+package irctest
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient is a raw connection to the server.
+type testClient struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+func dial(t *testing.T, s *Server, nick string) *testClient {
+	conn, err := net.Dial("tcp", net.JoinHostPort(s.Host(), strconv.Itoa(int(s.Port()))))
+	if err != nil {
+		t.Fatal("Can't connect to the server", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testClient{t: t, conn: conn, lines: make(chan string, 100)}
+	go func() {
+		r := bufio.NewScanner(conn)
+		for r.Scan() {
+			c.lines <- r.Text()
+		}
+		close(c.lines)
+	}()
+
+	c.expect(":irc.test NOTICE * :*** Looking up")
+	if nick != "" {
+		c.send("NICK " + nick)
+		c.send("USER " + nick + " 0 * :Real Name")
+		c.expect(":irc.test 376 " + nick)
+	}
+	return c
+}
+
+func (c *testClient) send(line string) {
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+// expect skips the lines until one starts with the prefix.
+func (c *testClient) expect(prefix string) string {
+	c.t.Helper()
+	for {
+		select {
+		case l, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("Expected a line starting with %q, got the connection closed.", prefix)
+			}
+			if strings.HasPrefix(l, prefix) {
+				return l
+			}
+		case <-time.After(time.Second):
+			c.t.Fatalf("Expected a line starting with %q, got nothing.", prefix)
+		}
+	}
+}
+
+// expectNothing fails if the client receives a line in the next 50 milliseconds.
+func (c *testClient) expectNothing() {
+	c.t.Helper()
+	select {
+	case l := <-c.lines:
+		c.t.Fatalf("Expected no lines, got %q.", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// drain discards the lines received until none comes in 50 milliseconds.
+func (c *testClient) drain() {
+	for {
+		select {
+		case <-c.lines:
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func newServer(t *testing.T) *Server {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestServer_Registration(t *testing.T) {
+	s := newServer(t)
+	c := dial(t, s, "")
+
+	tests := []struct {
+		name     string
+		send     string
+		expected string
+	}{
+		{"not registered", "JOIN #chan", ":irc.test 451 * :You have not registered"},
+		{"cap ls", "CAP LS 302", ":irc.test CAP * LS :"},
+		{"cap req", "CAP REQ :multi-prefix", ":irc.test CAP * NAK :multi-prefix"},
+		{"erroneous nick", "NICK #bot", ":irc.test 432 * #bot :Erroneous Nickname"},
+		{"ping", "PING :token", ":irc.test PONG irc.test :token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c.t = t
+			c.send(test.send)
+			if l := c.expect(":irc.test"); l != test.expected {
+				t.Errorf("Expected %q, got %q instead.", test.expected, l)
+			}
+		})
+	}
+
+	c.t = t
+	c.send("NICK bot")
+	c.send("USER bot 0 * :Real Name")
+	c.expectNothing() // waiting for CAP END
+	c.send("CAP END")
+	c.expect(":irc.test 001 bot :Welcome to the IRCTest IRC Network bot!bot@localhost")
+	c.expect(":irc.test 005 bot CHANTYPES=#")
+	c.expect(":irc.test 376 bot")
+
+	other := dial(t, s, "")
+	other.send("NICK BOT")
+	other.expect(":irc.test 433 * BOT :Nickname is already in use")
+}
+
+func TestServer_Channels(t *testing.T) {
+	s := newServer(t)
+	alice, bob := dial(t, s, "alice"), dial(t, s, "bob")
+
+	alice.send("JOIN #chan")
+	alice.expect(":alice!alice@localhost JOIN #chan")
+	alice.expect(":irc.test 353 alice = #chan :@alice")
+	alice.expect(":irc.test 366 alice #chan")
+
+	bob.send("JOIN #Chan")
+	bob.expect(":bob!bob@localhost JOIN #chan")
+	bob.expect(":irc.test 353 bob = #chan :@alice bob")
+	bob.expect(":irc.test 366 bob #chan")
+	alice.expect(":bob!bob@localhost JOIN #chan")
+
+	tests := []struct {
+		name  string
+		from  *testClient
+		send  string
+		to    *testClient
+		other *testClient // expecting nothing
+		line  string
+	}{
+		{"channel message", alice, "PRIVMSG #chan :hi", bob, alice, ":alice!alice@localhost PRIVMSG #chan :hi"},
+		{"private message", bob, "PRIVMSG alice :psst", alice, bob, ":bob!bob@localhost PRIVMSG alice :psst"},
+		{"notice", bob, "NOTICE #chan :note", alice, bob, ":bob!bob@localhost NOTICE #chan :note"},
+		{"no such nick", alice, "PRIVMSG nobody :hi", alice, bob, ":irc.test 401 alice nobody :No such nick/channel"},
+		{"not an op", bob, "KICK #chan alice", bob, alice, ":irc.test 482 bob #chan :You're not channel operator"},
+		{"topic", bob, "TOPIC #chan :the topic", alice, nil, ":bob!bob@localhost TOPIC #chan :the topic"},
+		{"voice", alice, "MODE #chan +v bob", bob, nil, ":alice!alice@localhost MODE #chan +v bob"},
+		{"modes", alice, "MODE #chan +nl 10", bob, nil, ":alice!alice@localhost MODE #chan +nl 10"},
+		{"mode query", bob, "MODE #chan", bob, alice, ":irc.test 324 bob #chan +ln :10"},
+		{"names", bob, "NAMES #chan", bob, alice, ":irc.test 353 bob = #chan :+bob @alice"},
+		{"nick", bob, "NICK bobby", alice, nil, ":bob!bob@localhost NICK :bobby"},
+		{"kick", alice, "KICK #chan bobby :behave", bob, nil, ":alice!alice@localhost KICK #chan bobby :behave"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.from.t, test.to.t = t, t
+			test.from.send(test.send)
+			if l := test.to.expect(":"); l != test.line {
+				t.Errorf("Expected %q, got %q instead.", test.line, l)
+			}
+			if test.other != nil {
+				test.other.t = t
+				test.other.expectNothing()
+			}
+			// the channel broadcasts are echoed to the sender too
+			test.from.drain()
+			test.to.drain()
+		})
+	}
+
+	alice.t = t
+	if members := s.Members("#chan"); !reflect.DeepEqual(members, []string{"@alice"}) {
+		t.Errorf("Expected the members %q, got %q instead.", []string{"@alice"}, members)
+	}
+
+	bob.t = t
+	bob.send("JOIN #chan")
+	alice.expect(":bobby!bob@localhost JOIN #chan")
+	bob.send("QUIT :bye")
+	bob.expect("ERROR :Closing Link")
+	alice.expect(":bobby!bob@localhost QUIT :Quit: bye")
+
+	alice.send("PART #chan :later")
+	alice.expect(":alice!alice@localhost PART #chan :later")
+	if members := s.Members("#chan"); members != nil {
+		t.Errorf("Expected the channel to be gone, got %q.", members)
+	}
+}
+
+func TestServer_Script(t *testing.T) {
+	s := newServer(t)
+	c := dial(t, s, "bot")
+
+	if err := s.Send("BOT", ":someone!u@h PRIVMSG bot :hello"); err != nil {
+		t.Fatal("Send failed", err)
+	}
+	c.expect(":someone!u@h PRIVMSG bot :hello")
+
+	if err := s.Send("nobody", "PING :x"); err != ErrNoSuchNick {
+		t.Errorf("Expected %v, got %v instead.", ErrNoSuchNick, err)
+	}
+
+	s.Broadcast(":irc.test NOTICE * :maintenance")
+	c.expect(":irc.test NOTICE * :maintenance")
+
+	c.send("PRIVMSG someone :hi back")
+	l, err := s.Expect("PRIVMSG someone", time.Second)
+	if err != nil || l.Nick != "bot" || l.Raw != "PRIVMSG someone :hi back" {
+		t.Errorf("Expected the line from bot, got %+v (err %v) instead.", l, err)
+	}
+
+	// the lines before aren't looked at again
+	if _, err := s.Expect("NICK bot", 50*time.Millisecond); err == nil {
+		t.Error("Expected a timeout, got a line already returned.")
+	}
+
+	lines := s.Lines()
+	if len(lines) < 3 || lines[0].Nick != "*" || lines[0].Raw != "NICK bot" {
+		t.Errorf("Expected the registration lines first, got %+v.", lines)
+	}
+}
+
+func TestServer_Config(t *testing.T) {
+	s := newServer(t)
+
+	c, err := s.Config("bot")
+	if err != nil {
+		t.Fatal("Expected a valid config, got", err)
+	}
+	if c.Address != s.Host() || c.Port != s.Port() || c.Nickname != "bot" {
+		t.Errorf("Expected a config connecting to %s:%d as bot, got %+v", s.Host(), s.Port(), c)
+	}
+	if _, err := s.Config("b"); err == nil {
+		t.Error("Expected an error for a too short nickname, got nil")
+	}
+}
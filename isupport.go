@@ -0,0 +1,273 @@
+package gophirc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ISupport holds the features advertised by the server with RPL_ISUPPORT (005), like the
+// channel types, the nickname prefixes, or the case mapping. Until the server sends them,
+// the helpers return the defaults from RFC 1459.
+type ISupport struct {
+	sync.RWMutex
+	tokens map[string]string
+}
+
+// ModeChange is a single mode change parsed from a mode string, e.g. "+o nick".
+type ModeChange struct {
+	Set   bool
+	Mode  byte
+	Param string
+}
+
+func newISupport() *ISupport {
+	return &ISupport{tokens: make(map[string]string)}
+}
+
+// reset forgets the tokens received, used when connecting.
+func (s *ISupport) reset() {
+	s.Lock()
+	s.tokens = make(map[string]string)
+	s.Unlock()
+}
+
+// update parses the tokens of a 005 event: "<me> TOKEN=value TOKEN -TOKEN :are supported".
+func (s *ISupport) update(e *Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, token := range e.Arguments[1 : len(e.Arguments)-1] {
+		if strings.HasPrefix(token, "-") {
+			delete(s.tokens, strings.ToUpper(token[1:]))
+			continue
+		}
+		kv := strings.SplitN(token, "=", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = unescapeISupport(kv[1])
+		}
+		s.tokens[strings.ToUpper(kv[0])] = value
+	}
+}
+
+// unescapeISupport decodes the "\xHH" escapes used in the token values.
+func unescapeISupport(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if c, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// Value returns the value of a token & whether the server advertised it.
+func (s *ISupport) Value(token string) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	v, ok := s.tokens[strings.ToUpper(token)]
+	return v, ok
+}
+
+// value returns the value of a token, or the default if it's missing or empty.
+func (s *ISupport) value(token, def string) string {
+	if v, ok := s.Value(token); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// number returns the value of a numeric token, or the default if it's missing or invalid.
+func (s *ISupport) number(token string, def int) int {
+	n, err := strconv.Atoi(s.value(token, ""))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// Network returns the name of the network, if advertised.
+func (s *ISupport) Network() string {
+	return s.value("NETWORK", "")
+}
+
+// ChanTypes returns the prefixes of the channel names, "#&" by default. It's empty if the
+// server has no channels.
+func (s *ISupport) ChanTypes() string {
+	if v, ok := s.Value("CHANTYPES"); ok {
+		return v
+	}
+	return "#&"
+}
+
+// Prefix returns the channel modes giving a nickname prefix & their prefixes, in the same
+// order, from the highest rank to the lowest. By default "ov" & "@+".
+func (s *ISupport) Prefix() (modes, prefixes string) {
+	v := s.value("PREFIX", "(ov)@+")
+	if i := strings.IndexByte(v, ')'); strings.HasPrefix(v, "(") && i != -1 && len(v)-i-1 == i-1 {
+		return v[1:i], v[i+1:]
+	}
+	return "ov", "@+"
+}
+
+// OpPrefixes returns the prefixes of the channel operators & the ranks above them, e.g. "~&@".
+func (s *ISupport) OpPrefixes() string {
+	modes, prefixes := s.Prefix()
+	if i := strings.IndexByte(modes, 'o'); i != -1 {
+		return prefixes[:i+1]
+	}
+	return ""
+}
+
+// ChanModes returns the four groups of channel modes: the list modes, the modes always taking
+// a parameter, the modes taking a parameter only when set, and the modes without a parameter.
+func (s *ISupport) ChanModes() [4]string {
+	var groups [4]string
+	copy(groups[:], strings.SplitN(s.value("CHANMODES", "beI,k,l,imnpst"), ",", 4))
+	return groups
+}
+
+// CaseMapping returns the case mapping used to compare nicknames & channel names,
+// "rfc1459" by default.
+func (s *ISupport) CaseMapping() string {
+	return strings.ToLower(s.value("CASEMAPPING", "rfc1459"))
+}
+
+// NickLen returns the maximum length of a nickname, 9 by default.
+func (s *ISupport) NickLen() int {
+	return s.number("NICKLEN", 9)
+}
+
+// TopicLen returns the maximum length of a topic, 0 meaning no limit.
+func (s *ISupport) TopicLen() int {
+	return s.number("TOPICLEN", 0)
+}
+
+// Modes returns the maximum number of modes with a parameter in a MODE command, 3 by default.
+func (s *ISupport) Modes() int {
+	return s.number("MODES", 3)
+}
+
+// TargMax returns the maximum number of targets per command, e.g. "PRIVMSG" => 4.
+// The commands without a limit are present with 0.
+func (s *ISupport) TargMax() map[string]int {
+	targets := make(map[string]int)
+	for _, t := range strings.Split(s.value("TARGMAX", ""), ",") {
+		kv := strings.SplitN(t, ":", 2)
+		if kv[0] == "" {
+			continue
+		}
+		max := 0
+		if len(kv) == 2 {
+			max, _ = strconv.Atoi(kv[1])
+		}
+		targets[strings.ToUpper(kv[0])] = max
+	}
+	return targets
+}
+
+// IsChannel returns whether the name starts with one of the channel types.
+func (s *ISupport) IsChannel(name string) bool {
+	return name != "" && strings.IndexByte(s.ChanTypes(), name[0]) != -1
+}
+
+// Fold returns the name in lower case, according to the case mapping. With rfc1459, the
+// characters "[]\~" are the upper case of "{}|^"; strict-rfc1459 leaves out "~".
+func (s *ISupport) Fold(name string) string {
+	var upper, lower string
+	switch s.CaseMapping() {
+	case "ascii":
+	case "strict-rfc1459":
+		upper, lower = `[]\`, "{}|"
+	case "rfc1459":
+		upper, lower = `[]\~`, "{}|^"
+	default:
+		return strings.ToLower(name)
+	}
+
+	b := []byte(name)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		} else if j := strings.IndexByte(upper, c); j != -1 {
+			b[i] = lower[j]
+		}
+	}
+	return string(b)
+}
+
+// EqualFold returns whether the names are equal according to the case mapping.
+func (s *ISupport) EqualFold(a, b string) bool {
+	return s.Fold(a) == s.Fold(b)
+}
+
+// ParseModes parses a mode string like "+o-v+l nick nick 10" along with its parameters,
+// according to the prefix & channel modes advertised.
+func (s *ISupport) ParseModes(modes string, params []string) []ModeChange {
+	prefixModes, _ := s.Prefix()
+	groups := s.ChanModes()
+
+	var changes []ModeChange
+	set := true
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+		if mode == '+' || mode == '-' {
+			set = mode == '+'
+			continue
+		}
+
+		hasParam := false
+		switch {
+		case strings.IndexByte(prefixModes, mode) != -1,
+			strings.IndexByte(groups[0], mode) != -1,
+			strings.IndexByte(groups[1], mode) != -1:
+			hasParam = true
+		case strings.IndexByte(groups[2], mode) != -1:
+			hasParam = set
+		}
+
+		c := ModeChange{Set: set, Mode: mode}
+		if hasParam && len(params) > 0 {
+			c.Param, params = params[0], params[1:]
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// ISupport returns the features advertised by the server.
+func (irc *IRC) ISupport() *ISupport {
+	return irc.isupport
+}
+
+// IsChannel returns whether the name is a channel, according to the channel types advertised
+// by the server.
+func (irc *IRC) IsChannel(name string) bool {
+	return irc.isupport.IsChannel(name)
+}
+
+// EqualFold returns whether the nicknames or channel names are equal, according to the case
+// mapping advertised by the server.
+func (irc *IRC) EqualFold(a, b string) bool {
+	return irc.isupport.EqualFold(a, b)
+}
+
+// isMe returns whether the nickname is our current one.
+func (irc *IRC) isMe(nick string) bool {
+	return irc.EqualFold(nick, irc.CurrentNick())
+}
@@ -0,0 +1,135 @@
+package gophirc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestISupport_Update(t *testing.T) {
+	s := newISupport()
+	s.update(&Event{Arguments: []string{
+		"gophirc", "CHANTYPES=#!", "PREFIX=(qov)~@+", "EXCEPTS", "NETWORK=Some\\x20Net", "NICKLEN=30",
+		"TARGMAX=PRIVMSG:4,NOTICE:4,JOIN:", "MODES=bad", "are supported by this server",
+	}})
+	s.update(&Event{Arguments: []string{"gophirc", "-EXCEPTS", "are supported by this server"}})
+
+	if _, ok := s.Value("EXCEPTS"); ok {
+		t.Error("Token EXCEPTS should be removed")
+	}
+	if v, ok := s.Value("chantypes"); !ok || v != "#!" {
+		t.Errorf("Expected CHANTYPES %q, got %q (%v)", "#!", v, ok)
+	}
+	if actual := s.Network(); actual != "Some Net" {
+		t.Errorf("Expected network %q, got %q instead.", "Some Net", actual)
+	}
+	if modes, prefixes := s.Prefix(); modes != "qov" || prefixes != "~@+" {
+		t.Errorf("Expected prefix %q %q, got %q %q instead.", "qov", "~@+", modes, prefixes)
+	}
+	if actual := s.NickLen(); actual != 30 {
+		t.Errorf("Expected NICKLEN %d, got %d instead.", 30, actual)
+	}
+	if actual := s.Modes(); actual != 3 {
+		t.Errorf("Expected the default MODES %d, got %d instead.", 3, actual)
+	}
+	expected := map[string]int{"PRIVMSG": 4, "NOTICE": 4, "JOIN": 0}
+	if actual := s.TargMax(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected TARGMAX %v, got %v instead.", expected, actual)
+	}
+	if !s.IsChannel("!chan") || s.IsChannel("&chan") {
+		t.Error("Channel types not applied")
+	}
+	if actual := s.OpPrefixes(); actual != "~@" {
+		t.Errorf("Expected the op prefixes %q, got %q instead.", "~@", actual)
+	}
+
+	s.update(&Event{Arguments: []string{"gophirc", "CHANTYPES=", "are supported by this server"}})
+	if actual := s.ChanTypes(); actual != "" || s.IsChannel("#chan") {
+		t.Errorf("Expected no channel types, got %q instead.", actual)
+	}
+	if actual := newISupport().ChanTypes(); actual != "#&" {
+		t.Errorf("Expected the default channel types %q, got %q instead.", "#&", actual)
+	}
+}
+
+func TestISupport_Fold(t *testing.T) {
+	tests := []struct {
+		mapping  string
+		name     string
+		expected string
+	}{
+		{"", "Nick[]\\~", "nick{}|^"},
+		{"rfc1459", "NICK[]\\~", "nick{}|^"},
+		{"strict-rfc1459", "Nick[]\\~", "nick{}|~"},
+		{"ascii", "Nick[]\\~", "nick[]\\~"},
+		{"rfc7613", "NÍCK", "níck"},
+	}
+	for _, test := range tests {
+		t.Run(test.mapping, func(t *testing.T) {
+			s := newISupport()
+			if test.mapping != "" {
+				s.update(&Event{Arguments: []string{"gophirc", "CASEMAPPING=" + test.mapping, "are supported"}})
+			}
+			if actual := s.Fold(test.name); actual != test.expected {
+				t.Errorf("%q: expected %q, got %q instead.", test.name, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestISupport_ParseModes(t *testing.T) {
+	s := newISupport()
+	s.update(&Event{Arguments: []string{"gophirc", "PREFIX=(ohv)@%+", "CHANMODES=beI,k,lj,imnpst", "are supported"}})
+
+	tests := []struct {
+		modes    string
+		params   []string
+		expected []ModeChange
+	}{
+		{"+nt", nil, []ModeChange{{true, 'n', ""}, {true, 't', ""}}},
+		{"+o-h+v", []string{"a", "b", "c"}, []ModeChange{{true, 'o', "a"}, {false, 'h', "b"}, {true, 'v', "c"}}},
+		{"+l-l+j", []string{"10", "3:5"}, []ModeChange{{true, 'l', "10"}, {false, 'l', ""}, {true, 'j', "3:5"}}},
+		{"-k+b", []string{"key", "*!*@host"}, []ModeChange{{false, 'k', "key"}, {true, 'b', "*!*@host"}}},
+		{"+b", nil, []ModeChange{{true, 'b', ""}}},
+	}
+	for _, test := range tests {
+		t.Run(test.modes, func(t *testing.T) {
+			actual := s.ParseModes(test.modes, test.params)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("%q: expected %+v, got %+v instead.", test.modes, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIRC_ISupport(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"Admin[1]"}})
+
+	i.ReadEvent(":irc.server.tld 005 gophirc CHANTYPES=#+ PREFIX=(qov)~@+ CASEMAPPING=rfc1459 :are supported by this server")
+	i.ReadEvent(":GOPHIRC!u@h JOIN +chan")
+	i.ReadEvent(":irc.server.tld 353 gophirc = +chan :GOPHIRC ~Owner @op +foo[")
+
+	c, ok := i.Channel("+CHAN")
+	if !ok {
+		t.Fatal("Channel +chan not tracked")
+	}
+	if m, ok := c.Member("owner"); !ok || m.Prefixes != "~" {
+		t.Errorf("Expected owner with prefix %q, got %+v (%v)", "~", m, ok)
+	}
+	if m, ok := c.Member("FOO{"); !ok || !m.IsVoiced() {
+		t.Errorf("Expected foo[ to match FOO{ with the rfc1459 case mapping, got %+v (%v)", m, ok)
+	}
+
+	if !i.IsChannel("+chan") || i.IsChannel("&chan") {
+		t.Error("Channel types not applied")
+	}
+	if !i.IsAdmin(&User{Nick: "admin{1}"}) {
+		t.Error("Admin nick should match with the rfc1459 case mapping")
+	}
+
+	i.emit(EventConnected, "irc.server.tld:6667")
+	if i.IsChannel("+chan") {
+		t.Error("ISUPPORT should be reset when connecting")
+	}
+}
@@ -0,0 +1,114 @@
+package gophirc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// pingPrefix starts the tokens of our own PINGs, telling their PONGs apart.
+const pingPrefix = "gophirc-"
+
+// keepAlive keeps track of the activity on the connection & of our PING waiting for its PONG.
+type keepAlive struct {
+	sync.Mutex
+	lastRead time.Time
+	token    string // of the PING waiting for its PONG, if any
+	sent     time.Time
+}
+
+// touch records that we received something from the server.
+func (irc *IRC) touch() {
+	irc.pings.Lock()
+	irc.pings.lastRead = time.Now()
+	irc.pings.Unlock()
+}
+
+// idle returns how long since we last received something from the server.
+func (irc *IRC) idle() time.Duration {
+	irc.pings.Lock()
+	defer irc.pings.Unlock()
+	return time.Since(irc.pings.lastRead)
+}
+
+// ping sends a PING to the server, unless one is already waiting for its PONG.
+func (irc *IRC) ping() {
+	irc.pings.Lock()
+	if irc.pings.token != "" {
+		irc.pings.Unlock()
+		return
+	}
+	irc.pings.sent = time.Now()
+	irc.pings.token = pingPrefix + strconv.FormatInt(irc.pings.sent.UnixNano(), 10)
+	token := irc.pings.token
+	irc.pings.Unlock()
+
+	irc.SendRawf("PING :%s", token)
+}
+
+// pinger sends a PING every time the connection is idle for the keep-alive interval, until done.
+func (irc *IRC) pinger(done <-chan struct{}) {
+	interval := time.Duration(irc.Config().KeepAlive.Interval)
+	if irc.Config().KeepAlive.Disabled || interval <= 0 {
+		return
+	}
+
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		if idle := irc.idle(); idle < interval {
+			t.Reset(interval - idle)
+			continue
+		}
+		irc.ping()
+		t.Reset(interval)
+	}
+}
+
+// handlePong measures the lag from the PONG answering our PING.
+func (irc *IRC) handlePong(e *Event) {
+	if len(e.Arguments) == 0 {
+		return
+	}
+
+	irc.pings.Lock()
+	if irc.pings.token == "" || e.Arguments[len(e.Arguments)-1] != irc.pings.token {
+		irc.pings.Unlock()
+		return
+	}
+	lag := time.Since(irc.pings.sent)
+	irc.pings.token = ""
+	irc.pings.Unlock()
+
+	irc.mu.Lock()
+	irc.State.Lag = lag
+	irc.mu.Unlock()
+	logger.Log.WithField("lag", lag).Debugln("Received our PONG")
+}
+
+// resetKeepAlive forgets the PING waiting for its PONG & the lag, used when connecting.
+func (irc *IRC) resetKeepAlive() {
+	irc.pings.Lock()
+	irc.pings.lastRead = time.Now()
+	irc.pings.token = ""
+	irc.pings.Unlock()
+
+	irc.mu.Lock()
+	irc.State.Lag = 0
+	irc.mu.Unlock()
+}
+
+// Lag returns the round-trip time of our last PING, 0 if none was answered yet.
+func (irc *IRC) Lag() time.Duration {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+	return irc.State.Lag
+}
@@ -0,0 +1,92 @@
+package gophirc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_Lag(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	i.resetKeepAlive()
+
+	i.ping()
+	l := <-lines
+	if !strings.HasPrefix(l, "PING :"+pingPrefix) {
+		t.Fatalf("Expected a PING, got %q instead.", l)
+	}
+	token := strings.TrimPrefix(l, "PING :")
+
+	// a single PING waits for its PONG
+	i.ping()
+	expectNoLines(t, lines)
+
+	tests := []struct {
+		name string
+		raw  string
+		lag  bool
+	}{
+		{"other pong", ":irc.server.tld PONG irc.server.tld :other", false},
+		{"empty pong", ":irc.server.tld PONG", false},
+		{"our pong", ":irc.server.tld PONG irc.server.tld :" + token, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i.ReadEvent(test.raw)
+			if lag := i.Lag(); (lag > 0) != test.lag {
+				t.Errorf("Expected a lag: %v, got %v instead.", test.lag, lag)
+			}
+		})
+	}
+
+	// once answered, the next PING can be sent
+	i.ping()
+	if l := <-lines; !strings.HasPrefix(l, "PING :"+pingPrefix) {
+		t.Errorf("Expected a PING, got %q instead.", l)
+	}
+}
+
+func TestIRC_Pinger(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{
+		Nickname:  "gophirc",
+		KeepAlive: config.KeepAlive{Interval: config.Duration(20 * time.Millisecond)},
+	})
+	i.resetKeepAlive()
+
+	done := make(chan struct{})
+	defer close(done)
+	go i.pinger(done)
+
+	select {
+	case l := <-lines:
+		if !strings.HasPrefix(l, "PING :"+pingPrefix) {
+			t.Errorf("Expected a PING, got %q instead.", l)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a PING on an idle connection, got nothing.")
+	}
+}
+
+func TestIRC_ReadTimeout(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{
+		Nickname:  "gophirc",
+		KeepAlive: config.KeepAlive{Interval: config.Duration(time.Hour), Timeout: config.Duration(50 * time.Millisecond)},
+	})
+
+	d := i.startDispatcher()
+	defer d.stop()
+
+	errs := make(chan error, 1)
+	go func() { errs <- i.read(d) }()
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "No data received") {
+			t.Errorf("Expected a timeout, got %v instead.", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the read to time out on a stalled connection.")
+	}
+}
@@ -0,0 +1,321 @@
+package gophirc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// EventStopped is emitted by the Manager when a network's Run returns on its own, e.g. after
+// giving up reconnecting. Arguments: the error Run returned, or "".
+const EventStopped = "gophirc:stopped"
+
+// Manager runs a bot on several networks, one IRC per server, named after the server's key in
+// the config. The callbacks bound with On get the events of all the networks, the network's
+// name attached, including the networks added later on.
+type Manager struct {
+	mu       sync.Mutex
+	networks map[string]*network
+	handlers []managerHandler
+	setups   []func(name string, irc *IRC)
+
+	ctx context.Context // set while running
+	wg  sync.WaitGroup  // the networks running
+}
+
+// network is an IRC managed by the Manager.
+type network struct {
+	irc     *IRC
+	cancel  context.CancelFunc // nil until started
+	done    chan struct{}      // closed once Run returned
+	stopped bool               // Run returned on its own
+	err     error              // returned by Run, if stopped on its own
+}
+
+type managerHandler struct {
+	code string
+	cb   func(*NetworkEvent)
+}
+
+// NetworkEvent is an event received on one of the Manager's networks.
+type NetworkEvent struct {
+	*Event
+	Network string
+	IRC     *IRC
+}
+
+// NewManager returns a Manager with a network for each server of the config, which must have
+// been checked. The networks are connected by Run.
+func NewManager(c *config.Config) *Manager {
+	m := &Manager{networks: make(map[string]*network)}
+	for name, s := range c.Servers {
+		m.networks[name] = &network{irc: New(s)}
+	}
+	return m
+}
+
+// On binds a callback to the event code on all the networks, current & future.
+func (m *Manager) On(code string, cb func(*NetworkEvent)) {
+	h := managerHandler{code, cb}
+
+	m.mu.Lock()
+	m.handlers = append(m.handlers, h)
+	networks := m.snapshot()
+	m.mu.Unlock()
+
+	for name, n := range networks {
+		m.bind(name, n.irc, h)
+	}
+}
+
+// Each calls fn for all the networks, current & future, e.g. to set up their command routers or
+// CTCP replies. For the networks added later, it's called before they connect.
+func (m *Manager) Each(fn func(name string, irc *IRC)) {
+	m.mu.Lock()
+	m.setups = append(m.setups, fn)
+	networks := m.snapshot()
+	m.mu.Unlock()
+
+	for name, n := range networks {
+		fn(name, n.irc)
+	}
+}
+
+// Network returns the network's IRC, false if there's no network with the name.
+func (m *Manager) Network(name string) (*IRC, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.networks[name]
+	if !ok {
+		return nil, false
+	}
+	return n.irc, true
+}
+
+// Networks returns the names of the networks, sorted.
+func (m *Manager) Networks() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.networks))
+	for name := range m.networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Err returns the error the network stopped with, nil if it's still running or was never started.
+func (m *Manager) Err(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n, ok := m.networks[name]; ok {
+		return n.err
+	}
+	return nil
+}
+
+// Add adds a network for the server, which must have been checked, connecting it right away
+// if the Manager is running.
+func (m *Manager) Add(name string, s *config.Server) error {
+	n := &network{irc: New(s)}
+
+	m.mu.Lock()
+	if _, ok := m.networks[name]; ok {
+		m.mu.Unlock()
+		return errors.Errorf("Network %q already exists", name)
+	}
+	m.networks[name] = n
+	handlers, setups := m.handlers, m.setups
+	m.mu.Unlock()
+
+	for _, fn := range setups {
+		fn(name, n.irc)
+	}
+	for _, h := range handlers {
+		m.bind(name, n.irc, h)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx != nil && m.ctx.Err() == nil && m.networks[name] == n {
+		m.start(name, n)
+	}
+	return nil
+}
+
+// Remove disconnects the network, with the server's quit message, & removes it once its Run
+// returned.
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	n, ok := m.networks[name]
+	delete(m.networks, name)
+	var cancel context.CancelFunc
+	var done chan struct{}
+	if ok {
+		cancel, done = n.cancel, n.done
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return errors.Errorf("No network %q", name)
+	}
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// Reload applies a new config, which must have been checked: the networks no longer in it are
+// removed, the new ones added, and the others reloaded live, see IRC.Reload.
+func (m *Manager) Reload(c *config.Config) error {
+	for _, name := range m.Networks() {
+		if _, ok := c.Servers[name]; !ok {
+			if err := m.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(c.Servers))
+	for name := range c.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if irc, ok := m.Network(name); ok {
+			irc.Reload(c.Servers[name])
+			continue
+		}
+		if err := m.Add(name, c.Servers[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run connects all the networks & blocks until the context is cancelled, returning its error
+// once all the networks quit. A network stopping on its own emits EventStopped & isn't
+// restarted; remove it & add it back to start it again.
+func (m *Manager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	if m.ctx != nil {
+		m.mu.Unlock()
+		return errors.New("Manager already running")
+	}
+	m.ctx = ctx
+	for name, n := range m.networks {
+		m.start(name, n)
+	}
+	m.mu.Unlock()
+
+	<-ctx.Done()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	m.ctx = nil
+	for _, n := range m.networks {
+		n.cancel = nil
+	}
+	m.mu.Unlock()
+	return ctx.Err()
+}
+
+// PrivMsg sends a message to the target on the network, failing if the network isn't running.
+func (m *Manager) PrivMsg(network, target, message string) error {
+	m.mu.Lock()
+	n, ok := m.networks[network]
+	running := ok && n.cancel != nil && !n.stopped
+	m.mu.Unlock()
+
+	if !ok {
+		return errors.Errorf("No network %q", network)
+	}
+	if !running {
+		return errors.Errorf("Network %q isn't running", network)
+	}
+	n.irc.PrivMsg(target, message)
+	return nil
+}
+
+// Relay sends a message, notice or action received on a network to the target on another one,
+// prefixed with the network & the sender, e.g. "[first] <nick> hi" or "[first] * nick waves".
+func (m *Manager) Relay(e *NetworkEvent, network, target string) error {
+	var text string
+	if c, ok := AsCTCP(e.Event); ok && c.Verb == "ACTION" && !c.Reply && e.User != nil {
+		text = fmt.Sprintf("[%s] * %s %s", e.Network, e.User.Nick, c.Params)
+	} else if msg, ok := AsMessage(e.Event); ok && e.User != nil {
+		text = fmt.Sprintf("[%s] <%s> %s", e.Network, e.User.Nick, msg.Text)
+	} else {
+		return errors.Errorf("Can't relay a %s event", e.Code)
+	}
+	return m.PrivMsg(network, target, text)
+}
+
+// snapshot returns a copy of the networks, m.mu being held.
+func (m *Manager) snapshot() map[string]*network {
+	networks := make(map[string]*network, len(m.networks))
+	for name, n := range m.networks {
+		networks[name] = n
+	}
+	return networks
+}
+
+// bind binds the Manager's callback on the network's IRC.
+func (m *Manager) bind(name string, irc *IRC, h managerHandler) {
+	irc.On(h.code, func(e *Event) {
+		h.cb(&NetworkEvent{Event: e, Network: name, IRC: irc})
+	})
+}
+
+// start runs the network until the Manager's context is cancelled or the network removed,
+// m.mu being held.
+func (m *Manager) start(name string, n *network) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	n.cancel, n.done, n.stopped, n.err = cancel, make(chan struct{}), false, nil
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(n.done)
+		defer cancel()
+
+		err := m.run(ctx, n.irc)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logger.Log.WithField("network", name).Errorln("Network stopped:", err)
+		} else {
+			logger.Log.WithField("network", name).Infoln("Network disconnected")
+		}
+		m.mu.Lock()
+		n.stopped, n.err = true, err
+		m.mu.Unlock()
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		}
+		n.irc.emit(EventStopped, reason)
+	}()
+}
+
+// run runs the IRC, turning a panic into an error so the other networks keep running.
+func (m *Manager) run(ctx context.Context, irc *IRC) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("Panic: %v", r)
+		}
+	}()
+	return irc.Run(ctx)
+}
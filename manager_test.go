@@ -0,0 +1,244 @@
+package gophirc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/irctest"
+)
+
+// managerServer starts a test server, returning it along with a config for a bot joining #relay.
+func managerServer(t *testing.T) (*irctest.Server, *config.Server) {
+	s, err := irctest.NewServer()
+	if err != nil {
+		t.Fatal("Can't start the test server", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	c, err := s.Config("bot")
+	if err != nil {
+		t.Fatal("Invalid test server config", err)
+	}
+	c.Channels = []string{"#relay"}
+	c.Reconnect.Disabled = true
+	return s, c
+}
+
+// expectLine fails the test if the server doesn't receive a line starting with the prefix.
+func expectLine(t *testing.T, s *irctest.Server, prefix string) {
+	t.Helper()
+	if _, err := s.Expect(prefix, time.Second); err != nil {
+		t.Fatalf("Expected %q, got %v.", prefix, err)
+	}
+}
+
+func TestManager(t *testing.T) {
+	a, aConf := managerServer(t)
+	b, bConf := managerServer(t)
+	m := NewManager(&config.Config{Servers: map[string]*config.Server{"a": aConf, "b": bConf}})
+
+	if networks := m.Networks(); !reflect.DeepEqual(networks, []string{"a", "b"}) {
+		t.Errorf("Expected the networks %q, got %q instead.", []string{"a", "b"}, networks)
+	}
+
+	// everything said on the other networks is relayed to b
+	for _, code := range []string{"PRIVMSG", CTCPCode("ACTION")} {
+		m.On(code, func(e *NetworkEvent) {
+			if e.Network != "b" && e.IRC.IsChannel(e.ReplyTo) {
+				m.Relay(e, "b", "#relay")
+			}
+		})
+	}
+	stopped := make(chan string, 1)
+	m.On(EventStopped, func(e *NetworkEvent) {
+		stopped <- e.Network
+	})
+	var setup []string
+	m.Each(func(name string, irc *IRC) {
+		setup = append(setup, name)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := make(chan error, 1)
+	go func() {
+		run <- m.Run(ctx)
+	}()
+
+	expectLine(t, a, "JOIN #relay")
+	expectLine(t, b, "JOIN #relay")
+
+	a.Send("bot", ":alice!u@h PRIVMSG #relay :hi there")
+	expectLine(t, b, "PRIVMSG #relay :[a] <alice> hi there")
+	a.Send("bot", ":alice!u@h PRIVMSG #relay :\001ACTION waves\001")
+	expectLine(t, b, "PRIVMSG #relay :[a] * alice waves")
+
+	// added at runtime, with the callbacks & the setups
+	c, cConf := managerServer(t)
+	if err := m.Add("c", cConf); err != nil {
+		t.Fatal("Can't add the network", err)
+	}
+	if err := m.Add("c", cConf); err == nil {
+		t.Error("Expected an error adding a network twice, got nil instead.")
+	}
+	expectLine(t, c, "JOIN #relay")
+	c.Send("bot", ":carol!u@h PRIVMSG #relay :hello")
+	expectLine(t, b, "PRIVMSG #relay :[c] <carol> hello")
+
+	if err := m.Remove("c"); err != nil {
+		t.Error("Can't remove the network", err)
+	}
+	expectLine(t, c, "QUIT")
+	if err := m.PrivMsg("c", "#relay", "hi"); err == nil {
+		t.Error("Expected an error sending to a removed network, got nil instead.")
+	}
+
+	sort.Strings(setup)
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(setup, expected) {
+		t.Errorf("Expected the networks set up %q, got %q instead.", expected, setup)
+	}
+
+	// a network stopping on its own doesn't stop the others
+	a.Close()
+	select {
+	case name := <-stopped:
+		if name != "a" {
+			t.Errorf("Expected the network %q to stop, got %q instead.", "a", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the network to stop, got nothing.")
+	}
+	if err := m.Err("a"); err != ErrConnectionClosed {
+		t.Errorf("Expected %v, got %v instead.", ErrConnectionClosed, err)
+	}
+	if err := m.PrivMsg("b", "#relay", "still here"); err != nil {
+		t.Error("Can't send to the network", err)
+	}
+	expectLine(t, b, "PRIVMSG #relay :still here")
+
+	cancel()
+	if err := <-run; err != context.Canceled {
+		t.Errorf("Expected %v, got %v instead.", context.Canceled, err)
+	}
+	expectLine(t, b, "QUIT")
+}
+
+func TestManager_Relay(t *testing.T) {
+	m := NewManager(&config.Config{})
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	e, _ := i.ParseToEvent(":user!u@h JOIN #chan")
+	if err := m.Relay(&NetworkEvent{Event: e, Network: "a", IRC: i}, "b", "#chan"); err == nil {
+		t.Error("Expected an error relaying a JOIN, got nil instead.")
+	}
+
+	e, _ = i.ParseToEvent(":user!u@h PRIVMSG #chan :hi")
+	if err := m.Relay(&NetworkEvent{Event: e, Network: "a", IRC: i}, "b", "#chan"); err == nil {
+		t.Error("Expected an error relaying to an unknown network, got nil instead.")
+	}
+}
+
+func TestManager_Reload(t *testing.T) {
+	a, aConf := managerServer(t)
+	b, bConf := managerServer(t)
+	m := NewManager(&config.Config{Servers: map[string]*config.Server{"a": aConf, "b": bConf}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := make(chan error, 1)
+	go func() {
+		run <- m.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-run
+	}()
+
+	expectLine(t, a, "JOIN #relay")
+	expectLine(t, b, "JOIN #relay")
+
+	// a is reloaded, b removed & c added
+	c, cConf := managerServer(t)
+	aReloaded := *aConf
+	aReloaded.Channels = []string{"#relay", "#other"}
+	err := m.Reload(&config.Config{Servers: map[string]*config.Server{"a": &aReloaded, "c": cConf}})
+	if err != nil {
+		t.Fatal("Can't reload the config", err)
+	}
+
+	expectLine(t, a, "JOIN #other")
+	expectLine(t, b, "QUIT")
+	expectLine(t, c, "JOIN #relay")
+	if networks := m.Networks(); !reflect.DeepEqual(networks, []string{"a", "c"}) {
+		t.Errorf("Expected the networks %q, got %q instead.", []string{"a", "c"}, networks)
+	}
+}
+
+func TestManager_RelayStopped(t *testing.T) {
+	a, aConf := managerServer(t)
+	b, bConf := managerServer(t)
+	m := NewManager(&config.Config{Servers: map[string]*config.Server{"a": aConf, "b": bConf}})
+
+	relayed := make(chan error, 1)
+	m.On("PRIVMSG", func(e *NetworkEvent) {
+		if e.Network == "b" {
+			relayed <- m.Relay(e, "a", "#relay")
+		}
+	})
+	stopped := make(chan string, 1)
+	m.On(EventStopped, func(e *NetworkEvent) {
+		stopped <- e.Network
+	})
+
+	if err := m.PrivMsg("a", "#relay", "hi"); err == nil {
+		t.Error("Expected an error sending before running, got nil instead.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := make(chan error, 1)
+	go func() {
+		run <- m.Run(ctx)
+	}()
+	expectLine(t, a, "JOIN #relay")
+	expectLine(t, b, "JOIN #relay")
+
+	a.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the network to stop, got nothing.")
+	}
+
+	// relaying to the stopped network fails right away, without blocking b's callbacks
+	b.Send("bot", ":bob!u@h PRIVMSG #relay :anyone there?")
+	select {
+	case err := <-relayed:
+		if err == nil {
+			t.Error("Expected an error relaying to a stopped network, got nil instead.")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the relay to fail, got nothing.")
+	}
+
+	// nor does sending on the stopped IRC itself
+	sent := make(chan struct{})
+	go func() {
+		irc, _ := m.Network("a")
+		irc.PrivMsg("#relay", "hi")
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Expected sending on a stopped network not to block.")
+	}
+
+	cancel()
+	select {
+	case <-run:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the manager to stop, got nothing.")
+	}
+}
@@ -0,0 +1,182 @@
+package gophirc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEmptyMessage is returned when parsing a line with no command in it.
+var ErrEmptyMessage = errors.New("Empty message")
+
+// ErrInvalidCommand is returned when the command is neither a word nor a 3 digit numeric.
+var ErrInvalidCommand = errors.New("Invalid command")
+
+// Message is a raw IRC line parsed according to RFC 1459 & the IRCv3 message tags spec:
+//
+//	[@tags] [:source] <command> [params...] [:trailing]
+//
+// The trailing parameter is stored as the last element of Params, without the leading colon.
+type Message struct {
+	Tags    map[string]string // Unescaped IRCv3 tags, a tag without a value maps to ""
+	Source  string            // Source of the message, without the leading colon
+	Command string            // Command or numeric reply code
+	Params  []string          // Parameters, including the trailing one
+}
+
+// ParseMessage parses a raw line into a Message. The trailing CR LF, if any, is ignored.
+func ParseMessage(raw string) (*Message, error) {
+	raw = strings.TrimRight(raw, "\r\n")
+	m := new(Message)
+
+	if strings.HasPrefix(raw, "@") {
+		var tags string
+		tags, raw = cut(raw[1:])
+		m.Tags = parseTags(tags)
+	}
+
+	if strings.HasPrefix(raw, ":") {
+		m.Source, raw = cut(raw[1:])
+	}
+
+	m.Command, raw = cut(raw)
+	if m.Command == "" {
+		return nil, ErrEmptyMessage
+	}
+	if !isCommand(m.Command) {
+		return nil, ErrInvalidCommand
+	}
+
+	for raw != "" {
+		if raw[0] == ':' {
+			m.Params = append(m.Params, raw[1:])
+			break
+		}
+		var param string
+		param, raw = cut(raw)
+		m.Params = append(m.Params, param)
+	}
+
+	return m, nil
+}
+
+// isCommand returns whether s is made only of letters, or of exactly 3 digits.
+func isCommand(s string) bool {
+	numeric := len(s) == 3
+	letters := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		numeric = numeric && c >= '0' && c <= '9'
+		letters = letters && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+	}
+	return numeric || letters
+}
+
+// cut returns the text before the first space, and the text after it with the leading spaces stripped.
+func cut(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i+1:], " ")
+}
+
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ";") {
+		kv := strings.SplitN(tag, "=", 2)
+		if kv[0] == "" {
+			continue
+		}
+		if len(kv) == 1 {
+			tags[kv[0]] = ""
+			continue
+		}
+		tags[kv[0]] = unescapeTag(kv[1])
+	}
+	return tags
+}
+
+var tagEscapes = map[byte]byte{':': ';', 's': ' ', '\\': '\\', 'r': '\r', 'n': '\n'}
+
+func unescapeTag(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			break // a lone trailing backslash is dropped
+		}
+		if c, ok := tagEscapes[s[i]]; ok {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func escapeTag(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
+
+// Trailing returns the last parameter of the message, or an empty string if there are none.
+func (m *Message) Trailing() string {
+	if len(m.Params) == 0 {
+		return ""
+	}
+	return m.Params[len(m.Params)-1]
+}
+
+// String serializes the message back into a raw line, without the CR LF.
+// Tags are sorted by key, and the last parameter is prefixed with a colon when needed.
+func (m *Message) String() string {
+	var b strings.Builder
+
+	if len(m.Tags) > 0 {
+		keys := make([]string, 0, len(m.Tags))
+		for k := range m.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteByte('@')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteString(k)
+			if v := m.Tags[k]; v != "" {
+				b.WriteByte('=')
+				b.WriteString(escapeTag(v))
+			}
+		}
+		b.WriteByte(' ')
+	}
+
+	if m.Source != "" {
+		b.WriteByte(':')
+		b.WriteString(m.Source)
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(m.Command)
+
+	for i, p := range m.Params {
+		b.WriteByte(' ')
+		if i == len(m.Params)-1 && (p == "" || p[0] == ':' || strings.Contains(p, " ")) {
+			b.WriteByte(':')
+		}
+		b.WriteString(p)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package gophirc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected *Message
+	}{
+		{"PING :irc.server.tld", &Message{Command: "PING", Params: []string{"irc.server.tld"}}},
+		{"PING irc.server.tld\r\n", &Message{Command: "PING", Params: []string{"irc.server.tld"}}},
+		{"ERROR :Closing Link: 127.0.0.1 (Quit: bye)", &Message{
+			Command: "ERROR", Params: []string{"Closing Link: 127.0.0.1 (Quit: bye)"},
+		}},
+		{"AUTHENTICATE +", &Message{Command: "AUTHENTICATE", Params: []string{"+"}}},
+		{":nick!user@host PRIVMSG #chan :hello there", &Message{
+			Source: "nick!user@host", Command: "PRIVMSG", Params: []string{"#chan", "hello there"},
+		}},
+		{":nick!user@host PRIVMSG #chan ::)", &Message{
+			Source: "nick!user@host", Command: "PRIVMSG", Params: []string{"#chan", ":)"},
+		}},
+		{":irc.server.tld 001 gophirc :Welcome", &Message{
+			Source: "irc.server.tld", Command: "001", Params: []string{"gophirc", "Welcome"},
+		}},
+		{":irc.server.tld   MODE  #chan  +o   gophirc", &Message{
+			Source: "irc.server.tld", Command: "MODE", Params: []string{"#chan", "+o", "gophirc"},
+		}},
+		{":nick!user@host JOIN #chan :", &Message{
+			Source: "nick!user@host", Command: "JOIN", Params: []string{"#chan", ""},
+		}},
+		{"@account=nick;time=2017-08-01T10:00:00.000Z :nick!user@host PRIVMSG #chan :hi", &Message{
+			Tags:    map[string]string{"account": "nick", "time": "2017-08-01T10:00:00.000Z"},
+			Source:  "nick!user@host",
+			Command: "PRIVMSG",
+			Params:  []string{"#chan", "hi"},
+		}},
+		{`@a=b\:c\sd\\e\rf\ng;h;+vendor/x=\y\ CAP * LS`, &Message{
+			Tags:    map[string]string{"a": "b;c d\\e\rf\ng", "h": "", "+vendor/x": "y"},
+			Command: "CAP",
+			Params:  []string{"*", "LS"},
+		}},
+		{"@=x;a PING x", &Message{Tags: map[string]string{"a": ""}, Command: "PING", Params: []string{"x"}}},
+		{"@a=1;a=2 PING x", &Message{Tags: map[string]string{"a": "2"}, Command: "PING", Params: []string{"x"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			actual, err := ParseMessage(test.raw)
+			if err != nil {
+				t.Fatalf("%q: unexpected error %q", test.raw, err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("%q: expected %#v, got %#v instead.", test.raw, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	tests := []string{"", "\r\n", " ", ":source", ":source ", "@a=b", "@a=b :source", "PRIV-MSG x", "01 x", "4O4 x"}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			if _, err := ParseMessage(test); err == nil {
+				t.Errorf("%q: expected an error, got nil instead.", test)
+			}
+		})
+	}
+}
+
+func TestMessage_String(t *testing.T) {
+	tests := []struct {
+		message  *Message
+		expected string
+	}{
+		{&Message{Command: "PING", Params: []string{"x"}}, "PING x"},
+		{&Message{Command: "PRIVMSG", Params: []string{"#chan", "hello there"}}, "PRIVMSG #chan :hello there"},
+		{&Message{Command: "PRIVMSG", Params: []string{"#chan", ":)"}}, "PRIVMSG #chan ::)"},
+		{&Message{Command: "PRIVMSG", Params: []string{"#chan", ""}}, "PRIVMSG #chan :"},
+		{&Message{Source: "nick!user@host", Command: "QUIT"}, ":nick!user@host QUIT"},
+		{&Message{
+			Tags:    map[string]string{"b": "x y;z", "a": ""},
+			Command: "TAGMSG",
+			Params:  []string{"#chan"},
+		}, `@a;b=x\sy\:z TAGMSG #chan`},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if actual := test.message.String(); actual != test.expected {
+				t.Errorf("%#v: expected %q, got %q instead.", test.message, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMessage_Trailing(t *testing.T) {
+	m, _ := ParseMessage(":nick!user@host PRIVMSG #chan :hello there")
+	if m.Trailing() != "hello there" {
+		t.Errorf("Expected %q, got %q instead.", "hello there", m.Trailing())
+	}
+
+	m, _ = ParseMessage("QUIT")
+	if m.Trailing() != "" {
+		t.Errorf("Expected no trailing parameter, got %q instead.", m.Trailing())
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	seeds := []string{
+		"PING :irc.server.tld",
+		":nick!user@host PRIVMSG #chan :hello there",
+		`@a=b\:c\sd;e :src CMD p1 p2 :trailing param`,
+		":irc.server.tld 353 gophirc = #chan :@op +voice user",
+		"@",
+		":",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		if strings.ContainsAny(raw, "\r\n\x00") {
+			return // can't appear inside a single line
+		}
+
+		m, err := ParseMessage(raw)
+		if err != nil {
+			return
+		}
+		if m.Command == "" {
+			t.Fatalf("%q: parsed a message without a command", raw)
+		}
+
+		// serializing & parsing again must be stable
+		again, err := ParseMessage(m.String())
+		if err != nil {
+			t.Fatalf("%q: can't parse serialized %q: %v", raw, m.String(), err)
+		}
+		if again.String() != m.String() {
+			t.Fatalf("%q: serialized %q, then %q", raw, m.String(), again.String())
+		}
+	})
+}
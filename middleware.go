@@ -0,0 +1,130 @@
+package gophirc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+// Middleware wraps the handling of an event, before its callbacks run. It can inspect or
+// mutate the event, then call next to continue the chain, or not call it to swallow the event.
+type Middleware func(e *Event, next func(*Event))
+
+// Use adds a middleware run for every event received from the server, after the ones added
+// before it. The ignored users are dropped by the first middleware, added by New.
+func (irc *IRC) Use(m Middleware) *IRC {
+	irc.eventsMu.Lock()
+	defer irc.eventsMu.Unlock()
+
+	irc.middleware = append(irc.middleware[:len(irc.middleware):len(irc.middleware)], m)
+	return irc
+}
+
+// UseFor adds a middleware run only for the events with the code, after the global ones.
+func (irc *IRC) UseFor(code string, m Middleware) *IRC {
+	irc.eventsMu.Lock()
+	defer irc.eventsMu.Unlock()
+
+	irc.codeMiddleware[code] = append(irc.codeMiddleware[code], m)
+	return irc
+}
+
+// StopPropagation stops the event from reaching the callbacks after the current one.
+func (e *Event) StopPropagation() {
+	e.stopped = true
+}
+
+// Stopped returns whether a callback stopped the event's propagation.
+func (e *Event) Stopped() bool {
+	return e.stopped
+}
+
+// dispatch runs the event through the middleware chain, then its callbacks.
+func (irc *IRC) dispatch(e *Event) {
+	irc.eventsMu.RLock()
+	chain := append(append([]Middleware{}, irc.middleware...), irc.codeMiddleware[e.Code]...)
+	irc.eventsMu.RUnlock()
+
+	handler := irc.callbacks
+	for i := len(chain) - 1; i >= 0; i-- {
+		m, next := chain[i], handler
+		handler = func(e *Event) { m(e, next) }
+	}
+	handler(e)
+}
+
+// callbacks calls the callbacks bound to the event's code, until one stops the propagation.
+func (irc *IRC) callbacks(e *Event) {
+	for _, callback := range irc.callbacksFor(e.Code) {
+		if e.stopped {
+			break
+		}
+		callback.call(e)
+	}
+	irc.logEvent(e)
+}
+
+// ignoreMiddleware swallows the events from the ignored users.
+func (irc *IRC) ignoreMiddleware(e *Event, next func(*Event)) {
+	if irc.HasRole(e, RoleIgnored) {
+		return
+	}
+	next(e)
+}
+
+// RateLimitUsers returns a middleware allowing each user a burst of events, then one event
+// every interval, swallowing the rest. The events from the server & the admins aren't limited.
+func (irc *IRC) RateLimitUsers(burst int, interval time.Duration) Middleware {
+	l := newUserLimiter(burst, interval)
+
+	return func(e *Event, next func(*Event)) {
+		if e.User == nil || irc.HasRole(e, RoleAdmin) {
+			next(e)
+			return
+		}
+		if !l.limited(irc.isupport.Fold(e.User.Nick)) {
+			next(e)
+		}
+	}
+}
+
+// userLimiter keeps a bucket for each user seen lately, the ones refilled being dropped as
+// they're the same as new ones.
+type userLimiter struct {
+	sync.Mutex
+	flood   config.Flood
+	buckets map[string]*bucket
+	swept   time.Time // when the refilled buckets were last dropped
+}
+
+func newUserLimiter(burst int, interval time.Duration) *userLimiter {
+	return &userLimiter{
+		flood:   config.Flood{Burst: burst, Interval: config.Duration(interval)},
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// limited returns whether the user's event goes over the limit, taking a token otherwise.
+func (l *userLimiter) limited(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	// a bucket takes burst intervals to refill
+	if time.Since(l.swept) >= time.Duration(l.flood.Burst)*time.Duration(l.flood.Interval) {
+		for k, b := range l.buckets {
+			if b.full() {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = time.Now()
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(l.flood)
+		l.buckets[key] = b
+	}
+	return b.wait() > 0
+}
@@ -0,0 +1,96 @@
+package gophirc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_Middleware(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Ignore: []string{"ignored"}})
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(e *Event, next func(*Event)) {
+			calls = append(calls, name+":"+e.Code)
+			next(e)
+		}
+	}
+
+	i.Use(record("global")).UseFor("PRIVMSG", record("privmsg")).UseFor("PRIVMSG", func(e *Event, next func(*Event)) {
+		if strings.Contains(e.Message, "spam") {
+			return
+		}
+		e.Message = strings.ToUpper(e.Message)
+		next(e)
+	})
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		calls = append(calls, "callback:"+e.Message)
+		if e.Message == "STOP" {
+			e.StopPropagation()
+		}
+	}).AddEventCallback("PRIVMSG", func(e *Event) {
+		calls = append(calls, "second:"+e.Message)
+	})
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+	}{
+		{"chain", ":user!u@h PRIVMSG #chan :hi", []string{"global:PRIVMSG", "privmsg:PRIVMSG", "callback:HI", "second:HI"}},
+		{"other code", ":user!u@h JOIN #chan", []string{"global:JOIN"}},
+		{"swallowed", ":user!u@h PRIVMSG #chan :spam", []string{"global:PRIVMSG", "privmsg:PRIVMSG"}},
+		{"stopped", ":user!u@h PRIVMSG #chan :stop", []string{"global:PRIVMSG", "privmsg:PRIVMSG", "callback:STOP"}},
+		{"ignored", ":ignored!u@h PRIVMSG #chan :hi", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls = nil
+			i.ReadEvent(test.raw)
+			if !reflect.DeepEqual(calls, test.expected) {
+				t.Errorf("Expected calls %q, got %q instead.", test.expected, calls)
+			}
+		})
+	}
+}
+
+func TestIRC_RateLimitUsers(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"admin"}})
+	i.Use(i.RateLimitUsers(2, time.Hour))
+
+	counts := make(map[string]int)
+	i.AddEventCallback("PRIVMSG", func(e *Event) {
+		counts[e.User.Nick]++
+	})
+
+	for n := 0; n < 5; n++ {
+		i.ReadEvent(":flooder!u@h PRIVMSG #chan :flood")
+		i.ReadEvent(":FLOODER!u@h PRIVMSG #chan :flood")
+		i.ReadEvent(":admin!u@h PRIVMSG #chan :flood")
+		i.ReadEvent(":user!u@h PRIVMSG #chan :hi")
+	}
+
+	expected := map[string]int{"flooder": 1, "FLOODER": 1, "admin": 5, "user": 2}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected counts %v, got %v instead.", expected, counts)
+	}
+}
+
+func TestUserLimiter_Sweep(t *testing.T) {
+	l := newUserLimiter(2, 10*time.Millisecond)
+	l.limited("first")
+	l.limited("second")
+	l.limited("second")
+
+	time.Sleep(30 * time.Millisecond)
+	if l.limited("third") {
+		t.Error("Expected a new user not to be limited.")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected only the third user's bucket, got %d buckets instead.", len(l.buckets))
+	}
+}
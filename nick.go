@@ -0,0 +1,241 @@
+package gophirc
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// nickErrors are the numerics rejecting a nickname: ERR_ERRONEUSNICKNAME, ERR_NICKNAMEINUSE,
+// ERR_NICKCOLLISION & ERR_UNAVAILRESOURCE.
+var nickErrors = map[string]bool{"432": true, "433": true, "436": true, "437": true}
+
+// maxFallbackNicks is how many generated nicknames we try after the alternates, before giving up.
+const maxFallbackNicks = 10
+
+// nickState keeps track of our current nickname & of getting the configured one back.
+type nickState struct {
+	sync.Mutex
+	current   string // our nickname, as the server knows it
+	attempts  int    // nicknames rejected during the registration
+	welcomed  bool   // the server accepted our nickname with RPL_WELCOME
+	monitored bool   // we're watching the configured nickname with MONITOR
+	regain    *time.Timer
+}
+
+// CurrentNick returns our current nickname, which differs from the configured one if it was
+// taken when connecting or if we changed it.
+func (irc *IRC) CurrentNick() string {
+	irc.nicks.Lock()
+	defer irc.nicks.Unlock()
+
+	if irc.nicks.current == "" {
+		return irc.Config().Nickname
+	}
+	return irc.nicks.current
+}
+
+// resetNick goes back to the configured nickname, used when registering.
+func (irc *IRC) resetNick() {
+	irc.nicks.Lock()
+	defer irc.nicks.Unlock()
+
+	irc.nicks.current = irc.Config().Nickname
+	irc.nicks.attempts = 0
+	irc.nicks.welcomed = false
+	irc.nicks.monitored = false
+	irc.stopRegain()
+}
+
+// trackNick updates our nickname from RPL_WELCOME & our NICK changes. It runs as the events are
+// read, before the callbacks, so isMe is always up to date.
+func (irc *IRC) trackNick(e *Event) {
+	irc.nicks.Lock()
+	defer irc.nicks.Unlock()
+
+	switch e.Code {
+	case "001":
+		if len(e.Arguments) > 0 {
+			irc.nicks.current = e.Arguments[0]
+		}
+		irc.nicks.welcomed = true
+	case "NICK":
+		if e.User != nil && len(e.Arguments) > 0 && irc.EqualFold(e.User.Nick, irc.nicks.current) {
+			irc.nicks.current = e.Arguments[0]
+		}
+	}
+}
+
+// nextNick returns the nickname to try after the previous one was rejected: the alternates from
+// the config, then the configured nickname followed by "_", then followed by random digits.
+// It returns false once we tried too many.
+func (irc *IRC) nextNick() (string, bool) {
+	irc.nicks.Lock()
+	defer irc.nicks.Unlock()
+
+	n := irc.nicks.attempts
+	irc.nicks.attempts++
+
+	alternates := irc.Config().AltNicknames
+	switch {
+	case n < len(alternates):
+		irc.nicks.current = alternates[n]
+	case n == len(alternates):
+		irc.nicks.current = irc.Config().Nickname + "_"
+	case n < len(alternates)+maxFallbackNicks:
+		irc.nicks.current = fallbackNick(irc.Config().Nickname, irc.isupport.NickLen())
+	default:
+		return "", false
+	}
+	return irc.nicks.current, true
+}
+
+// fallbackNick returns the nickname with 4 random digits, truncated to fit the maximum length.
+func fallbackNick(nick string, max int) string {
+	suffix := fmt.Sprintf("%04d", rand.Intn(10000))
+	if max > len(suffix) && len(nick)+len(suffix) > max {
+		nick = nick[:max-len(suffix)]
+	}
+	return nick + suffix
+}
+
+// handleNickError tries another nickname if ours was rejected during the registration.
+// Afterwards, the rejection comes from a NICK we sent, and we keep the nickname we have.
+func (irc *IRC) handleNickError(e *Event) {
+	reason := ""
+	if len(e.Arguments) > 0 {
+		reason = e.Arguments[len(e.Arguments)-1]
+	}
+
+	irc.nicks.Lock()
+	welcomed := irc.nicks.welcomed
+	irc.nicks.Unlock()
+
+	if welcomed {
+		logger.Log.WithField("code", e.Code).Debugf("Nickname change rejected: %s", reason)
+		return
+	}
+
+	nick, ok := irc.nextNick()
+	if !ok {
+		logger.Log.WithField("code", e.Code).Errorln("Couldn't find an available nickname")
+		return
+	}
+	logger.Log.WithFields(logger.Fields(map[string]interface{}{
+		"code": e.Code, "nick": nick,
+	})).Warnf("Nickname rejected: %s", reason)
+	irc.Nick(nick)
+}
+
+// hasPrimaryNick returns whether we're using the configured nickname.
+func (irc *IRC) hasPrimaryNick() bool {
+	return irc.EqualFold(irc.CurrentNick(), irc.Config().Nickname)
+}
+
+// startRegain starts trying to get the configured nickname back, if enabled & not using it:
+// every Regain.Interval, and with MONITOR if the server supports it.
+func (irc *IRC) startRegain() {
+	if !irc.Config().Regain.Enabled || irc.hasPrimaryNick() {
+		return
+	}
+
+	if _, ok := irc.isupport.Value("MONITOR"); ok {
+		irc.nicks.Lock()
+		monitored := irc.nicks.monitored
+		irc.nicks.monitored = true
+		irc.nicks.Unlock()
+
+		if !monitored {
+			irc.SendRawf("MONITOR + %s", irc.Config().Nickname)
+		}
+	}
+
+	irc.nicks.Lock()
+	defer irc.nicks.Unlock()
+
+	irc.stopRegain()
+	if interval := time.Duration(irc.Config().Regain.Interval); interval > 0 {
+		irc.nicks.regain = time.AfterFunc(interval, func() {
+			irc.regain()
+			irc.startRegain()
+		})
+	}
+}
+
+// stopRegain stops the periodic attempts. The nickState must be locked.
+func (irc *IRC) stopRegain() {
+	if irc.nicks.regain != nil {
+		irc.nicks.regain.Stop()
+		irc.nicks.regain = nil
+	}
+}
+
+// regain asks for the configured nickname, if we're registered & not using it.
+func (irc *IRC) regain() {
+	irc.nicks.Lock()
+	welcomed := irc.nicks.welcomed
+	irc.nicks.Unlock()
+
+	if !welcomed || irc.hasPrimaryNick() {
+		return
+	}
+	logger.Log.WithField("nick", irc.Config().Nickname).Infoln("Trying to regain our nickname")
+	irc.Nick(irc.Config().Nickname)
+}
+
+// handleRegain tries to regain the configured nickname as soon as its holder leaves it, with a
+// QUIT or NICK seen in our channels, or RPL_MONOFFLINE (731) from MONITOR. Once regained, the
+// attempts stop & we identify to NickServ again.
+func (irc *IRC) handleRegain(e *Event) {
+	if !irc.Config().Regain.Enabled {
+		return
+	}
+
+	switch e.Code {
+	case "QUIT", "NICK":
+		if e.User == nil {
+			return
+		}
+		if e.Code == "NICK" && len(e.Arguments) > 0 && irc.isMe(e.Arguments[0]) {
+			irc.regained()
+			return
+		}
+		if irc.EqualFold(e.User.Nick, irc.Config().Nickname) {
+			irc.regain()
+		}
+	case "731": // <me> :target[!user@host][,target[!user@host]]*
+		if len(e.Arguments) < 2 {
+			return
+		}
+		for _, target := range strings.Split(e.Arguments[1], ",") {
+			if nick := strings.SplitN(target, "!", 2)[0]; irc.EqualFold(nick, irc.Config().Nickname) {
+				irc.regain()
+			}
+		}
+	}
+}
+
+// regained stops the attempts after we changed to the configured nickname, & identifies.
+func (irc *IRC) regained() {
+	if !irc.hasPrimaryNick() {
+		return
+	}
+
+	irc.nicks.Lock()
+	monitored := irc.nicks.monitored
+	irc.nicks.monitored = false
+	irc.stopRegain()
+	irc.nicks.Unlock()
+
+	logger.Log.WithField("nick", irc.Config().Nickname).Infoln("Regained our nickname")
+	if monitored {
+		irc.SendRawf("MONITOR - %s", irc.Config().Nickname)
+	}
+	if !irc.SASLAuthenticated() {
+		irc.Identify()
+	}
+}
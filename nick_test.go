@@ -0,0 +1,130 @@
+package gophirc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestIRC_NickCollision(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc", AltNicknames: []string{"gophbot", "gophalt"}})
+	i.resetNick()
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{"in use", ":irc.server.tld 433 * gophirc :Nickname is already in use", "NICK gophbot"},
+		{"unavailable", ":irc.server.tld 437 * gophbot :Nick/channel is temporarily unavailable", "NICK gophalt"},
+		{"erroneous", ":irc.server.tld 432 * gophalt :Erroneous Nickname", "NICK gophirc_"},
+		{"collision", ":irc.server.tld 436 * gophirc_ :Nickname collision KILL", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i.ReadEvent(test.raw)
+			if test.expected != "" {
+				expectLines(t, lines, test.expected)
+				return
+			}
+
+			l := <-lines
+			if !strings.HasPrefix(l, "NICK gophi") || len(l) != len("NICK ")+9 {
+				t.Errorf("Expected a generated nickname, got %q instead.", l)
+			}
+		})
+	}
+
+	nick := i.CurrentNick()
+	i.ReadEvent(":irc.server.tld 001 " + nick + " :Welcome")
+	if i.CurrentNick() != nick || !i.isMe(nick) {
+		t.Errorf("Expected the current nick %q, got %q instead.", nick, i.CurrentNick())
+	}
+
+	// after the registration, a rejected NICK keeps our nickname
+	i.ReadEvent(":irc.server.tld 433 " + nick + " gophirc :Nickname is already in use")
+	expectNoLines(t, lines)
+	if i.CurrentNick() != nick {
+		t.Errorf("Expected the current nick %q, got %q instead.", nick, i.CurrentNick())
+	}
+}
+
+func TestIRC_NickFallbackLimit(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	i.resetNick()
+
+	for n := 0; n < maxFallbackNicks; n++ {
+		i.ReadEvent(":irc.server.tld 433 * nick :Nickname is already in use")
+		<-lines
+	}
+	i.ReadEvent(":irc.server.tld 433 * nick :Nickname is already in use")
+	expectNoLines(t, lines)
+}
+
+func TestFallbackNick(t *testing.T) {
+	tests := []struct {
+		name   string
+		nick   string
+		max    int
+		prefix string
+	}{
+		{"short", "bot", 9, "bot"},
+		{"truncated", "gophirc", 9, "gophi"},
+		{"long nicklen", "gophirc", 30, "gophirc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nick := fallbackNick(test.nick, test.max)
+			if !strings.HasPrefix(nick, test.prefix) || len(nick) != len(test.prefix)+4 {
+				t.Errorf("Expected %q followed by 4 digits, got %q instead.", test.prefix, nick)
+			}
+		})
+	}
+}
+
+func TestIRC_Regain(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{
+		Nickname:         "gophirc",
+		AltNicknames:     []string{"gophbot"},
+		NickservPassword: "pass",
+		Regain:           config.Regain{Enabled: true, Interval: config.Duration(time.Hour)},
+	})
+	i.resetNick()
+
+	i.ReadEvent(":irc.server.tld 433 * gophirc :Nickname is already in use")
+	expectLines(t, lines, "NICK gophbot")
+	i.ReadEvent(":irc.server.tld 001 gophbot :Welcome")
+	expectLines(t, lines, "NS IDENTIFY pass")
+	i.ReadEvent(":irc.server.tld 005 gophbot MONITOR=100 :are supported by this server")
+	i.ReadEvent(":irc.server.tld 376 gophbot :End of /MOTD command.")
+	expectLines(t, lines, "MONITOR + gophirc")
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+	}{
+		{"other user quits", ":other!u@h QUIT :bye", nil},
+		{"holder quits", ":gophirc!u@h QUIT :bye", []string{"NICK gophirc"}},
+		{"holder changes nick", ":GOPHIRC!u@h NICK other", []string{"NICK gophirc"}},
+		{"monitor offline", ":irc.server.tld 731 gophbot :gophirc", []string{"NICK gophirc"}},
+		{"regained", ":gophbot!u@h NICK gophirc", []string{"MONITOR - gophirc", "NS IDENTIFY pass"}},
+		{"no more attempts", ":gophirc!u@h QUIT :bye", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i.ReadEvent(test.raw)
+			if len(test.expected) == 0 {
+				expectNoLines(t, lines)
+				return
+			}
+			expectLines(t, lines, test.expected...)
+		})
+	}
+
+	if !i.hasPrimaryNick() {
+		t.Errorf("Expected the nick %q, got %q instead.", "gophirc", i.CurrentNick())
+	}
+}
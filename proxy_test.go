@@ -0,0 +1,180 @@
+package gophirc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// socks5Server answers a SOCKS5 client on c, requiring the username & password if set, and
+// sends the address requested.
+func socks5Server(c net.Conn, username, password string, requested chan<- string) {
+	head := make([]byte, 2)
+	io.ReadFull(c, head)
+	methods := make([]byte, head[1])
+	io.ReadFull(c, methods)
+
+	if username == "" {
+		c.Write([]byte{5, 0})
+	} else {
+		if !bytes.Contains(methods, []byte{2}) {
+			c.Write([]byte{5, 0xff})
+			return
+		}
+		c.Write([]byte{5, 2})
+
+		io.ReadFull(c, head)
+		u := make([]byte, head[1])
+		io.ReadFull(c, u)
+		io.ReadFull(c, head[:1])
+		p := make([]byte, head[0])
+		io.ReadFull(c, p)
+		if string(u) != username || string(p) != password {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+
+	req := make([]byte, 4)
+	io.ReadFull(c, req)
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(c, ip)
+		host = net.IP(ip).String()
+	case 3:
+		io.ReadFull(c, head[:1])
+		name := make([]byte, head[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(c, port)
+	requested <- net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+
+	c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0x1a, 0x0b})
+	c.Write([]byte(":irc.test NOTICE * :*** Looking up your hostname...\r\n"))
+}
+
+func TestProxyDialer_SOCKS5(t *testing.T) {
+	tests := []struct {
+		name       string
+		credential string // in the proxy URL
+		address    string
+		shouldFail bool
+	}{
+		{"no auth", "", "irc.server.tld:6667", false},
+		{"ip", "", "10.0.0.1:6697", false},
+		{"auth", "user:secret@", "irc.server.tld:6667", false},
+		{"wrong password", "user:wrong@", "irc.server.tld:6667", true},
+		{"missing auth", "", "irc.server.tld:6667", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			username, password := "", ""
+			if test.credential != "" || test.name == "missing auth" {
+				username, password = "user", "secret"
+			}
+
+			requested := make(chan string, 1)
+			proxy := listen(t, func(c net.Conn) {
+				socks5Server(c, username, password, requested)
+			})
+
+			d, err := ProxyDialer("socks5://"+test.credential+proxy, &net.Dialer{})
+			if err != nil {
+				t.Fatal("Can't create the dialer", err)
+			}
+			c, err := d.Dial("tcp", test.address)
+			if test.shouldFail {
+				if err == nil {
+					t.Error("Expected an error, got nil instead.")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Can't dial through the proxy", err)
+			}
+			defer c.Close()
+
+			if address := <-requested; address != test.address {
+				t.Errorf("Expected %q, got %q instead.", test.address, address)
+			}
+			if l, _ := bufio.NewReader(c).ReadString('\n'); !strings.HasPrefix(l, ":irc.test NOTICE") {
+				t.Errorf("Expected the server's notice, got %q instead.", l)
+			}
+		})
+	}
+}
+
+func TestProxyDialer_HTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		credential string
+		status     string
+		shouldFail bool
+	}{
+		{"connect", "", "200 Connection established", false},
+		{"auth", "user:secret@", "200 Connection established", false},
+		{"refused", "", "407 Proxy Authentication Required", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := make(chan *http.Request, 1)
+			proxy := listen(t, func(c net.Conn) {
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					return
+				}
+				requests <- req
+				// the server's first line comes along with the reply
+				c.Write([]byte("HTTP/1.1 " + test.status + "\r\n\r\n:irc.test NOTICE * :*** Looking up your hostname...\r\n"))
+			})
+
+			d, _ := ProxyDialer("http://"+test.credential+proxy, &net.Dialer{})
+			c, err := d.Dial("tcp", "irc.server.tld:6697")
+			if test.shouldFail {
+				if err == nil {
+					t.Error("Expected an error, got nil instead.")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Can't dial through the proxy", err)
+			}
+			defer c.Close()
+
+			req := <-requests
+			if req.Method != http.MethodConnect || req.Host != "irc.server.tld:6697" {
+				t.Errorf("Expected CONNECT irc.server.tld:6697, got %s %s instead.", req.Method, req.Host)
+			}
+			auth := ""
+			if test.credential != "" {
+				auth = "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+			}
+			if a := req.Header.Get("Proxy-Authorization"); a != auth {
+				t.Errorf("Expected the authorization %q, got %q instead.", auth, a)
+			}
+
+			if l, _ := bufio.NewReader(c).ReadString('\n'); !strings.HasPrefix(l, ":irc.test NOTICE") {
+				t.Errorf("Expected the server's notice, got %q instead.", l)
+			}
+		})
+	}
+}
+
+func TestProxyDialer_Invalid(t *testing.T) {
+	for _, proxy := range []string{"ftp://127.0.0.1:21", "socks5://", "://"} {
+		if _, err := ProxyDialer(proxy, &net.Dialer{}); err == nil {
+			t.Errorf("Expected an error for %q, got nil instead.", proxy)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package gophirc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query is a command sent to the server, waiting for its numeric replies. The replies are
+// collected until one of the end numerics, or one of the error numerics, is received.
+type Query struct {
+	Command string   // raw line sent, e.g. "WHOIS nick"
+	Replies []string // numerics collected, e.g. "311"
+	End     []string // numerics ending the replies, collected too, e.g. "318"
+	Errors  []string // error numerics ending the query with a ReplyError, e.g. "401"
+
+	// Match returns whether an event is a reply to this query, e.g. is about the same nick.
+	// Without it, the replies go to the oldest query waiting for them.
+	Match func(*Event) bool
+}
+
+// ReplyError is an error numeric received as a reply to a query.
+type ReplyError struct {
+	Code    string
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// pendingQuery is a query waiting for its replies.
+type pendingQuery struct {
+	Query
+	events []*Event
+	err    error
+	done   chan struct{}
+}
+
+// queries keeps the queries waiting for replies, in the order they were sent.
+type queries struct {
+	sync.Mutex
+	pending []*pendingQuery
+}
+
+func has(codes []string, code string) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// offer gives the event to the oldest query waiting for it, if any.
+func (q *queries) offer(e *Event) {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, p := range q.pending {
+		isEnd, isErr := has(p.End, e.Code), has(p.Errors, e.Code)
+		if !isEnd && !isErr && !has(p.Replies, e.Code) {
+			continue
+		}
+		if p.Match != nil && !p.Match(e) {
+			continue
+		}
+
+		if isErr {
+			r := &ReplyError{Code: e.Code}
+			if len(e.Arguments) > 0 {
+				r.Message = e.Arguments[len(e.Arguments)-1]
+			}
+			p.err = r
+		} else {
+			p.events = append(p.events, e)
+		}
+		if isEnd || isErr {
+			q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+			close(p.done)
+		}
+		return
+	}
+}
+
+// remove drops the query, returning false if it already ended.
+func (q *queries) remove(p *pendingQuery) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, pending := range q.pending {
+		if pending == p {
+			q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fail ends all the queries with the error, used when the connection ends.
+func (q *queries) fail(err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, p := range q.pending {
+		p.err = err
+		close(p.done)
+	}
+	q.pending = nil
+}
+
+// Query sends the query's command & waits for its replies, until the end numeric, an error
+// numeric, the end of the connection, or the context is done. It returns the replies received,
+// including the end numeric.
+func (irc *IRC) Query(ctx context.Context, q Query) ([]*Event, error) {
+	p := &pendingQuery{Query: q, done: make(chan struct{})}
+
+	irc.queries.Lock()
+	irc.queries.pending = append(irc.queries.pending, p)
+	irc.queries.Unlock()
+
+	irc.SendRaw(q.Command)
+
+	select {
+	case <-p.done:
+		return p.events, p.err
+	case <-ctx.Done():
+		if !irc.queries.remove(p) {
+			<-p.done
+			return p.events, p.err
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// argument returns whether the event's argument at the index is the name, with the server's
+// case mapping.
+func (irc *IRC) argument(e *Event, index int, name string) bool {
+	return len(e.Arguments) > index && irc.EqualFold(e.Arguments[index], name)
+}
+
+// WhoisReply is the information about a user, from WHOIS.
+type WhoisReply struct {
+	Nick     string
+	User     string
+	Host     string
+	Realname string
+
+	Server     string
+	ServerInfo string
+	Channels   []string // with their prefixes, e.g. "@#chan"
+	Account    string
+	Away       string
+
+	Idle   time.Duration
+	SignOn time.Time
+
+	Operator bool
+	Secure   bool
+}
+
+// Whois sends a WHOIS for the nick & returns the reply. If the nick isn't online, it returns
+// a ReplyError with the code 401.
+func (irc *IRC) Whois(ctx context.Context, nick string) (*WhoisReply, error) {
+	events, err := irc.Query(ctx, Query{
+		Command: "WHOIS " + nick,
+		Replies: []string{"301", "311", "312", "313", "317", "319", "330", "671"},
+		End:     []string{"318"},
+		Errors:  []string{"401", "402"},
+		Match: func(e *Event) bool {
+			return irc.argument(e, 1, nick)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WhoisReply{Nick: nick}
+	for _, e := range events {
+		args := e.Arguments
+		switch {
+		case e.Code == "311" && len(args) > 5: // <me> <nick> <user> <host> * :<realname>
+			w.Nick, w.User, w.Host, w.Realname = args[1], args[2], args[3], args[5]
+		case e.Code == "312" && len(args) > 3: // <me> <nick> <server> :<info>
+			w.Server, w.ServerInfo = args[2], args[3]
+		case e.Code == "313":
+			w.Operator = true
+		case e.Code == "317" && len(args) > 3: // <me> <nick> <idle> <signon> :seconds idle, signon time
+			idle, _ := strconv.Atoi(args[2])
+			w.Idle = time.Duration(idle) * time.Second
+			if signon, err := strconv.ParseInt(args[3], 10, 64); err == nil {
+				w.SignOn = time.Unix(signon, 0)
+			}
+		case e.Code == "319" && len(args) > 2: // <me> <nick> :<channels>
+			w.Channels = append(w.Channels, strings.Fields(args[2])...)
+		case e.Code == "330" && len(args) > 2: // <me> <nick> <account> :is logged in as
+			w.Account = args[2]
+		case e.Code == "301" && len(args) > 2: // <me> <nick> :<away message>
+			w.Away = args[2]
+		case e.Code == "671":
+			w.Secure = true
+		}
+	}
+	return w, nil
+}
+
+// WhoReply is a user matching a WHO query.
+type WhoReply struct {
+	Channel  string
+	User     string
+	Host     string
+	Server   string
+	Nick     string
+	Flags    string // e.g. "H@" for here & op, "G" for gone
+	Hops     int
+	Realname string
+}
+
+// Who sends a WHO for the mask (a channel or a nick mask) & returns the users matching it.
+func (irc *IRC) Who(ctx context.Context, mask string) ([]WhoReply, error) {
+	events, err := irc.Query(ctx, Query{
+		Command: "WHO " + mask,
+		Replies: []string{"352"},
+		End:     []string{"315"},
+		Errors:  []string{"403"},
+		Match: func(e *Event) bool {
+			return e.Code == "352" || irc.argument(e, 1, mask)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var users []WhoReply
+	for _, e := range events {
+		// <me> <channel> <user> <host> <server> <nick> <flags> :<hops> <realname>
+		if e.Code != "352" || len(e.Arguments) < 8 {
+			continue
+		}
+		args := e.Arguments
+		u := WhoReply{Channel: args[1], User: args[2], Host: args[3], Server: args[4], Nick: args[5], Flags: args[6]}
+		hops := strings.SplitN(args[7], " ", 2)
+		u.Hops, _ = strconv.Atoi(hops[0])
+		if len(hops) > 1 {
+			u.Realname = hops[1]
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// ListEntry is a channel from LIST.
+type ListEntry struct {
+	Channel string
+	Users   int
+	Topic   string
+}
+
+// List sends a LIST, optionally for some channels only, & returns the channels.
+func (irc *IRC) List(ctx context.Context, channels ...string) ([]ListEntry, error) {
+	command := "LIST"
+	if len(channels) > 0 {
+		command += " " + strings.Join(channels, ",")
+	}
+
+	events, err := irc.Query(ctx, Query{
+		Command: command,
+		Replies: []string{"321", "322"},
+		End:     []string{"323"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []ListEntry
+	for _, e := range events {
+		// <me> <channel> <users> :<topic>
+		if e.Code != "322" || len(e.Arguments) < 3 {
+			continue
+		}
+		entry := ListEntry{Channel: e.Arguments[1]}
+		entry.Users, _ = strconv.Atoi(e.Arguments[2])
+		if len(e.Arguments) > 3 {
+			entry.Topic = e.Arguments[3]
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// Names sends a NAMES for the channel & returns its members, with their prefixes.
+func (irc *IRC) Names(ctx context.Context, channel string) ([]Member, error) {
+	events, err := irc.Query(ctx, Query{
+		Command: "NAMES " + channel,
+		Replies: []string{"353"},
+		End:     []string{"366"},
+		Errors:  []string{"403"},
+		Match: func(e *Event) bool {
+			if e.Code == "353" {
+				return irc.argument(e, 2, channel)
+			}
+			return irc.argument(e, 1, channel)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Member
+	ops := irc.isupport.OpPrefixes()
+	for _, e := range events {
+		// <me> <symbol> <channel> :<names>
+		if e.Code != "353" || len(e.Arguments) < 4 {
+			continue
+		}
+		for _, name := range strings.Fields(e.Arguments[3]) {
+			prefixes, nick := irc.tracker.splitPrefixes(name)
+			members = append(members, Member{Nick: nick, Prefixes: prefixes, ops: ops})
+		}
+	}
+	return members, nil
+}
+
+// BanEntry is a ban from a channel's ban list.
+type BanEntry struct {
+	Mask  string
+	SetBy string
+	SetAt time.Time
+}
+
+// Bans sends a "MODE #chan b" & returns the channel's ban list.
+func (irc *IRC) Bans(ctx context.Context, channel string) ([]BanEntry, error) {
+	events, err := irc.Query(ctx, Query{
+		Command: "MODE " + channel + " b",
+		Replies: []string{"367"},
+		End:     []string{"368"},
+		Errors:  []string{"403", "442", "482"},
+		Match: func(e *Event) bool {
+			return irc.argument(e, 1, channel)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var bans []BanEntry
+	for _, e := range events {
+		// <me> <channel> <mask> [<setter> <timestamp>]
+		if e.Code != "367" || len(e.Arguments) < 3 {
+			continue
+		}
+		ban := BanEntry{Mask: e.Arguments[2]}
+		if len(e.Arguments) > 4 {
+			ban.SetBy = e.Arguments[3]
+			if ts, err := strconv.ParseInt(e.Arguments[4], 10, 64); err == nil {
+				ban.SetAt = time.Unix(ts, 0)
+			}
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
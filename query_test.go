@@ -0,0 +1,186 @@
+package gophirc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+// answer waits for the query line, then feeds the replies to the client.
+func answer(t *testing.T, i *IRC, lines <-chan string, query string, replies ...string) {
+	t.Helper()
+	expectLines(t, lines, query)
+	for _, r := range replies {
+		i.ReadEvent(r)
+	}
+}
+
+func TestIRC_Whois(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	type result struct {
+		w   *WhoisReply
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		w, err := i.Whois(context.Background(), "someone")
+		done <- result{w, err}
+	}()
+
+	answer(t, i, lines, "WHOIS someone",
+		":irc.server.tld 311 gophirc other u h * :Not this one",
+		":irc.server.tld 311 gophirc Someone user some.host * :Some One",
+		":irc.server.tld 319 gophirc Someone :@#chan +#other",
+		":irc.server.tld 312 gophirc Someone irc.server.tld :The server",
+		":irc.server.tld 313 gophirc Someone :is an IRC operator",
+		":irc.server.tld 671 gophirc Someone :is using a secure connection",
+		":irc.server.tld 330 gophirc Someone someaccount :is logged in as",
+		":irc.server.tld 317 gophirc Someone 60 1500000000 :seconds idle, signon time",
+		":irc.server.tld 318 gophirc Someone :End of /WHOIS list.",
+	)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal("Whois failed", r.err)
+	}
+	expected := &WhoisReply{
+		Nick: "Someone", User: "user", Host: "some.host", Realname: "Some One",
+		Server: "irc.server.tld", ServerInfo: "The server", Channels: []string{"@#chan", "+#other"},
+		Account: "someaccount", Idle: time.Minute, SignOn: time.Unix(1500000000, 0),
+		Operator: true, Secure: true,
+	}
+	if !reflect.DeepEqual(r.w, expected) {
+		t.Errorf("Expected %+v, got %+v instead.", expected, r.w)
+	}
+
+	go func() {
+		w, err := i.Whois(context.Background(), "nobody")
+		done <- result{w, err}
+	}()
+	answer(t, i, lines, "WHOIS nobody", ":irc.server.tld 401 gophirc nobody :No such nick/channel")
+
+	r = <-done
+	if err, ok := r.err.(*ReplyError); !ok || err.Code != "401" || err.Message != "No such nick/channel" {
+		t.Errorf("Expected a 401 error, got %v", r.err)
+	}
+}
+
+func TestIRC_QueryLists(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		query    func() (interface{}, error)
+		line     string
+		replies  []string
+		expected interface{}
+	}{
+		{
+			"who",
+			func() (interface{}, error) { return i.Who(ctx, "#chan") },
+			"WHO #chan",
+			[]string{
+				":irc.server.tld 352 gophirc #chan u1 h1 irc.server.tld one H@ :0 User One",
+				":irc.server.tld 352 gophirc #chan u2 h2 irc.server.tld two G :3 User Two",
+				":irc.server.tld 315 gophirc #chan :End of /WHO list.",
+			},
+			[]WhoReply{
+				{"#chan", "u1", "h1", "irc.server.tld", "one", "H@", 0, "User One"},
+				{"#chan", "u2", "h2", "irc.server.tld", "two", "G", 3, "User Two"},
+			},
+		},
+		{
+			"list",
+			func() (interface{}, error) { return i.List(ctx) },
+			"LIST",
+			[]string{
+				":irc.server.tld 321 gophirc Channel :Users  Name",
+				":irc.server.tld 322 gophirc #chan 12 :The topic",
+				":irc.server.tld 322 gophirc #other 3 :",
+				":irc.server.tld 323 gophirc :End of /LIST",
+			},
+			[]ListEntry{{"#chan", 12, "The topic"}, {"#other", 3, ""}},
+		},
+		{
+			"names",
+			func() (interface{}, error) { return i.Names(ctx, "#chan") },
+			"NAMES #chan",
+			[]string{
+				":irc.server.tld 353 gophirc = #other :not me",
+				":irc.server.tld 353 gophirc = #chan :@op +voiced user",
+				":irc.server.tld 366 gophirc #chan :End of /NAMES list.",
+			},
+			[]Member{{"op", "@", "@"}, {"voiced", "+", "@"}, {"user", "", "@"}},
+		},
+		{
+			"bans",
+			func() (interface{}, error) { return i.Bans(ctx, "#chan") },
+			"MODE #chan b",
+			[]string{
+				":irc.server.tld 367 gophirc #chan *!*@bad.host op 1500000000",
+				":irc.server.tld 367 gophirc #chan *!*@other.host",
+				":irc.server.tld 368 gophirc #chan :End of channel ban list",
+			},
+			[]BanEntry{{"*!*@bad.host", "op", time.Unix(1500000000, 0)}, {"*!*@other.host", "", time.Time{}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			type result struct {
+				v   interface{}
+				err error
+			}
+			done := make(chan result, 1)
+			go func() {
+				v, err := test.query()
+				done <- result{v, err}
+			}()
+
+			answer(t, i, lines, test.line, test.replies...)
+			r := <-done
+			if r.err != nil {
+				t.Fatal("Query failed", r.err)
+			}
+			if !reflect.DeepEqual(r.v, test.expected) {
+				t.Errorf("Expected %+v, got %+v instead.", test.expected, r.v)
+			}
+		})
+	}
+}
+
+func TestIRC_QueryEnds(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := i.Whois(ctx, "someone")
+		done <- err
+	}()
+	expectLines(t, lines, "WHOIS someone")
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected %v, got %v instead.", context.Canceled, err)
+	}
+
+	go func() {
+		_, err := i.Bans(context.Background(), "#chan")
+		done <- err
+	}()
+	expectLines(t, lines, "MODE #chan b")
+	i.emit(EventDisconnected, "")
+	if err := <-done; err != ErrConnectionClosed {
+		t.Errorf("Expected %v, got %v instead.", ErrConnectionClosed, err)
+	}
+
+	i.queries.Lock()
+	defer i.queries.Unlock()
+	if len(i.queries.pending) != 0 {
+		t.Errorf("Expected no pending queries, got %d", len(i.queries.pending))
+	}
+}
@@ -0,0 +1,249 @@
+package gophirc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// priorityCommands skip the queue, being sent before any other pending line.
+// Our own PINGs skip it too, not to count the queue in the lag.
+var priorityCommands = map[string]bool{"PING": true, "PONG": true, "QUIT": true}
+
+// sendQueue holds the lines waiting to be written to a connection by its writer goroutine.
+type sendQueue struct {
+	sync.Mutex
+	priority []string
+	lines    []string
+	closed   bool
+
+	wake chan struct{} // signals new lines, or closing
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{wake: make(chan struct{}, 1)}
+}
+
+// push adds a line to the queue, in the priority lane if its command has priority.
+// The line is discarded if the queue is closed.
+func (q *sendQueue) push(line string) {
+	cmd := line
+	if i := strings.IndexByte(line, ' '); i != -1 {
+		cmd = line[:i]
+	}
+
+	q.Lock()
+	if q.closed {
+		q.Unlock()
+		return
+	}
+	if priorityCommands[strings.ToUpper(cmd)] {
+		q.priority = append(q.priority, line)
+	} else {
+		q.lines = append(q.lines, line)
+	}
+	q.Unlock()
+	q.signal()
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the writer, dropping the pending lines.
+func (q *sendQueue) close() {
+	q.Lock()
+	q.closed = true
+	q.priority, q.lines = nil, nil
+	q.Unlock()
+	q.signal()
+}
+
+// next returns the next line to write & whether it has priority, waiting for one if there's none.
+// It returns false once the queue is closed.
+func (q *sendQueue) next() (line string, priority bool, ok bool) {
+	for {
+		q.Lock()
+		switch {
+		case q.closed:
+			q.Unlock()
+			return "", false, false
+		case len(q.priority) > 0:
+			line, q.priority = q.priority[0], q.priority[1:]
+			q.Unlock()
+			return line, true, true
+		case len(q.lines) > 0:
+			line, q.lines = q.lines[0], q.lines[1:]
+			q.Unlock()
+			return line, false, true
+		}
+		q.Unlock()
+		<-q.wake
+	}
+}
+
+// unshift puts a line back at the front of the normal lane.
+func (q *sendQueue) unshift(line string) {
+	q.Lock()
+	q.lines = append([]string{line}, q.lines...)
+	q.Unlock()
+}
+
+// drop removes the pending lines sent to the target, compared with equal, returning how many
+// lines won't be sent to it anymore. A line sent to other targets too is kept for them.
+func (q *sendQueue) drop(target string, equal func(a, b string) bool) int {
+	q.Lock()
+	defer q.Unlock()
+
+	kept := q.lines[:0]
+	dropped := 0
+	for _, line := range q.lines {
+		m, err := ParseMessage(line)
+		if err != nil || len(m.Params) == 0 {
+			kept = append(kept, line)
+			continue
+		}
+
+		targets := strings.Split(m.Params[0], ",")
+		var others []string
+		for _, t := range targets {
+			if !equal(t, target) {
+				others = append(others, t)
+			}
+		}
+		switch {
+		case len(others) == 0:
+			dropped++
+		case len(others) < len(targets):
+			m.Params[0] = strings.Join(others, ",")
+			kept = append(kept, m.String())
+			dropped++
+		default:
+			kept = append(kept, line)
+		}
+	}
+	q.lines = kept
+	return dropped
+}
+
+// bucket is a token bucket, allowing a burst of lines & then one line every interval.
+type bucket struct {
+	tokens   float64
+	burst    float64
+	interval time.Duration
+	last     time.Time
+}
+
+func newBucket(f config.Flood) *bucket {
+	return &bucket{
+		tokens:   float64(f.Burst),
+		burst:    float64(f.Burst),
+		interval: time.Duration(f.Interval),
+		last:     time.Now(),
+	}
+}
+
+func (b *bucket) refill() {
+	now := time.Now()
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// wait returns how long to wait for a token to be available, taking it if there's one.
+// Without an interval there's no limit.
+func (b *bucket) wait() time.Duration {
+	if b.interval <= 0 {
+		return 0
+	}
+
+	b.refill()
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// full returns whether the bucket refilled to its burst, being the same as a new one.
+func (b *bucket) full() bool {
+	if b.interval <= 0 {
+		return true
+	}
+
+	b.refill()
+	return b.tokens >= b.burst
+}
+
+// take consumes a token, if there's one, without waiting. Used by the priority lines.
+func (b *bucket) take() {
+	if b.interval <= 0 {
+		return
+	}
+
+	b.refill()
+	if b.tokens >= 1 {
+		b.tokens--
+	}
+}
+
+// writer writes the queued lines to the connection until the queue is closed or a write fails,
+// rate limited according to the server's flood settings.
+func (irc *IRC) writer(c net.Conn, q *sendQueue) {
+	flood := irc.Config().Flood
+	if flood.Disabled {
+		flood.Interval = 0
+	}
+	b := newBucket(flood)
+
+	for {
+		line, priority, ok := q.next()
+		if !ok {
+			return
+		}
+
+		if priority {
+			b.take()
+		} else if d := b.wait(); d > 0 {
+			// put it back & wait, unless something with priority comes in
+			q.unshift(line)
+			select {
+			case <-time.After(d):
+			case <-q.wake:
+			}
+			continue
+		}
+
+		// recorded as it's written, past the reordering & dropping, but before the reply is read
+		irc.hook(rawLine{line: line, sent: true})
+		if _, err := fmt.Fprint(c, line+"\r\n"); err != nil {
+			logger.Log.WithField("line", line).Warnln("Error writing to the connection:", err)
+			q.close()
+			return
+		}
+	}
+}
+
+// DropPending drops the lines waiting in the send queue for the target (a channel or a nick),
+// as per the server's case mapping, returning how many were dropped. The lines sent to other
+// targets too are still sent to them. Part calls it before leaving a channel.
+func (irc *IRC) DropPending(target string) int {
+	irc.mu.Lock()
+	q := irc.queue
+	irc.mu.Unlock()
+
+	if q == nil {
+		return 0
+	}
+	return q.drop(target, irc.EqualFold)
+}
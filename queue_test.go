@@ -0,0 +1,109 @@
+package gophirc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestBucket(t *testing.T) {
+	b := newBucket(config.Flood{Burst: 3, Interval: config.Duration(time.Hour)})
+	for i := 0; i < 3; i++ {
+		if d := b.wait(); d != 0 {
+			t.Fatalf("Line %d should be in the burst, got a %v wait", i, d)
+		}
+	}
+	if d := b.wait(); d <= 0 || d > time.Hour {
+		t.Errorf("Expected a wait of up to an hour, got %v", d)
+	}
+
+	b = newBucket(config.Flood{Burst: 1})
+	for i := 0; i < 100; i++ {
+		if d := b.wait(); d != 0 {
+			t.Fatalf("Expected no limit without an interval, got a %v wait", d)
+		}
+	}
+}
+
+func TestSendQueue(t *testing.T) {
+	q := newSendQueue()
+	q.push("PRIVMSG #a :1")
+	q.push("PRIVMSG #b :2")
+	q.push("pong :x")
+	q.push("NOTICE #A :3")
+	q.push("PRIVMSG #b,#a :4 5")
+	q.push("QUIT :bye")
+
+	if n := q.drop("#a", strings.EqualFold); n != 3 {
+		t.Errorf("Expected 3 dropped lines, got %d", n)
+	}
+
+	expected := []struct {
+		line     string
+		priority bool
+	}{
+		{"pong :x", true},
+		{"QUIT :bye", true},
+		{"PRIVMSG #b :2", false},
+		{"PRIVMSG #b :4 5", false},
+	}
+	for _, e := range expected {
+		line, priority, ok := q.next()
+		if !ok || line != e.line || priority != e.priority {
+			t.Errorf("Expected %q (priority %v), got %q (priority %v, ok %v)", e.line, e.priority, line, priority, ok)
+		}
+	}
+
+	q.close()
+	if _, _, ok := q.next(); ok {
+		t.Error("Expected the queue to be closed")
+	}
+	q.push("PRIVMSG #b :after")
+	if len(q.lines) != 0 {
+		t.Errorf("Expected the lines pushed after closing to be discarded, got %q", q.lines)
+	}
+}
+
+func TestIRC_SendRawFlood(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{
+		Flood: config.Flood{Burst: 2, Interval: config.Duration(200 * time.Millisecond)},
+	})
+
+	start := time.Now()
+	i.PrivMsg("#chan", "1")
+	i.PrivMsg("#chan", "2")
+	i.PrivMsg("#chan", "3")
+	i.PrivMsg("#chan", "4")
+	expectLines(t, lines, "PRIVMSG #chan :1", "PRIVMSG #chan :2")
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("The burst took %v", time.Since(start))
+	}
+
+	// PONG skips the lines waiting for a token
+	i.pong("irc.server.tld")
+	expectLines(t, lines, "PONG :irc.server.tld")
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("PONG should skip the queue, took %v", time.Since(start))
+	}
+
+	expectLines(t, lines, "PRIVMSG #chan :3", "PRIVMSG #chan :4")
+	if time.Since(start) < 400*time.Millisecond {
+		t.Errorf("Lines sent too fast, in %v", time.Since(start))
+	}
+}
+
+func TestIRC_PartDropsPending(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{
+		Flood: config.Flood{Burst: 1, Interval: config.Duration(100 * time.Millisecond)},
+	})
+
+	i.PrivMsg("#other", "1")
+	i.PrivMsg("#chan", "2")
+	i.PrivMsg("#chan", "3")
+	i.PrivMsg("#other", "4")
+	i.Part("#chan")
+
+	expectLines(t, lines, "PRIVMSG #other :1", "PRIVMSG #other :4", "PART #chan")
+}
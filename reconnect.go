@@ -0,0 +1,216 @@
+package gophirc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// Lifecycle event codes, emitted to the callbacks in the Events map.
+// They contain a colon, so they can't clash with the commands received from the server.
+const (
+	EventConnected    = "gophirc:connected"    // Arguments: the "host:port" connected to
+	EventDisconnected = "gophirc:disconnected" // Arguments: the error ending the connection, or ""
+	EventReconnecting = "gophirc:reconnecting" // Arguments: the attempt number, the delay before it
+)
+
+// session keeps track of what has to be restored after reconnecting.
+type session struct {
+	sync.Mutex
+	channels map[string]string // channels we're in, by their folded names
+	self     *User             // our nick!user@host, as seen by the others
+}
+
+// emit calls the callbacks bound to a lifecycle event.
+func (irc *IRC) emit(code string, args ...string) {
+	irc.callbacks(&Event{Code: code, Arguments: args})
+}
+
+// trackChannels keeps track of the channels we join, part, or get kicked from.
+func (irc *IRC) trackChannels(e *Event) {
+	if len(e.Arguments) == 0 {
+		return
+	}
+
+	irc.session.Lock()
+	defer irc.session.Unlock()
+
+	switch e.Code {
+	case "JOIN":
+		if e.User != nil && irc.isMe(e.User.Nick) {
+			irc.session.channels[irc.isupport.Fold(e.Arguments[0])] = e.Arguments[0]
+		}
+	case "PART":
+		if e.User != nil && irc.isMe(e.User.Nick) {
+			delete(irc.session.channels, irc.isupport.Fold(e.Arguments[0]))
+		}
+	case "KICK":
+		if len(e.Arguments) > 1 && irc.isMe(e.Arguments[1]) {
+			delete(irc.session.channels, irc.isupport.Fold(e.Arguments[0]))
+		}
+	}
+}
+
+// rejoinChannels returns the channels from the config, followed by the other channels we were in,
+// compared as per the server's case mapping.
+func (irc *IRC) rejoinChannels() []string {
+	irc.session.Lock()
+	defer irc.session.Unlock()
+
+	configured := irc.Config().Channels
+	channels := append([]string{}, configured...)
+	var others []string
+	for _, c := range irc.session.channels {
+		if !irc.hasChannel(configured, c) {
+			others = append(others, c)
+		}
+	}
+	sort.Strings(others)
+	return append(channels, others...)
+}
+
+// minBackoff is the shortest delay before a reconnect attempt, so we never retry in a busy loop.
+const minBackoff = 100 * time.Millisecond
+
+// backoff returns the delay before the reconnect attempt, starting at 1.
+func backoff(r config.Reconnect, attempt int) time.Duration {
+	d, max := time.Duration(r.Delay), time.Duration(r.MaxDelay)
+	if d < minBackoff {
+		d = minBackoff
+	}
+	if max < d {
+		max = d
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	d -= time.Duration(rand.Float64() * r.Jitter * float64(d))
+	if d < minBackoff {
+		d = minBackoff
+	}
+	return d
+}
+
+// addresses returns the server's address followed by the alternate ones, as "host:port".
+// Over WebSocket, it's the URL's host only, the alternates being ignored.
+func (irc *IRC) addresses() []string {
+	s := irc.Config()
+	if u, err := url.Parse(s.WebSocket.URL); err == nil && s.WebSocket.URL != "" {
+		return []string{webSocketAddress(u)}
+	}
+
+	port := strconv.Itoa(int(s.Port))
+	addrs := []string{net.JoinHostPort(s.Address, port)}
+	for _, a := range s.Reconnect.Alternates {
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			a = net.JoinHostPort(a, port)
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+// reconnect tries to re-establish the connection, then registers again. The rest of the session
+// (authentication, channels) is restored once we get 001.
+func (irc *IRC) reconnect(ctx context.Context) error {
+	if err := irc.retryConnect(ctx, false); err != nil {
+		return err
+	}
+	irc.Register()
+	return nil
+}
+
+// retryConnect connects to the server, cycling through its addresses with the backoff before each
+// attempt. The first connection tries the server's address right away, then goes on as a
+// reconnect, unless reconnecting is disabled. It stops waiting & dialing once the context is done.
+func (irc *IRC) retryConnect(ctx context.Context, first bool) error {
+	r := irc.Config().Reconnect
+	addrs := irc.addresses()
+
+	irc.mu.Lock()
+	stop := irc.stop
+	irc.mu.Unlock()
+
+	for attempt := 1; r.MaxAttempts == 0 || attempt <= r.MaxAttempts; attempt++ {
+		retry := attempt
+		if first {
+			retry--
+		}
+		if retry > 0 {
+			d := backoff(r, retry)
+			irc.emit(EventReconnecting, strconv.Itoa(retry), d.String())
+			logger.Log.WithField("attempt", retry).Infof("Reconnecting in %s", d)
+
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-stop:
+				return errors.New("Disconnect requested")
+			}
+		}
+
+		dest := addrs[(attempt-1)%len(addrs)]
+		c, err := irc.dialContext(ctx, dest)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if first && r.Disabled {
+				return err
+			}
+			logger.Log.WithField("address", dest).Warnln(err)
+			continue
+		}
+
+		select {
+		case <-stop:
+			c.Close()
+			return errors.New("Disconnect requested")
+		default:
+		}
+		irc.connected(c, dest)
+		return nil
+	}
+
+	return fmt.Errorf("Giving up after %d attempts", r.MaxAttempts)
+}
+
+// dialContext dials the destination like dial, giving up once the context is done. The connection
+// established after that, if any, is closed.
+func (irc *IRC) dialContext(ctx context.Context, dest string) (net.Conn, error) {
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := irc.dial(dest)
+		done <- result{c, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.c, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.c != nil {
+				r.c.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
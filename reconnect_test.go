@@ -0,0 +1,289 @@
+package gophirc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestBackoff(t *testing.T) {
+	r := config.Reconnect{Delay: config.Duration(time.Second), MaxDelay: config.Duration(10 * time.Second)}
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.attempt), func(t *testing.T) {
+			if actual := backoff(r, test.attempt); actual != test.expected {
+				t.Errorf("Attempt %d: expected %v, got %v instead.", test.attempt, test.expected, actual)
+			}
+
+			jittered := r
+			jittered.Jitter = 0.5
+			actual := backoff(jittered, test.attempt)
+			if actual > test.expected || actual < test.expected/2 {
+				t.Errorf("Attempt %d: expected between %v and %v, got %v instead.",
+					test.attempt, test.expected/2, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBackoff_Floor(t *testing.T) {
+	for attempt := 1; attempt < 5; attempt++ {
+		if actual := backoff(config.Reconnect{}, attempt); actual != minBackoff {
+			t.Errorf("Attempt %d: expected %v, got %v instead.", attempt, minBackoff, actual)
+		}
+	}
+}
+
+func TestIRC_Addresses(t *testing.T) {
+	i := New(&config.Server{
+		Address: "irc.server.tld", Port: 6697,
+		Reconnect: config.Reconnect{Alternates: []string{"irc2.server.tld", "10.0.0.1:7000", "::1"}},
+	})
+
+	expected := []string{"irc.server.tld:6697", "irc2.server.tld:6697", "10.0.0.1:7000", "[::1]:6697"}
+	if actual := i.addresses(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, actual)
+	}
+}
+
+func TestIRC_TrackChannels(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Channels: []string{"#config"}})
+
+	i.ReadEvent(":gophirc!u@h JOIN #config")
+	i.ReadEvent(":gophirc!u@h JOIN #b")
+	i.ReadEvent(":gophirc!u@h JOIN #a")
+	i.ReadEvent(":gophirc!u@h JOIN #parted")
+	i.ReadEvent(":gophirc!u@h JOIN #kicked")
+	i.ReadEvent(":someone!u@h JOIN #other")
+	i.ReadEvent(":gophirc!u@h PART #parted :bye")
+	i.ReadEvent(":op!u@h KICK #kicked gophirc :out")
+	i.ReadEvent(":op!u@h KICK #a someone :out")
+
+	expected := []string{"#config", "#a", "#b"}
+	if actual := i.rejoinChannels(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, actual)
+	}
+}
+
+func TestIRC_TrackChannelsCaseMapping(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc", Channels: []string{"#Chan"}})
+
+	i.ReadEvent(":gophirc!u@h JOIN #chan")
+	i.ReadEvent(":gophirc!u@h JOIN #Other[1]")
+	i.ReadEvent(":gophirc!u@h JOIN #parted")
+	i.ReadEvent(":gophirc!u@h PART #PARTED :bye")
+
+	expected := []string{"#Chan", "#Other[1]"}
+	if actual := i.rejoinChannels(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, actual)
+	}
+
+	i.ReadEvent(":op!u@h KICK #other{1} gophirc :out")
+	expected = []string{"#Chan"}
+	if actual := i.rejoinChannels(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, got %q instead.", expected, actual)
+	}
+}
+
+func TestIRC_Reconnect(t *testing.T) {
+	primary, _ := net.Listen("tcp", "127.0.0.1:0")
+	alternate, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer alternate.Close()
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(primary.Addr().(*net.TCPAddr).Port),
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		Channels: []string{"#config"},
+		Reconnect: config.Reconnect{
+			Delay: config.Duration(10 * time.Millisecond), MaxDelay: config.Duration(time.Second),
+			MaxAttempts: 3, Alternates: []string{alternate.Addr().String()},
+		},
+	})
+
+	var events []string
+	var mu sync.Mutex
+	for _, code := range []string{EventConnected, EventDisconnected, EventReconnecting} {
+		i.AddEventCallback(code, func(e *Event) {
+			mu.Lock()
+			events = append(events, e.Code)
+			mu.Unlock()
+		})
+	}
+	joined := make(chan struct{})
+	i.AddEventCallback("JOIN", func(e *Event) {
+		if e.Arguments[0] == "#other" {
+			close(joined)
+		}
+	})
+
+	if err := i.Connect(); err != nil {
+		t.Fatal("Couldn't connect", err)
+	}
+	c, _ := primary.Accept()
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(context.Background())
+	}()
+
+	c.Write([]byte(":gophirc!u@h JOIN #other\r\n"))
+	<-joined
+
+	// drop the connection & the primary address, forcing the alternate one
+	c.Close()
+	primary.Close()
+
+	alternate.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := alternate.Accept()
+	if err != nil {
+		t.Fatal("Client didn't reconnect", err)
+	}
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	expect := func(expected ...string) {
+		t.Helper()
+		for _, e := range expected {
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			l, err := r.ReadString('\n')
+			if err != nil || l != e+"\r\n" {
+				t.Fatalf("Expected line %q, got %q (%v)", e, l, err)
+			}
+		}
+	}
+
+	expect("CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+	c.Write([]byte(":irc.server.tld CAP * LS :multi-prefix\r\n"))
+	expect("CAP END")
+	c.Write([]byte(":irc.server.tld 001 gophirc :Welcome\r\n"))
+	expect("JOIN #config", "JOIN #other")
+
+	i.Disconnect("bye")
+	expect("QUIT :bye")
+	c.Close()
+	if err := <-run; err != nil {
+		t.Error("Run should return nil after Disconnect", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		EventConnected, EventDisconnected,
+		EventReconnecting, EventReconnecting, EventConnected, EventDisconnected,
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %q, got %q instead.", expected, events)
+	}
+}
+
+func TestIRC_ReconnectGivesUp(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(l.Addr().(*net.TCPAddr).Port),
+		Reconnect: config.Reconnect{
+			Delay: config.Duration(time.Millisecond), MaxDelay: config.Duration(time.Millisecond), MaxAttempts: 2,
+		},
+	})
+
+	if err := i.Connect(); err != nil {
+		t.Fatal("Couldn't connect", err)
+	}
+	c, _ := l.Accept()
+	l.Close()
+	c.Close()
+
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(context.Background())
+	}()
+
+	select {
+	case err := <-run:
+		if err == nil || !strings.Contains(err.Error(), "Giving up after 2 attempts") {
+			t.Errorf("Expected Run to give up, got %q", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should stop after the max attempts")
+	}
+
+	if !i.State.Disconnected.Value || i.State.Disconnected.Requested {
+		t.Errorf("Expected an unrequested disconnect, got %+v", i.State.Disconnected)
+	}
+}
+
+func TestIRC_RunFirstConnect(t *testing.T) {
+	primary, _ := net.Listen("tcp", "127.0.0.1:0")
+	primary.Close()
+	alternate, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer alternate.Close()
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(primary.Addr().(*net.TCPAddr).Port),
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		Reconnect: config.Reconnect{MaxAttempts: 2, Alternates: []string{alternate.Addr().String()}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(ctx)
+	}()
+
+	alternate.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := alternate.Accept()
+	if err != nil {
+		t.Fatal("Client didn't connect to the alternate address", err)
+	}
+	defer c.Close()
+
+	cancel()
+	c.Close()
+	if err := <-run; err != context.Canceled {
+		t.Errorf("Expected %q, got %q", context.Canceled, err)
+	}
+}
+
+func TestIRC_RunCancelConnecting(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	l.Close()
+
+	i := New(&config.Server{
+		Address: "127.0.0.1", Port: uint16(l.Addr().(*net.TCPAddr).Port),
+		Reconnect: config.Reconnect{Delay: config.Duration(time.Second), MaxDelay: config.Duration(time.Minute)},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(ctx)
+	}()
+
+	select {
+	case err := <-run:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected %q, got %q", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should stop connecting once the context is done")
+	}
+}
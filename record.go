@@ -0,0 +1,118 @@
+package gophirc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// rawLine is a line received from the server, or sent to it, passed to the debug hook.
+type rawLine struct {
+	line string
+	sent bool
+}
+
+// hook passes the line to Run, to be logged in debug mode & recorded. The line is dropped if
+// Run isn't running, so sending never blocks on a stopped IRC.
+func (irc *IRC) hook(l rawLine) {
+	irc.mu.Lock()
+	hooked := irc.hooked
+	irc.mu.Unlock()
+	if hooked == nil {
+		return
+	}
+
+	select {
+	case irc.raw <- l:
+	case <-hooked:
+	}
+}
+
+// RecordedLine is a line of a recorded session, written as its time, "<" for the lines
+// received or ">" for the lines sent, and the raw line, e.g.
+//
+//	2006-01-02T15:04:05.999999999Z07:00 < :irc.server.tld 001 gophirc :Welcome
+type RecordedLine struct {
+	Time time.Time
+	Sent bool
+	Raw  string
+}
+
+func (l RecordedLine) String() string {
+	direction := "<"
+	if l.Sent {
+		direction = ">"
+	}
+	return fmt.Sprintf("%s %s %s", l.Time.Format(time.RFC3339Nano), direction, l.Raw)
+}
+
+// ParseRecordedLine parses a line written by the recorder back into a RecordedLine.
+func ParseRecordedLine(s string) (RecordedLine, error) {
+	var l RecordedLine
+
+	fields := strings.SplitN(strings.TrimRight(s, "\r\n"), " ", 3)
+	if len(fields) < 3 {
+		return l, errors.Errorf("Invalid recorded line %q", s)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return l, errors.Wrapf(err, "Invalid time in recorded line %q", s)
+	}
+
+	switch fields[1] {
+	case "<":
+	case ">":
+		l.Sent = true
+	default:
+		return l, errors.Errorf("Invalid direction in recorded line %q", s)
+	}
+
+	l.Time, l.Raw = t, fields[2]
+	return l, nil
+}
+
+// ReadRecording reads the lines of a recorded session, skipping the empty ones.
+func ReadRecording(r io.Reader) ([]RecordedLine, error) {
+	var lines []RecordedLine
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		l, err := ParseRecordedLine(s.Text())
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, s.Err()
+}
+
+// openRecord opens the file the session is appended to, nil if recording is disabled.
+func openRecord(path string) (*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't open the record file")
+	}
+	return f, nil
+}
+
+// writeRecord appends the line to the recorded session.
+func writeRecord(w io.Writer, l rawLine) {
+	r := RecordedLine{Time: time.Now(), Sent: l.sent, Raw: l.line}
+	if _, err := fmt.Fprintln(w, r); err != nil {
+		logger.Log.WithField("line", l.line).Warnln("Can't record the line:", err)
+	}
+}
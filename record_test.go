@@ -0,0 +1,93 @@
+package gophirc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestParseRecordedLine(t *testing.T) {
+	at := time.Date(2020, 1, 2, 15, 4, 5, 123, time.UTC)
+
+	tests := []struct {
+		line     string
+		expected RecordedLine
+		ok       bool
+	}{
+		{"2020-01-02T15:04:05.000000123Z < :irc.test 001 gophirc :Welcome", RecordedLine{at, false, ":irc.test 001 gophirc :Welcome"}, true},
+		{"2020-01-02T15:04:05.000000123Z > PRIVMSG #chan :a > b\r\n", RecordedLine{at, true, "PRIVMSG #chan :a > b"}, true},
+		{"2020-01-02T15:04:05.000000123Z <", RecordedLine{}, false},
+		{"2020-01-02 < PING :x", RecordedLine{}, false},
+		{"2020-01-02T15:04:05.000000123Z - PING :x", RecordedLine{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			l, err := ParseRecordedLine(test.line)
+			if (err == nil) != test.ok {
+				t.Fatalf("Expected ok %v, got error %v instead.", test.ok, err)
+			}
+			if test.ok && (!l.Time.Equal(test.expected.Time) || l.Sent != test.expected.Sent || l.Raw != test.expected.Raw) {
+				t.Errorf("Expected %v, got %v instead.", test.expected, l)
+			}
+		})
+	}
+}
+
+func TestWriteRecord(t *testing.T) {
+	var b bytes.Buffer
+	writeRecord(&b, rawLine{line: ":irc.test PING :x"})
+	writeRecord(&b, rawLine{line: "PONG :x", sent: true})
+
+	lines, err := ReadRecording(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal("Can't read the recorded lines", err)
+	}
+	if len(lines) != 2 || lines[0].Sent || lines[0].Raw != ":irc.test PING :x" || !lines[1].Sent || lines[1].Raw != "PONG :x" {
+		t.Errorf("Expected the PING received & the PONG sent, got %v instead.", lines)
+	}
+	if lines[1].Time.Before(lines[0].Time) || time.Since(lines[0].Time) > time.Minute {
+		t.Errorf("Expected the current times, got %v instead.", lines)
+	}
+
+	if _, err := ReadRecording(strings.NewReader("not a recorded line")); err == nil {
+		t.Error("Expected an error reading an invalid line, got nil instead.")
+	}
+}
+
+func TestIRC_HookSentLines(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Flood: config.Flood{Burst: 1, Interval: config.Duration(time.Hour)}})
+	i.mu.Lock()
+	i.hooked = make(chan struct{})
+	i.mu.Unlock()
+
+	hooked := func(expected string) {
+		t.Helper()
+		select {
+		case l := <-i.raw:
+			if !l.sent || l.line != expected {
+				t.Fatalf("Expected the sent line %q, got %+v instead.", expected, l)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the sent line %q, got nothing.", expected)
+		}
+	}
+
+	i.SendRaw("PRIVMSG #a :1")
+	hooked("PRIVMSG #a :1")
+	expectLines(t, lines, "PRIVMSG #a :1")
+	// waiting for the flood protection, then dropped
+	i.SendRaw("PRIVMSG #a :2")
+	i.DropPending("#a")
+	i.SendRaw("PONG :x")
+	hooked("PONG :x")
+	expectLines(t, lines, "PONG :x")
+
+	select {
+	case l := <-i.raw:
+		t.Errorf("Expected only the lines written, got %+v too.", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,143 @@
+package gophirc
+
+import (
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// Config returns the server's config, safe to call while Reload replaces it.
+func (irc *IRC) Config() *config.Server {
+	irc.serverMu.RLock()
+	defer irc.serverMu.RUnlock()
+	return irc.Server
+}
+
+// Reload replaces the server's config with the new one, which must have been checked, applying
+// the changes live: the channels added are joined & the ones removed parted, the admins, ignored
+// users & roles replace the ACL's, and the new nickname is asked for, then regained if enabled.
+// If the settings used to connect & register changed, we quit & reconnect with the new ones,
+// unless reconnecting is disabled. The other settings, e.g. flood & keep-alive, apply from the
+// next connection on, while the workers & the record file need Run to be called again.
+func (irc *IRC) Reload(s *config.Server) config.ServerDiff {
+	irc.serverMu.Lock()
+	old := irc.Server
+	irc.Server = s
+	irc.serverMu.Unlock()
+
+	d := old.Diff(s)
+	d.Join, d.Part = irc.renamedChannels(d.Join, d.Part)
+	if d.Empty() {
+		return d
+	}
+	logger.Log.WithFields(logger.Fields(map[string]interface{}{
+		"join": d.Join, "part": d.Part, "nick": d.Nickname, "acl": d.ACL, "reconnect": d.Reconnect,
+	})).Infoln("Reloading the config")
+
+	if d.ACL {
+		irc.acl.load(s)
+	}
+	// not to be joined again if we reconnect before parting them
+	irc.forgetChannels(d.Part)
+
+	irc.caps.Lock()
+	for _, c := range s.Capabilities {
+		irc.caps.wanted[c] = true
+	}
+	if s.SASLMechanism != "" {
+		irc.caps.wanted["sasl"] = true
+	}
+	irc.caps.Unlock()
+
+	irc.mu.Lock()
+	connected := irc.conn != nil && !irc.State.Disconnected.Value
+	irc.mu.Unlock()
+	if !connected {
+		return d
+	}
+
+	if d.Reconnect {
+		if !s.Reconnect.Disabled {
+			irc.reconnectNow(s.QuitMessage)
+		} else {
+			logger.Log.Warnln("Connection settings changed, but reconnecting is disabled")
+		}
+		return d
+	}
+
+	irc.nicks.Lock()
+	welcomed, monitored := irc.nicks.welcomed, irc.nicks.monitored
+	if d.Nickname {
+		irc.nicks.monitored = false
+	}
+	irc.nicks.Unlock()
+	// not registered yet, the channels are joined on 001 & the nickname sent on the next connection
+	if !welcomed {
+		return d
+	}
+
+	if d.Nickname {
+		if monitored {
+			irc.SendRawf("MONITOR - %s", old.Nickname)
+		}
+		irc.regain()
+		irc.startRegain()
+	}
+	for _, c := range d.Part {
+		irc.Part(c)
+	}
+	for _, c := range d.Join {
+		irc.Join(c)
+	}
+	return d
+}
+
+// renamedChannels drops the channels both joined & parted, their names differing only by case
+// as per the server's case mapping.
+func (irc *IRC) renamedChannels(join, part []string) ([]string, []string) {
+	var j, p []string
+	for _, c := range join {
+		if !irc.hasChannel(part, c) {
+			j = append(j, c)
+		}
+	}
+	for _, c := range part {
+		if !irc.hasChannel(join, c) {
+			p = append(p, c)
+		}
+	}
+	return j, p
+}
+
+// hasChannel returns whether the channel is in the list, as per the server's case mapping.
+func (irc *IRC) hasChannel(channels []string, name string) bool {
+	for _, c := range channels {
+		if irc.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetChannels drops the channels from the ones rejoined after reconnecting, as per the server's
+// case mapping.
+func (irc *IRC) forgetChannels(channels []string) {
+	irc.session.Lock()
+	defer irc.session.Unlock()
+
+	for folded, c := range irc.session.channels {
+		if irc.hasChannel(channels, c) {
+			delete(irc.session.channels, folded)
+		}
+	}
+}
+
+// reconnectNow quits with the message, Run reconnecting once the server closes the connection,
+// or after a few seconds.
+func (irc *IRC) reconnectNow(message string) {
+	logger.Log.Infoln("Connection settings changed, reconnecting")
+	irc.SendRaw("QUIT :" + message)
+	c := irc.connection()
+	time.AfterFunc(quitTimeout, func() { c.Close() })
+}
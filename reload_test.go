@@ -0,0 +1,165 @@
+package gophirc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+	"github.com/vlad-s/gophirc/irctest"
+)
+
+// reloadServer runs a bot joining #first & #second on a test server, stopping it at cleanup.
+func reloadServer(t *testing.T) (*IRC, *irctest.Server) {
+	s, err := irctest.NewServer()
+	if err != nil {
+		t.Fatal("Can't start the test server", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	c, err := s.Config("bot")
+	if err != nil {
+		t.Fatal("Invalid test server config", err)
+	}
+	c.Channels = []string{"#first", "#second"}
+	c.Reconnect.Delay = config.Duration(10 * time.Millisecond)
+	i := New(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-run
+	})
+
+	expectLine(t, s, "JOIN #first")
+	expectLine(t, s, "JOIN #second")
+	return i, s
+}
+
+// reloaded returns a copy of the server's config, changed by fn.
+func reloaded(s *config.Server, fn func(s *config.Server)) *config.Server {
+	c := *s
+	fn(&c)
+	return &c
+}
+
+func TestIRC_Reload(t *testing.T) {
+	i, s := reloadServer(t)
+	admin := &User{Nick: "alice", User: "u", Host: "h"}
+
+	d := i.Reload(reloaded(i.Config(), func(c *config.Server) {
+		c.Channels = []string{"#second", "#third"}
+		c.Admins = []string{"alice!*@*"}
+		c.Nickname = "bot2"
+		c.QuitMessage = "See you"
+	}))
+	if d.Reconnect {
+		t.Error("Expected no reconnect, got one instead.")
+	}
+	expectLine(t, s, "NICK bot2")
+	expectLine(t, s, "PART #first")
+	expectLine(t, s, "JOIN #third")
+
+	if !i.IsAdmin(admin) {
+		t.Errorf("Expected %v to be an admin, got false instead.", admin)
+	}
+	if q := i.Config().QuitMessage; q != "See you" {
+		t.Errorf("Expected the quit message %q, got %q instead.", "See you", q)
+	}
+
+	// nothing changed
+	if d := i.Reload(reloaded(i.Config(), func(*config.Server) {})); !d.Empty() {
+		t.Errorf("Expected no changes, got %+v instead.", d)
+	}
+
+	// the same channels as per the rfc1459 case mapping
+	d = i.Reload(reloaded(i.Config(), func(c *config.Server) { c.Channels = []string{"#SECOND", "#Third"} }))
+	if !d.Empty() {
+		t.Errorf("Expected no changes, got %+v instead.", d)
+	}
+}
+
+func TestIRC_ReloadReconnect(t *testing.T) {
+	i, s := reloadServer(t)
+	connected := make(chan string, 2)
+	i.On(EventConnected, func(e *Event) {
+		connected <- e.Arguments[0]
+	})
+
+	d := i.Reload(reloaded(i.Config(), func(c *config.Server) {
+		c.Username = "newuser"
+		c.Channels = append(c.Channels, "#third")
+	}))
+	if !d.Reconnect {
+		t.Error("Expected a reconnect, got none instead.")
+	}
+	expectLine(t, s, "QUIT")
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("Expected to reconnect, got nothing.")
+	}
+	expectLine(t, s, "USER newuser")
+	expectLine(t, s, "JOIN #first")
+	expectLine(t, s, "JOIN #second")
+	expectLine(t, s, "JOIN #third")
+}
+
+func TestIRC_ReloadReconnectPart(t *testing.T) {
+	i, s := reloadServer(t)
+	connected := make(chan string, 2)
+	i.On(EventConnected, func(e *Event) {
+		connected <- e.Arguments[0]
+	})
+	// wait for the server's JOINs for #first & #second
+	joined := func() int {
+		i.session.Lock()
+		defer i.session.Unlock()
+		return len(i.session.channels)
+	}
+	for deadline := time.Now().Add(time.Second); joined() < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected to be in #first & #second.")
+		}
+	}
+
+	i.Reload(reloaded(i.Config(), func(c *config.Server) {
+		c.Username = "newuser"
+		c.Channels = []string{"#second"}
+	}))
+	expectLine(t, s, "QUIT")
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("Expected to reconnect, got nothing.")
+	}
+	expectLine(t, s, "USER newuser")
+	expectLine(t, s, "JOIN #second")
+	if l, err := s.Expect("JOIN", 200*time.Millisecond); err == nil {
+		t.Errorf("Expected the removed channel not to be joined, got %q.", l.Raw)
+	}
+}
+
+func TestIRC_ReloadDisconnected(t *testing.T) {
+	i := New(&config.Server{Nickname: "gophirc", Channels: []string{"#chan"}})
+
+	d := i.Reload(&config.Server{Nickname: "gophirc2", Address: "irc.other.tld", Ignore: []string{"spammer"}})
+	if !d.Reconnect || !d.Nickname || !d.ACL {
+		t.Errorf("Expected the nickname, the ACL & the connection to change, got %+v instead.", d)
+	}
+	if nick := i.CurrentNick(); nick != "gophirc2" {
+		t.Errorf("Expected %q, got %q instead.", "gophirc2", nick)
+	}
+	if channels := i.rejoinChannels(); len(channels) != 0 {
+		t.Errorf("Expected no channels to join, got %q instead.", channels)
+	}
+	if !i.IsIgnored(&User{Nick: "spammer"}) {
+		t.Error("Expected the user to be ignored, got false instead.")
+	}
+}
@@ -0,0 +1,140 @@
+package gophirc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultReplayTimeout is how long the Replayer waits for each line sent, if not set.
+const defaultReplayTimeout = time.Second
+
+// Replayer feeds a recorded session to a bot over an in-memory connection, checking that the
+// bot sends the recorded lines, in order. The received lines are fed as fast as the bot reads
+// them, up to the next line it has to send.
+type Replayer struct {
+	Lines   []RecordedLine
+	Timeout time.Duration // waiting for each line sent, a second if zero
+
+	// Equal compares the recorded lines sent with the ones the bot sends, e.g. to ignore
+	// the PING tokens or the CTCP TIME replies. Without it, the lines must be identical.
+	Equal func(expected, got string) bool
+}
+
+// ReplayError is a line the bot didn't send as recorded.
+type ReplayError struct {
+	Line     int // 1-based, in the recorded session
+	Expected string
+	Got      string // empty if the bot sent nothing in time
+}
+
+func (e *ReplayError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("Line %d: expected %q, got nothing", e.Line, e.Expected)
+	}
+	return fmt.Sprintf("Line %d: expected %q, got %q", e.Line, e.Expected, e.Got)
+}
+
+// LoadReplay returns a Replayer for the session recorded in the file.
+func LoadReplay(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't open the recorded session")
+	}
+	defer f.Close()
+
+	lines, err := ReadRecording(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{Lines: lines}, nil
+}
+
+// Replay runs the bot over an in-memory connection, feeding it the session. It returns a
+// ReplayError on the first line the bot doesn't send as recorded, or the error ending Run.
+// The bot is disconnected once the session is over, its callbacks having returned; a session
+// ending with the bot's QUIT is disconnected with its message instead of waiting for it.
+func (r *Replayer) Replay(irc *IRC) error {
+	lines, quit := r.Lines, ""
+	if n := len(lines); n > 0 && lines[n-1].Sent {
+		if m, err := ParseMessage(lines[n-1].Raw); err == nil && strings.EqualFold(m.Command, "QUIT") {
+			lines = lines[:n-1]
+			if len(m.Params) > 0 {
+				quit = m.Params[0]
+			}
+		}
+	}
+
+	client, server := net.Pipe()
+	irc.connected(client, "replay")
+
+	sent := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		s := bufio.NewScanner(server)
+		for s.Scan() {
+			select {
+			case sent <- s.Text():
+			case <-done:
+			}
+		}
+	}()
+
+	run := make(chan error, 1)
+	go func() {
+		run <- irc.Run(context.Background())
+	}()
+
+	err := r.feed(lines, server, sent, run)
+
+	irc.Disconnect(quit)
+	close(done)
+	server.Close()
+	if rErr := <-run; err == nil {
+		err = rErr
+	}
+	return err
+}
+
+// feed writes the received lines to the connection & checks the lines sent, until the
+// session is over or Run returns.
+func (r *Replayer) feed(lines []RecordedLine, server net.Conn, sent <-chan string, run chan error) error {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultReplayTimeout
+	}
+	equal := r.Equal
+	if equal == nil {
+		equal = func(expected, got string) bool { return expected == got }
+	}
+
+	for n, l := range lines {
+		if !l.Sent {
+			server.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := server.Write([]byte(l.Raw + "\r\n")); err != nil {
+				return errors.Wrapf(err, "Line %d: can't feed the line", n+1)
+			}
+			continue
+		}
+
+		select {
+		case got := <-sent:
+			if !equal(l.Raw, got) {
+				return &ReplayError{Line: n + 1, Expected: l.Raw, Got: got}
+			}
+		case <-time.After(timeout):
+			return &ReplayError{Line: n + 1, Expected: l.Raw}
+		case err := <-run:
+			// Replay waits for Run to return
+			run <- err
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package gophirc
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+const replaySession = `
+2020-01-02T15:04:05.000000001Z < :irc.test NOTICE * :*** Looking up your hostname...
+2020-01-02T15:04:05.000000002Z > CAP LS 302
+2020-01-02T15:04:05.000000003Z > USER gophirc 8 * gophirc
+2020-01-02T15:04:05.000000004Z > NICK gophirc
+2020-01-02T15:04:05.000000005Z < :irc.test CAP * LS :
+2020-01-02T15:04:05.000000006Z > CAP END
+2020-01-02T15:04:05.000000007Z < :irc.test 001 gophirc :Welcome to the IRCTest IRC Network gophirc
+2020-01-02T15:04:05.000000008Z > JOIN #chan
+2020-01-02T15:04:05.000000009Z < :gophirc!gophirc@localhost JOIN #chan
+2020-01-02T15:04:05.00000001Z < :someone!u@h PRIVMSG #chan :!ping
+2020-01-02T15:04:05.000000011Z > PRIVMSG #chan :pong
+`
+
+// replayIRC returns a bot answering "!ping" in #chan, recording its session to the file.
+func replayIRC(record string) *IRC {
+	i := New(&config.Server{
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		Channels:  []string{"#chan"},
+		Flood:     config.Flood{Disabled: true},
+		Reconnect: config.Reconnect{Disabled: true},
+		Record:    record,
+	})
+	i.OnPrivMsg(func(m *MessageEvent) {
+		if m.Text == "!ping" {
+			i.PrivMsg(m.ReplyTo, "pong")
+		}
+	})
+	return i
+}
+
+func TestReplayer_Replay(t *testing.T) {
+	lines, err := ReadRecording(strings.NewReader(replaySession))
+	if err != nil {
+		t.Fatal("Can't read the session", err)
+	}
+
+	record := filepath.Join(t.TempDir(), "session.log")
+	r := &Replayer{Lines: lines}
+	if err := r.Replay(replayIRC(record)); err != nil {
+		t.Fatal("Replay failed", err)
+	}
+
+	// the replayed session is recorded again, with other times
+	r, err = LoadReplay(record)
+	if err != nil {
+		t.Fatal("Can't load the recorded session", err)
+	}
+	// with the QUIT sent once the session was over, if it was written in time
+	r.Lines = append(r.Lines, RecordedLine{Sent: true, Raw: "QUIT :"})
+	if n := len(r.Lines); r.Lines[n-2].Raw == "QUIT :" {
+		r.Lines = r.Lines[:n-1]
+	}
+	if err := r.Replay(replayIRC(filepath.Join(t.TempDir(), "again.log"))); err != nil {
+		t.Fatal("Replaying the recorded session failed", err)
+	}
+
+	r.Lines = r.Lines[:len(r.Lines)-1]
+	for i := range r.Lines {
+		lines[i].Time = time.Time{}
+		r.Lines[i].Time = time.Time{}
+	}
+	if !reflect.DeepEqual(r.Lines, lines) {
+		t.Errorf("Expected the recorded lines %v, got %v instead.", lines, r.Lines)
+	}
+}
+
+func TestReplayer_ReplayErrors(t *testing.T) {
+	lines, _ := ReadRecording(strings.NewReader(replaySession))
+	last := len(lines) - 1
+
+	tests := []struct {
+		name     string
+		expected string // the last line sent, replaced
+		equal    func(expected, got string) bool
+		err      *ReplayError
+	}{
+		{"different line", "PRIVMSG #chan :pang", nil,
+			&ReplayError{Line: last + 1, Expected: "PRIVMSG #chan :pang", Got: "PRIVMSG #chan :pong"}},
+		{"custom comparison", "privmsg #chan :PONG", strings.EqualFold, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := append([]RecordedLine{}, lines...)
+			l[last].Raw = test.expected
+
+			r := &Replayer{Lines: l, Equal: test.equal}
+			err := r.Replay(replayIRC(""))
+			if test.err == nil {
+				if err != nil {
+					t.Errorf("Expected no error, got %v instead.", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(err, test.err) {
+				t.Errorf("Expected %v, got %v instead.", test.err, err)
+			}
+		})
+	}
+
+	t.Run("nothing sent", func(t *testing.T) {
+		// the bot has no callbacks answering "!ping"
+		i := New(&config.Server{Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+			Channels: []string{"#chan"}, Flood: config.Flood{Disabled: true}})
+
+		r := &Replayer{Lines: lines, Timeout: 100 * time.Millisecond}
+		expected := &ReplayError{Line: last + 1, Expected: "PRIVMSG #chan :pong"}
+		if err := r.Replay(i); !reflect.DeepEqual(err, expected) {
+			t.Errorf("Expected %v, got %v instead.", expected, err)
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package gophirc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUsage can be returned by a command handler to reply with the command's usage.
+var ErrUsage = errors.New("Invalid usage")
+
+// ErrUnbalancedQuotes is returned by ParseArgs when a quote isn't closed.
+var ErrUnbalancedQuotes = errors.New("Unbalanced quotes")
+
+// Permission is the level a user needs to run a command. Every level includes the ones below,
+// and the admins from the config are allowed everything.
+type Permission int
+
+// The permission levels, the voice & op levels being checked in the channel the command is
+// sent to. In private messages, only the admins have them.
+const (
+	PermissionAnyone Permission = iota
+	PermissionVoice             // voice or higher in the channel
+	PermissionOp                // op or higher in the channel
+	PermissionAdmin             // listed in the config's admins
+)
+
+// Command is a bot command registered on a Router.
+type Command struct {
+	Name    string
+	Aliases []string
+	Usage   string // arguments, e.g. "<nick> [reason]"
+	Help    string // short description, shown by the help command
+
+	MinArgs int // minimum number of arguments
+	MaxArgs int // maximum number of arguments, 0 meaning no limit
+
+	Permission Permission
+	Requires   string                   // ACL permission needed, if any
+	Allow      func(*CommandEvent) bool // optional extra check, after the permission level
+
+	// Handler runs the command. A returned error is replied to the user; ErrUsage replies
+	// with the command's usage.
+	Handler func(*CommandEvent) error
+}
+
+// CommandEvent is a command sent by a user, along with the event it was parsed from.
+type CommandEvent struct {
+	*Event
+	Command *Command
+	Name    string   // name the command was called with, one of its aliases maybe
+	Args    []string // arguments, quotes removed
+
+	irc *IRC
+}
+
+// Reply sends a message back to the channel or the user the command came from.
+func (e *CommandEvent) Reply(message string) {
+	e.irc.PrivMsg(e.ReplyTo, message)
+}
+
+// Replyf is simply a wrapper for Reply & fmt.Sprintf.
+func (e *CommandEvent) Replyf(format string, args ...interface{}) {
+	e.Reply(fmt.Sprintf(format, args...))
+}
+
+// Router dispatches the PRIVMSG events to the commands registered. A command is recognized
+// if it starts with the prefix (e.g. "!cmd"), if it's addressed to the bot ("botnick: cmd"),
+// or, in private messages, as is.
+type Router struct {
+	irc    *IRC
+	prefix string
+
+	mu       sync.RWMutex
+	commands map[string]*Command // by lower case name & alias
+}
+
+// NewRouter returns a router for the commands starting with the prefix, bound to the PRIVMSG
+// events. It registers a help command, listing the commands or describing one of them.
+func (irc *IRC) NewRouter(prefix string) *Router {
+	r := &Router{irc: irc, prefix: prefix, commands: make(map[string]*Command)}
+
+	r.Handle(&Command{
+		Name:    "help",
+		Usage:   "[command]",
+		Help:    "Lists the commands, or shows the usage of a command",
+		MaxArgs: 1,
+		Handler: r.help,
+	})
+
+	irc.AddEventCallback("PRIVMSG", r.dispatch)
+	return r
+}
+
+// Handle registers a command, replacing any command with the same name or alias.
+func (r *Router) Handle(c *Command) *Router {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		r.commands[strings.ToLower(name)] = c
+	}
+	return r
+}
+
+// Commands returns the commands registered, sorted by name.
+func (r *Router) Commands() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var commands []*Command
+	for name, c := range r.commands {
+		if strings.EqualFold(name, c.Name) {
+			commands = append(commands, c)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// command returns the command with the name or alias.
+func (r *Router) command(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.commands[strings.ToLower(name)]
+	return c, ok
+}
+
+// strip returns the message without the prefix or the bot's nickname, and whether it's a
+// command at all.
+func (r *Router) strip(e *Event) (string, bool) {
+	message := e.Message
+	if r.prefix != "" && strings.HasPrefix(message, r.prefix) {
+		return message[len(r.prefix):], true
+	}
+
+	if i := strings.IndexAny(message, ":,"); i > 0 && r.irc.isMe(message[:i]) {
+		return strings.TrimSpace(message[i+1:]), true
+	}
+
+	// private messages don't need a prefix
+	return message, !r.irc.IsChannel(e.ReplyTo)
+}
+
+func (r *Router) dispatch(e *Event) {
+	if e.ReplyTo == "" || e.User == nil {
+		return
+	}
+
+	message, ok := r.strip(e)
+	if !ok {
+		return
+	}
+	fields := strings.SplitN(message, " ", 2)
+	c, ok := r.command(fields[0])
+	if !ok {
+		return
+	}
+	ce := &CommandEvent{Event: e, Command: c, Name: fields[0], irc: r.irc}
+
+	if len(fields) > 1 {
+		args, err := ParseArgs(fields[1])
+		if err != nil {
+			r.irc.PrivMsgf(e.ReplyTo, "%s: %s", e.User.Nick, err)
+			return
+		}
+		ce.Args = args
+	}
+
+	if !r.allowed(ce) {
+		r.irc.PrivMsgf(e.ReplyTo, "%s: You're not allowed to use %s", e.User.Nick, c.Name)
+		return
+	}
+
+	if len(ce.Args) < c.MinArgs || (c.MaxArgs > 0 && len(ce.Args) > c.MaxArgs) {
+		r.irc.PrivMsg(e.ReplyTo, r.usage(c))
+		return
+	}
+
+	if err := c.Handler(ce); err == ErrUsage {
+		r.irc.PrivMsg(e.ReplyTo, r.usage(c))
+	} else if err != nil {
+		r.irc.PrivMsgf(e.ReplyTo, "%s: %s", e.User.Nick, err)
+	}
+}
+
+// allowed returns whether the user can run the command, by its permission level, ACL
+// permission & check.
+func (r *Router) allowed(e *CommandEvent) bool {
+	if r.level(e) < e.Command.Permission {
+		return false
+	}
+	if e.Command.Requires != "" && !r.irc.Can(e.Event, e.Command.Requires) {
+		return false
+	}
+	return e.Command.Allow == nil || e.Command.Allow(e)
+}
+
+// level returns the permission level of the user, in the channel the command was sent to.
+func (r *Router) level(e *CommandEvent) Permission {
+	if r.irc.HasRole(e.Event, RoleAdmin) {
+		return PermissionAdmin
+	}
+
+	ch, ok := r.irc.Channel(e.ReplyTo)
+	if !ok {
+		return PermissionAnyone
+	}
+	m, ok := ch.Member(e.User.Nick)
+	switch {
+	case !ok:
+		return PermissionAnyone
+	case m.IsOp():
+		return PermissionOp
+	case m.IsVoiced() || m.HasPrefix('%'):
+		return PermissionVoice
+	}
+	return PermissionAnyone
+}
+
+// usage returns the usage line of the command, e.g. "Usage: !kick <nick> [reason]".
+func (r *Router) usage(c *Command) string {
+	usage := "Usage: " + r.prefix + c.Name
+	if c.Usage != "" {
+		usage += " " + c.Usage
+	}
+	return usage
+}
+
+// help lists the commands the user is allowed to run, or describes a command.
+func (r *Router) help(e *CommandEvent) error {
+	if len(e.Args) == 1 {
+		c, ok := r.command(e.Args[0])
+		if !ok {
+			return fmt.Errorf("Unknown command %q", e.Args[0])
+		}
+		r.irc.PrivMsg(e.ReplyTo, r.usage(c))
+		if c.Help != "" {
+			r.irc.PrivMsg(e.ReplyTo, c.Help)
+		}
+		return nil
+	}
+
+	var names []string
+	for _, c := range r.Commands() {
+		if r.allowed(&CommandEvent{Event: e.Event, Command: c, irc: r.irc}) {
+			names = append(names, r.prefix+c.Name)
+		}
+	}
+	r.irc.PrivMsgf(e.ReplyTo, "Commands: %s", strings.Join(names, ", "))
+	return nil
+}
+
+// ParseArgs splits a string into arguments on whitespace. Double or single quotes group the
+// words, and a backslash escapes the next character, except inside single quotes.
+// A trailing backslash is kept as is.
+func ParseArgs(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, ErrUnbalancedQuotes
+	}
+	if escaped {
+		current.WriteRune('\\')
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
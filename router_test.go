@@ -0,0 +1,114 @@
+package gophirc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args       string
+		expected   []string
+		shouldFail bool
+	}{
+		{"", nil, false},
+		{"one two  three", []string{"one", "two", "three"}, false},
+		{`"quoted arg" 'single one'`, []string{"quoted arg", "single one"}, false},
+		{`with\ space "esc\"aped" 'no\esc'`, []string{"with space", `esc"aped`, `no\esc`}, false},
+		{`empty "" ''`, []string{"empty", "", ""}, false},
+		{`mid"dle quo"te`, []string{"middle quote"}, false},
+		{`trailing\`, []string{`trailing\`}, false},
+		{`"unbalanced`, nil, true},
+		{`it's`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			actual, err := ParseArgs(test.args)
+			if (test.shouldFail && err == nil) || (!test.shouldFail && err != nil) {
+				t.Fatalf("%q - should fail: %v, got err %q", test.args, test.shouldFail, err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("%q: expected %q, got %q instead.", test.args, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc", Admins: []string{"admin"}})
+	r := i.NewRouter("!")
+
+	var called []string
+	r.Handle(&Command{
+		Name: "say", Aliases: []string{"echo"}, Usage: "<message>", Help: "Says the message",
+		MinArgs: 1,
+		Handler: func(e *CommandEvent) error {
+			called = append(called, e.Name)
+			e.Reply(strings.Join(e.Args, "|"))
+			return nil
+		},
+	}).Handle(&Command{
+		Name: "kick", Usage: "<nick>", MinArgs: 1, MaxArgs: 1, Permission: PermissionOp,
+		Handler: func(e *CommandEvent) error {
+			if e.Args[0] == "gophirc" {
+				return ErrUsage
+			}
+			return errors.New("Can't kick " + e.Args[0])
+		},
+	}).Handle(&Command{
+		Name: "restart", Permission: PermissionAdmin,
+		Handler: func(e *CommandEvent) error {
+			e.Reply("Restarting")
+			return nil
+		},
+	})
+
+	for _, raw := range []string{
+		":gophirc!u@h JOIN #chan",
+		":irc.server.tld 353 gophirc = #chan :gophirc @op +voiced user admin",
+		":irc.server.tld 366 gophirc #chan :End of /NAMES list.",
+	} {
+		i.ReadEvent(raw)
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+	}{
+		{"prefix", `:user!u@h PRIVMSG #chan :!say "hello world" again`, []string{"PRIVMSG #chan :hello world|again"}},
+		{"alias", `:user!u@h PRIVMSG #chan :!ECHO hi`, []string{"PRIVMSG #chan :hi"}},
+		{"addressed", `:user!u@h PRIVMSG #chan :GOPHIRC: say hi`, []string{"PRIVMSG #chan :hi"}},
+		{"private", `:user!u@h PRIVMSG gophirc :say hi`, []string{"PRIVMSG user :hi"}},
+		{"no prefix", `:user!u@h PRIVMSG #chan :say hi`, nil},
+		{"unknown", `:user!u@h PRIVMSG #chan :!unknown "arg`, nil},
+		{"unbalanced", `:user!u@h PRIVMSG #chan :!say "arg`, []string{"PRIVMSG #chan :user: Unbalanced quotes"}},
+		{"too few args", `:user!u@h PRIVMSG #chan :!say`, []string{"PRIVMSG #chan :Usage: !say <message>"}},
+		{"not allowed", `:voiced!u@h PRIVMSG #chan :!kick user`, []string{"PRIVMSG #chan :voiced: You're not allowed to use kick"}},
+		{"op", `:op!u@h PRIVMSG #chan :!kick user`, []string{"PRIVMSG #chan :op: Can't kick user"}},
+		{"too many args", `:op!u@h PRIVMSG #chan :!kick a b`, []string{"PRIVMSG #chan :Usage: !kick <nick>"}},
+		{"usage error", `:op!u@h PRIVMSG #chan :!kick gophirc`, []string{"PRIVMSG #chan :Usage: !kick <nick>"}},
+		{"op in private", `:op!u@h PRIVMSG gophirc :kick user`, []string{"PRIVMSG op :op: You're not allowed to use kick"}},
+		{"admin", `:admin!u@h PRIVMSG gophirc :restart`, []string{"PRIVMSG admin :Restarting"}},
+		{"help", `:op!u@h PRIVMSG #chan :!help`, []string{"PRIVMSG #chan :Commands: !help, !kick, !say"}},
+		{"help admin", `:admin!u@h PRIVMSG #chan :!help`, []string{"PRIVMSG #chan :Commands: !help, !kick, !restart, !say"}},
+		{"help command", `:user!u@h PRIVMSG #chan :!help echo`, []string{"PRIVMSG #chan :Usage: !say <message>", "PRIVMSG #chan :Says the message"}},
+		{"help unknown", `:user!u@h PRIVMSG #chan :!help nope`, []string{`PRIVMSG #chan :user: Unknown command "nope"`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i.ReadEvent(test.raw)
+			expectLines(t, lines, test.expected...)
+			expectNoLines(t, lines)
+		})
+	}
+
+	expected := []string{"say", "ECHO", "say", "say"}
+	if !reflect.DeepEqual(called, expected) {
+		t.Errorf("Expected calls %q, got %q instead.", expected, called)
+	}
+}
@@ -0,0 +1,183 @@
+package gophirc
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vlad-s/gophirc/logger"
+)
+
+// ErrSASLFailed is returned by Run when the SASL authentication is required & fails.
+var ErrSASLFailed = errors.New("SASL authentication failed")
+
+// SASLResult is the outcome of the SASL authentication, parsed from the numerics 903 to 907.
+type SASLResult int
+
+// The possible SASL results, SASLNone meaning no authentication was attempted.
+const (
+	SASLNone    SASLResult = iota
+	SASLSuccess            // 903 RPL_SASLSUCCESS
+	SASLFailed             // 904 ERR_SASLFAIL, or the mechanism isn't supported by the server
+	SASLTooLong            // 905 ERR_SASLTOOLONG
+	SASLAborted            // 906 ERR_SASLABORTED
+	SASLAlready            // 907 ERR_SASLALREADY
+)
+
+var saslNumerics = map[string]SASLResult{
+	"903": SASLSuccess,
+	"904": SASLFailed,
+	"905": SASLTooLong,
+	"906": SASLAborted,
+	"907": SASLAlready,
+}
+
+// String returns the name of the result.
+func (r SASLResult) String() string {
+	switch r {
+	case SASLSuccess:
+		return "success"
+	case SASLFailed:
+		return "failed"
+	case SASLTooLong:
+		return "too long"
+	case SASLAborted:
+		return "aborted"
+	case SASLAlready:
+		return "already authenticated"
+	}
+	return "none"
+}
+
+// Authenticated returns whether the result means we're logged in.
+func (r SASLResult) Authenticated() bool {
+	return r == SASLSuccess || r == SASLAlready
+}
+
+// SASLAuthenticated returns whether we're logged in with SASL on this connection, safe to call
+// from the callbacks.
+func (irc *IRC) SASLAuthenticated() bool {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	return irc.State.SASL.Authenticated()
+}
+
+// authenticate starts the SASL authentication with the mechanism specified in the config.
+func (irc *IRC) authenticate() {
+	mech := irc.Config().SASLMechanism
+
+	if mechs, _ := irc.CapabilityValue("sasl"); mechs != "" {
+		supported := false
+		for _, m := range strings.Split(mechs, ",") {
+			if strings.EqualFold(m, mech) {
+				supported = true
+			}
+		}
+		if !supported {
+			logger.Log.WithField("mechanisms", mechs).Warnf("SASL mechanism %s not supported by the server", mech)
+			irc.saslDone(SASLFailed)
+			return
+		}
+	}
+
+	irc.caps.Lock()
+	irc.caps.authenticating = true
+	irc.caps.Unlock()
+
+	irc.SendRawf("AUTHENTICATE %s", mech)
+}
+
+// handleAuthenticate sends the SASL payload once the server is ready for it.
+func (irc *IRC) handleAuthenticate(e *Event) {
+	if len(e.Arguments) == 0 || e.Arguments[0] != "+" {
+		return
+	}
+
+	s := irc.Config()
+	var payload []byte
+	if s.SASLMechanism == "PLAIN" {
+		user := s.SASLUsername
+		if user == "" {
+			user = s.Nickname
+		}
+		payload = []byte(user + "\x00" + user + "\x00" + s.SASLPassword)
+	}
+	irc.authenticatePayload(payload)
+}
+
+// authenticatePayload sends the base64 encoded payload in chunks of 400 bytes. A chunk shorter
+// than 400 bytes ends the payload, so an exact multiple of 400 is followed by "AUTHENTICATE +".
+func (irc *IRC) authenticatePayload(payload []byte) {
+	enc := base64.StdEncoding.EncodeToString(payload)
+	for len(enc) >= 400 {
+		irc.SendRawf("AUTHENTICATE %s", enc[:400])
+		enc = enc[400:]
+	}
+	if enc == "" {
+		enc = "+"
+	}
+	irc.SendRawf("AUTHENTICATE %s", enc)
+}
+
+// saslLoggedIn returns whether we're being or were authenticated with SASL, rather than with
+// NickServ.
+func (irc *IRC) saslLoggedIn() bool {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	return irc.caps.authenticating || irc.State.SASL.Authenticated()
+}
+
+// saslMissing disconnects if SASL is required & we aren't authenticated, e.g. the server
+// didn't offer or rejected the capability, or doesn't support CAP at all. It returns whether
+// the registration must not go on.
+func (irc *IRC) saslMissing() bool {
+	s := irc.Config()
+	if s.SASLMechanism == "" || !s.SASLRequired {
+		return false
+	}
+	if irc.SASLAuthenticated() {
+		return false
+	}
+
+	if !irc.requested() {
+		logger.Log.Errorln("SASL authentication required but not done, disconnecting")
+		irc.disconnect(ErrSASLFailed, "SASL authentication failed")
+	}
+	return true
+}
+
+// handleSASLResult handles the numerics 903 to 907, ending the authentication.
+func (irc *IRC) handleSASLResult(e *Event) {
+	irc.caps.Lock()
+	authenticating := irc.caps.authenticating
+	irc.caps.authenticating = false
+	irc.caps.Unlock()
+
+	if !authenticating {
+		return
+	}
+
+	r := saslNumerics[e.Code]
+	if !r.Authenticated() && len(e.Arguments) > 0 {
+		logger.Log.WithField("result", r).Warnln(e.Arguments[len(e.Arguments)-1])
+	}
+	irc.saslDone(r)
+}
+
+// saslDone records the result, and either resumes the registration or, if SASL is
+// required & failed, aborts the connection.
+func (irc *IRC) saslDone(r SASLResult) {
+	irc.caps.Lock()
+	irc.State.SASL = r
+	irc.caps.Unlock()
+
+	if r.Authenticated() {
+		logger.Log.Infoln("Successfully authenticated with SASL")
+	} else if irc.Config().SASLRequired {
+		logger.Log.WithField("result", r).Errorln("SASL authentication required, disconnecting")
+		irc.disconnect(ErrSASLFailed, "SASL authentication failed")
+		return
+	}
+
+	irc.capRelease()
+}
@@ -0,0 +1,164 @@
+package gophirc
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func saslServer(mech, password string, required bool) *config.Server {
+	return &config.Server{
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		SASLMechanism: mech, SASLUsername: "account", SASLPassword: password, SASLRequired: required,
+	}
+}
+
+func saslNegotiate(t *testing.T, i *IRC, lines <-chan string, mechs string) {
+	i.Register()
+	expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+
+	i.ReadEvent(":irc.server.tld CAP * LS :multi-prefix " + mechs)
+	expectLines(t, lines, "CAP REQ :sasl")
+	i.ReadEvent(":irc.server.tld CAP * ACK :sasl")
+}
+
+func TestIRC_SASLPlain(t *testing.T) {
+	i, lines := pipeIRC(t, saslServer("PLAIN", "secret", false))
+	saslNegotiate(t, i, lines, "sasl=PLAIN,EXTERNAL")
+	expectLines(t, lines, "AUTHENTICATE PLAIN")
+
+	i.ReadEvent("AUTHENTICATE +")
+	expectLines(t, lines, "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte("account\x00account\x00secret")))
+	expectNoLines(t, lines)
+
+	i.ReadEvent(":irc.server.tld 900 gophirc gophirc!gophirc@host account :You are now logged in as account")
+	i.ReadEvent(":irc.server.tld 903 gophirc :SASL authentication successful")
+	expectLines(t, lines, "CAP END")
+
+	if i.State.SASL != SASLSuccess {
+		t.Errorf("Expected SASL result %v, got %v", SASLSuccess, i.State.SASL)
+	}
+}
+
+func TestIRC_SASLExternal(t *testing.T) {
+	i, lines := pipeIRC(t, saslServer("EXTERNAL", "", false))
+	saslNegotiate(t, i, lines, "sasl")
+	expectLines(t, lines, "AUTHENTICATE EXTERNAL")
+
+	i.ReadEvent("AUTHENTICATE +")
+	expectLines(t, lines, "AUTHENTICATE +")
+
+	i.ReadEvent(":irc.server.tld 907 gophirc :You have already authenticated using SASL")
+	expectLines(t, lines, "CAP END")
+
+	if !i.SASLAuthenticated() {
+		t.Errorf("Expected SASL to be authenticated, got %v", i.State.SASL)
+	}
+}
+
+func TestIRC_SASLChunking(t *testing.T) {
+	// "account\0account\0" is 16 bytes, 600 bytes in total encode to exactly 800 base64 bytes
+	i, lines := pipeIRC(t, saslServer("PLAIN", strings.Repeat("x", 584), false))
+	saslNegotiate(t, i, lines, "sasl")
+	expectLines(t, lines, "AUTHENTICATE PLAIN")
+
+	i.ReadEvent("AUTHENTICATE +")
+	enc := base64.StdEncoding.EncodeToString([]byte("account\x00account\x00" + strings.Repeat("x", 584)))
+	expectLines(t, lines, "AUTHENTICATE "+enc[:400], "AUTHENTICATE "+enc[400:], "AUTHENTICATE +")
+}
+
+func TestIRC_SASLFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		numeric  string
+		result   SASLResult
+		required bool
+		expected string
+	}{
+		{"failed", "904", SASLFailed, false, "CAP END"},
+		{"too long", "905", SASLTooLong, false, "CAP END"},
+		{"aborted", "906", SASLAborted, false, "CAP END"},
+		{"required", "904", SASLFailed, true, "QUIT :SASL authentication failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i, lines := pipeIRC(t, saslServer("PLAIN", "wrong", test.required))
+			saslNegotiate(t, i, lines, "sasl")
+			expectLines(t, lines, "AUTHENTICATE PLAIN")
+
+			i.ReadEvent("AUTHENTICATE +")
+			<-lines
+			i.ReadEvent(":irc.server.tld " + test.numeric + " gophirc :SASL authentication failed")
+			expectLines(t, lines, test.expected)
+
+			if i.State.SASL != test.result {
+				t.Errorf("Expected SASL result %v, got %v", test.result, i.State.SASL)
+			}
+		})
+	}
+}
+
+func TestIRC_SASLUnsupportedMechanism(t *testing.T) {
+	i, lines := pipeIRC(t, saslServer("EXTERNAL", "", true))
+	saslNegotiate(t, i, lines, "sasl=PLAIN")
+	expectLines(t, lines, "QUIT :SASL authentication failed")
+
+	if !i.State.Disconnected.Requested {
+		t.Error("Disconnect should be requested")
+	}
+}
+
+func TestIRC_NickServWithoutCap(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		NickservPassword: "pass", Channels: []string{"#chan"},
+	})
+
+	i.Register()
+	expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+
+	i.ReadEvent(":irc.server.tld 421 gophirc CAP :Unknown command")
+	i.ReadEvent(":irc.server.tld 001 gophirc :Welcome")
+	expectLines(t, lines, "NS IDENTIFY pass")
+
+	i.ReadEvent(":irc.server.tld 900 gophirc gophirc!gophirc@host gophirc :You are now logged in as gophirc")
+	expectLines(t, lines, "JOIN #chan")
+}
+
+func TestIRC_SASLRequiredNotDone(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []string
+	}{
+		{"not offered", []string{":irc.server.tld CAP * LS :multi-prefix"}},
+		{"rejected", []string{":irc.server.tld CAP * LS :sasl", ":irc.server.tld CAP * NAK :sasl"}},
+		{"cap unsupported", []string{":irc.server.tld 421 gophirc CAP :Unknown command"}},
+		{"welcomed", []string{":irc.server.tld 001 gophirc :Welcome"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := saslServer("PLAIN", "secret", true)
+			s.Channels = []string{"#secret"}
+			i, lines := pipeIRC(t, s)
+
+			i.Register()
+			expectLines(t, lines, "CAP LS 302", "USER gophirc 8 * gophirc", "NICK gophirc")
+			for _, r := range test.replies {
+				i.ReadEvent(r)
+				if strings.Contains(r, " LS ") && strings.Contains(r, "sasl") {
+					expectLines(t, lines, "CAP REQ :sasl")
+				}
+			}
+			expectLines(t, lines, "QUIT :SASL authentication failed")
+
+			// the registration isn't completed, nor the channels joined
+			i.ReadEvent(":irc.server.tld 001 gophirc :Welcome")
+			expectNoLines(t, lines)
+			if err := i.disconnectErr(); err != ErrSASLFailed {
+				t.Errorf("Expected %v, got %v instead.", ErrSASLFailed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package gophirc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the maximum length of a line, including the CR LF & the source prefix the
+// server prepends when relaying it.
+const maxLineLength = 512
+
+// Maximum lengths of the username & hostname, used to estimate our prefix until we see it.
+const (
+	maxUserLength = 10
+	maxHostLength = 63
+)
+
+// trackSelf records our own nick!user@host as seen by the others, from our JOINs, the
+// RPL_VISIBLEHOST (396) numeric & CHGHOST.
+func (irc *IRC) trackSelf(e *Event) {
+	irc.session.Lock()
+	defer irc.session.Unlock()
+
+	switch e.Code {
+	case "JOIN":
+		if e.User != nil && irc.isMe(e.User.Nick) {
+			u := *e.User
+			irc.session.self = &u
+		}
+	case "396":
+		if irc.session.self != nil && len(e.Arguments) > 1 {
+			irc.session.self.Host = e.Arguments[1]
+		}
+	case "CHGHOST":
+		if irc.session.self != nil && e.User != nil && irc.isMe(e.User.Nick) && len(e.Arguments) > 1 {
+			irc.session.self.User, irc.session.self.Host = e.Arguments[0], e.Arguments[1]
+		}
+	}
+}
+
+// prefixLength returns the length of the ":nick!user@host " prefix the server adds to our
+// messages, assuming the longest username & hostname if we don't know them yet.
+func (irc *IRC) prefixLength() int {
+	irc.session.Lock()
+	defer irc.session.Unlock()
+
+	if u := irc.session.self; u != nil && irc.isMe(u.Nick) {
+		return len(":" + u.String() + " ")
+	}
+	return len(":"+irc.CurrentNick()+"!@ ") + maxUserLength + maxHostLength
+}
+
+// sendSplit sends the message to the target with the command, split into as many lines as
+// needed to fit in maxLineLength. The prefix & suffix are added around every piece, used for
+// the CTCP framing; with a framing, an empty message is still sent as one line.
+func (irc *IRC) sendSplit(command, target, prefix, suffix, message string) {
+	header := command + " " + target + " :" + prefix
+	budget := maxLineLength - len("\r\n") - irc.prefixLength() - len(header) - len(suffix)
+
+	pieces := splitMessage(message, budget)
+	if len(pieces) == 0 && prefix != "" {
+		pieces = []string{""}
+	}
+	for _, piece := range pieces {
+		irc.SendRaw(header + piece + suffix)
+	}
+}
+
+// splitMessage splits the message on its line breaks, then splits each line into pieces of at
+// most max bytes, breaking on the last space if there's one, or else on a rune boundary, if the
+// line is UTF-8. Empty lines are dropped, as the servers reject empty messages.
+func splitMessage(message string, max int) []string {
+	if max < utf8.UTFMax {
+		max = utf8.UTFMax
+	}
+
+	var pieces []string
+	message = strings.Replace(message, "\r\n", "\n", -1)
+	for _, line := range strings.FieldsFunc(message, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		for len(line) > max {
+			cut := max
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 { // not UTF-8, cut anywhere
+				cut = max
+			}
+			if i := strings.LastIndexByte(line[:cut], ' '); i > 0 && line[cut] != ' ' {
+				cut = i
+			}
+			pieces = append(pieces, line[:cut])
+			line = strings.TrimPrefix(line[cut:], " ")
+		}
+		if line != "" {
+			pieces = append(pieces, line)
+		}
+	}
+	return pieces
+}
@@ -0,0 +1,108 @@
+package gophirc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		max      int
+		expected []string
+	}{
+		{"short", "hello world", 20, []string{"hello world"}},
+		{"empty", "", 20, nil},
+		{"words", "hello there world", 11, []string{"hello there", "world"}},
+		{"exact", "hello world", 11, []string{"hello world"}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"runes", "ăâîșțăâîșț", 5, []string{"ăâ", "îș", "ță", "âî", "șț"}},
+		{"invalid utf-8", strings.Repeat("\x80", 20), 10, []string{strings.Repeat("\x80", 10), strings.Repeat("\x80", 10)}},
+		{"newlines", "one\ntwo\r\nthree\n\nfour\r", 20, []string{"one", "two", "three", "four"}},
+		{"newlines & words", "aa bb cc\ndd", 5, []string{"aa bb", "cc", "dd"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := splitMessage(test.message, test.max)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("%q: expected %q, got %q instead.", test.message, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIRC_PrivMsgSplit(t *testing.T) {
+	i, lines := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+	i.ReadEvent(":gophirc!~gophirc@some.host.tld JOIN #chan")
+	prefix := len(":gophirc!~gophirc@some.host.tld ")
+
+	message := strings.TrimSpace(strings.Repeat("ăbc ", 300))
+	tests := []struct {
+		name   string
+		send   func()
+		header string
+		suffix string
+	}{
+		{"privmsg", func() { i.PrivMsg("#chan", message) }, "PRIVMSG #chan :", ""},
+		{"notice", func() { i.Notice("#chan", message) }, "NOTICE #chan :", ""},
+		{"action", func() { i.Action("#chan", message) }, "PRIVMSG #chan :\001ACTION ", "\001"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.send()
+
+			var joined []string
+			for len(strings.Join(joined, " ")) < len(message) {
+				select {
+				case l := <-lines:
+					if prefix+len(l)+2 > maxLineLength {
+						t.Fatalf("Line too long, %d bytes: %q", prefix+len(l)+2, l)
+					}
+					if !strings.HasPrefix(l, test.header) || !strings.HasSuffix(l, test.suffix) {
+						t.Fatalf("Expected %q...%q framing, got %q instead.", test.header, test.suffix, l)
+					}
+					piece := strings.TrimSuffix(strings.TrimPrefix(l, test.header), test.suffix)
+					if !utf8.ValidString(piece) {
+						t.Fatalf("Line split mid rune: %q", l)
+					}
+					joined = append(joined, piece)
+				case <-time.After(time.Second):
+					t.Fatalf("Expected more lines, got %q", joined)
+				}
+			}
+			if len(joined) < 2 || strings.Join(joined, " ") != message {
+				t.Errorf("Message not split properly: %q", joined)
+			}
+		})
+	}
+
+	i.PrivMsg("nick", "one\ntwo")
+	i.CTCP("nick", "VERSION", "")
+	expectLines(t, lines, "PRIVMSG nick :one", "PRIVMSG nick :two", "NOTICE nick :\001VERSION\001")
+}
+
+func TestIRC_PrefixLength(t *testing.T) {
+	i, _ := pipeIRC(t, &config.Server{Nickname: "gophirc"})
+
+	expected := len(":gophirc!@ ") + maxUserLength + maxHostLength
+	if actual := i.prefixLength(); actual != expected {
+		t.Errorf("Expected %d, got %d instead.", expected, actual)
+	}
+
+	i.ReadEvent(":gophirc!~gophirc@some.host.tld JOIN #chan")
+	i.ReadEvent(":irc.server.tld 396 gophirc hidden.host :is now your displayed host")
+	if actual := i.prefixLength(); actual != len(":gophirc!~gophirc@hidden.host ") {
+		t.Errorf("Expected %d, got %d instead.", len(":gophirc!~gophirc@hidden.host "), actual)
+	}
+
+	i.ReadEvent(":gophirc!~gophirc@hidden.host CHGHOST user new.host")
+	if actual := i.prefixLength(); actual != len(":gophirc!user@new.host ") {
+		t.Errorf("Expected %d, got %d instead.", len(":gophirc!user@new.host "), actual)
+	}
+}
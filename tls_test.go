@@ -0,0 +1,94 @@
+package gophirc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+func tlsListener(t *testing.T) net.Listener {
+	cert, err := tls.LoadX509KeyPair("config/testdata/cert.pem", "config/testdata/key.pem")
+	if err != nil {
+		t.Fatal("Can't load the test certificate", err)
+	}
+
+	pem, err := ioutil.ReadFile("config/testdata/cert.pem")
+	if err != nil {
+		t.Fatal("Can't read the test certificate", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal("Can't listen", err)
+	}
+	return l
+}
+
+func TestIRC_ConnectTLS(t *testing.T) {
+	l := tlsListener(t)
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name       string
+		server     config.Server
+		clientCert bool
+		shouldFail bool
+	}{
+		{"untrusted", config.Server{}, false, true},
+		{"skip verify", config.Server{TLSSkipVerify: true}, false, false},
+		{"ca", config.Server{TLSCAFile: "config/testdata/cert.pem"}, false, false},
+		{"wrong name", config.Server{TLSCAFile: "config/testdata/cert.pem", TLSServerName: "irc.server.tld"}, false, true},
+		{"client cert", config.Server{
+			TLSCAFile:   "config/testdata/cert.pem",
+			TLSCertFile: "config/testdata/cert.pem",
+			TLSKeyFile:  "config/testdata/key.pem",
+		}, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := test.server
+			s.Address, s.Port, s.TLS = "localhost", uint16(port), true
+
+			peer := make(chan []*x509.Certificate, 1)
+			go func() {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				// the handshake completes on the first read
+				bufio.NewReader(c).ReadString('\n')
+				peer <- c.(*tls.Conn).ConnectionState().PeerCertificates
+			}()
+
+			i := New(&s)
+			err := i.Connect()
+			if (test.shouldFail && err == nil) || (!test.shouldFail && err != nil) {
+				t.Fatalf("Should fail: %v, got err %q\n", test.shouldFail, err)
+			}
+			if err != nil {
+				<-peer
+				return
+			}
+			defer i.conn.Close()
+
+			i.conn.Write([]byte("PING :test\r\n"))
+			certs := <-peer
+			if test.clientCert != (len(certs) > 0) {
+				t.Errorf("Expected client certificate: %v, got %d\n", test.clientCert, len(certs))
+			}
+		})
+	}
+}
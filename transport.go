@@ -0,0 +1,128 @@
+package gophirc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dialTimeout bounds the dialing, including the TLS, proxy & WebSocket handshakes.
+const dialTimeout = 5 * time.Second
+
+// Dialer opens a connection to the "host:port" address, the network being "tcp". A net.Dialer is
+// the plain TCP one; TLSDialer, ProxyDialer & WebSocketDialer wrap another Dialer.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// DialerFunc is a function used as a Dialer.
+type DialerFunc func(network, address string) (net.Conn, error)
+
+// Dial calls the function.
+func (f DialerFunc) Dial(network, address string) (net.Conn, error) {
+	return f(network, address)
+}
+
+// SetDialer sets the transport the connections are opened with, instead of plain TCP. The proxy,
+// TLS & WebSocket configured for the server still go over it.
+func (irc *IRC) SetDialer(d Dialer) *IRC {
+	irc.dialer = d
+	return irc
+}
+
+// ConnectWith uses the established connection to the server instead of dialing one, e.g. one
+// end of a net.Pipe. Run uses it until it drops, reconnecting by dialing the server.
+func (irc *IRC) ConnectWith(c net.Conn) {
+	irc.connected(c, c.RemoteAddr().String())
+}
+
+// dial connects to the "host:port" destination, or the WebSocket URL, with the server's
+// transport: the dialer, the proxy, TLS & WebSocket, in this order.
+func (irc *IRC) dial(dest string) (net.Conn, error) {
+	var d Dialer = &net.Dialer{Timeout: dialTimeout}
+	if irc.dialer != nil {
+		d = irc.dialer
+	}
+
+	s := irc.Config()
+	if s.Proxy != "" {
+		var err error
+		if d, err = ProxyDialer(s.Proxy, d); err != nil {
+			return nil, err
+		}
+	}
+
+	ws := s.WebSocket
+	useTLS := s.TLS
+	if ws.URL != "" {
+		u, err := url.Parse(ws.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid WebSocket URL")
+		}
+		dest = webSocketAddress(u)
+		useTLS = u.Scheme == "wss"
+	}
+
+	if useTLS {
+		tc, err := s.TLSConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error loading the TLS config")
+		}
+		if s.TLSServerName == "" {
+			tc.ServerName, _, _ = net.SplitHostPort(dest)
+		}
+		d = TLSDialer(d, tc)
+	}
+
+	if ws.URL != "" {
+		d = WebSocketDialer(d, ws.URL, ws.Binary)
+	}
+
+	c, err := d.Dial("tcp", dest)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error dialing the host")
+	}
+	return c, nil
+}
+
+// TLSDialer returns a Dialer wrapping the connections opened with d in TLS.
+func TLSDialer(d Dialer, config *tls.Config) Dialer {
+	return DialerFunc(func(network, address string) (net.Conn, error) {
+		c, err := d.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		tc := tls.Client(c, config)
+		c.SetDeadline(time.Now().Add(dialTimeout))
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.SetDeadline(time.Time{})
+		return tc, nil
+	})
+}
+
+// bufferedConn is a connection whose first bytes were read while handshaking, e.g. the server's
+// first lines sent right after the proxy's reply.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// withReader returns the connection reading from r first, if r holds any bytes.
+func withReader(c net.Conn, r *bufio.Reader) net.Conn {
+	if r.Buffered() == 0 {
+		return c
+	}
+	return &bufferedConn{Conn: c, r: r}
+}
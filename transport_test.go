@@ -0,0 +1,89 @@
+package gophirc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+// listen accepts a single connection on a local port, handled by handle, returning the address.
+func listen(t *testing.T, handle func(net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Can't listen", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		handle(c)
+	}()
+	return l.Addr().String()
+}
+
+func TestIRC_ConnectWith(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	i := New(&config.Server{
+		Nickname: "gophirc", Username: "gophirc", Realname: "gophirc",
+		Flood:     config.Flood{Disabled: true},
+		Reconnect: config.Reconnect{Disabled: true},
+	})
+	i.ConnectWith(client)
+
+	run := make(chan error, 1)
+	go func() {
+		run <- i.Run(context.Background())
+	}()
+
+	server.Write([]byte(":irc.test NOTICE * :*** Looking up your hostname...\r\n"))
+	r := bufio.NewReader(server)
+	if l, _ := r.ReadString('\n'); l != "CAP LS 302\r\n" {
+		t.Errorf("Expected %q, got %q instead.", "CAP LS 302\r\n", l)
+	}
+
+	server.Close()
+	if err := <-run; err != ErrConnectionClosed {
+		t.Errorf("Expected %v, got %v instead.", ErrConnectionClosed, err)
+	}
+}
+
+func TestIRC_SetDialer(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   config.Server
+		expected string // the address dialed
+	}{
+		{"address", config.Server{Address: "irc.server.tld", Port: 6667}, "irc.server.tld:6667"},
+		{"websocket", config.Server{Address: "irc.server.tld", Port: 6667,
+			WebSocket: config.WebSocket{URL: "ws://irc.server.tld/webirc"}}, "irc.server.tld:80"},
+		{"websocket port", config.Server{WebSocket: config.WebSocket{URL: "ws://irc.server.tld:8080"}}, "irc.server.tld:8080"},
+		{"proxy", config.Server{Address: "irc.server.tld", Port: 6667, Proxy: "socks5://127.0.0.1:1080"}, "127.0.0.1:1080"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dialed := make(chan string, 1)
+			s := test.server
+			i := New(&s).SetDialer(DialerFunc(func(network, address string) (net.Conn, error) {
+				dialed <- address
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			}))
+
+			// the handshakes fail on the closed pipe, only the address matters
+			i.Connect()
+			if address := <-dialed; address != test.expected {
+				t.Errorf("Expected %q, got %q instead.", test.expected, address)
+			}
+		})
+	}
+}
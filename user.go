@@ -24,9 +24,7 @@ func (u *User) String() string {
 // ParseUser splits a "nick!user@host" raw user into a User struct,
 // containing the nickname, username, and hostname.
 func ParseUser(user string) (*User, bool) {
-	if user[0] == ':' {
-		user = user[1:]
-	}
+	user = strings.TrimPrefix(user, ":")
 
 	pattern := regexp.MustCompile(
 		`\A[a-zA-Z_\-\[\]\\^{}|][a-zA-Z0-9_\-\[\]\\^{}|.` + "`" +
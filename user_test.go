@@ -13,6 +13,7 @@ func TestParseUser(t *testing.T) {
 		{":}o{!I`mAButterfly@this.is.my.vhost", true},
 		{"x@y!z", false},
 		{"malformed", false},
+		{"", false},
 		{"psycho!~madness@0x00.0x70737963686f", true},
 		{"gophirc_test!~gophirc@2a02:2f0d:1a1:c19:581e:ca94:3650:2615", true},
 	}
@@ -0,0 +1,350 @@
+package gophirc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The IRCv3 WebSocket subprotocols: each message is a line, without the CR LF, as UTF-8 text
+// or as binary.
+const (
+	WebSocketText   = "text.ircv3.net"
+	WebSocketBinary = "binary.ircv3.net"
+)
+
+// webSocketGUID is appended to the handshake key, RFC 6455 section 1.3.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes, RFC 6455 section 5.2.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// maxWebSocketMessage bounds the messages received; the IRC lines are much shorter, even with tags.
+const maxWebSocketMessage = 64 * 1024
+
+// WebSocketDialer returns a Dialer speaking IRC over WebSocket at the "ws://" or "wss://" URL,
+// on the connections opened with d, which must handle TLS for "wss://". It requests the
+// binary.ircv3.net subprotocol if useBinary is set, text.ircv3.net otherwise.
+func WebSocketDialer(d Dialer, rawURL string, useBinary bool) Dialer {
+	protocol := WebSocketText
+	if useBinary {
+		protocol = WebSocketBinary
+	}
+
+	return DialerFunc(func(network, address string) (net.Conn, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid WebSocket URL")
+		}
+
+		c, err := d.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		c.SetDeadline(time.Now().Add(dialTimeout))
+		wc, err := webSocketHandshake(c, u, protocol)
+		if err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "WebSocket handshake failed")
+		}
+		c.SetDeadline(time.Time{})
+		return wc, nil
+	})
+}
+
+// webSocketAddress returns the "host:port" the URL is reached at.
+func webSocketAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "wss" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// webSocketHandshake upgrades the connection to WebSocket, asking for the subprotocol.
+func webSocketHandshake(c net.Conn, u *url.URL, protocol string) (net.Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-WebSocket-Key":      {key},
+			"Sec-WebSocket-Version":  {"13"},
+			"Sec-WebSocket-Protocol": {protocol},
+		},
+		Host: u.Host,
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.Errorf("Unexpected reply %q", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return nil, errors.New("The server didn't upgrade to WebSocket")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, errors.New("Invalid Sec-WebSocket-Accept")
+	}
+
+	// without a subprotocol, the server speaks text
+	wc := &wsConn{Conn: c, r: r}
+	switch p := resp.Header.Get("Sec-WebSocket-Protocol"); p {
+	case "", WebSocketText:
+	case WebSocketBinary:
+		wc.binary = true
+	default:
+		return nil, errors.Errorf("Unexpected subprotocol %q", p)
+	}
+	return wc, nil
+}
+
+// webSocketAccept returns the Sec-WebSocket-Accept the server must answer the key with.
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsConn is a WebSocket connection read & written as IRC lines: each message received is read
+// as a line ending in CR LF, and each line written is sent as a message.
+type wsConn struct {
+	net.Conn
+	r      *bufio.Reader
+	binary bool
+
+	pending []byte // the rest of the line being read
+
+	writeMu sync.Mutex // the control frames are written while reading
+	partial []byte     // the start of a line written without its LF
+	closed  bool       // a close frame was received
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		if len(msg) > 0 {
+			c.pending = append(msg, '\r', '\n')
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readMessage returns the next data message, answering the control frames read before it.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		f, err := readFrame(c.r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch f.opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, f.payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.closed = true
+			c.writeFrame(wsClose, f.payload)
+			return nil, nil
+		case wsText, wsBinary, wsContinuation:
+		default:
+			return nil, errors.Errorf("Unknown WebSocket opcode %d", f.opcode)
+		}
+
+		msg = append(msg, f.payload...)
+		if len(msg) > maxWebSocketMessage {
+			return nil, errors.New("WebSocket message too long")
+		}
+		if f.fin {
+			return bytes.TrimRight(msg, "\r\n"), nil
+		}
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	data := append(c.partial, p...)
+	c.writeMu.Unlock()
+
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(data[:i], "\r")
+		data = data[i+1:]
+		if len(line) == 0 {
+			continue
+		}
+
+		opcode := byte(wsText)
+		if c.binary {
+			opcode = wsBinary
+		} else {
+			line = bytes.ToValidUTF8(line, []byte("�"))
+		}
+		if err := c.writeFrame(opcode, line); err != nil {
+			return 0, err
+		}
+	}
+
+	c.writeMu.Lock()
+	c.partial = append([]byte(nil), data...)
+	c.writeMu.Unlock()
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000, normal closure
+	return c.Conn.Close()
+}
+
+// writeFrame writes a masked frame, as sent by clients.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeFrame(c.Conn, opcode, payload, true)
+}
+
+// wsFrame is a WebSocket frame read, unmasked.
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads a frame, unmasking its payload if masked.
+func readFrame(r io.Reader) (*wsFrame, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	f := &wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		n = binary.BigEndian.Uint64(b)
+	}
+	if n > maxWebSocketMessage {
+		return nil, fmt.Errorf("WebSocket frame too long: %d bytes", n)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// writeFrame writes the payload in a single frame, masked with a random key if mask is set.
+func writeFrame(w io.Writer, opcode byte, payload []byte, mask bool) error {
+	frame := []byte{0x80 | opcode, 0}
+
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, byte(n>>8), byte(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if !mask {
+		_, err := w.Write(append(frame, payload...))
+		return err
+	}
+
+	frame[1] |= 0x80
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	frame = append(frame, key[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,145 @@
+package gophirc
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/vlad-s/gophirc/config"
+)
+
+// webSocketServer upgrades the connection on c, answering with the subprotocol, then sends the
+// frames received, unmasked, on frames.
+func webSocketServer(c net.Conn, protocol string, frames chan<- *wsFrame) {
+	r := bufio.NewReader(c)
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Header.Get("Upgrade") != "websocket" {
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		return
+	}
+
+	reply := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(req.Header.Get("Sec-WebSocket-Key")) + "\r\n"
+	if protocol != "" {
+		reply += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	c.Write([]byte(reply + "\r\n"))
+
+	// a ping, then a line split in two frames
+	writeFrame(c, wsPing, []byte("ping?"), false)
+	c.Write([]byte{wsText, 7})
+	c.Write([]byte(":irc.te"))
+	writeFrame(c, wsContinuation, []byte("st NOTICE * :hi"), false)
+
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			close(frames)
+			return
+		}
+		frames <- f
+	}
+}
+
+func TestWebSocketDialer(t *testing.T) {
+	tests := []struct {
+		name     string
+		binary   bool
+		protocol string // chosen by the server
+		opcode   byte   // of the lines sent
+	}{
+		{"text", false, WebSocketText, wsText},
+		{"no subprotocol", false, "", wsText},
+		{"binary", true, WebSocketBinary, wsBinary},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames := make(chan *wsFrame, 10)
+			address := listen(t, func(c net.Conn) {
+				webSocketServer(c, test.protocol, frames)
+			})
+
+			d := WebSocketDialer(&net.Dialer{}, "ws://"+address+"/webirc", test.binary)
+			c, err := d.Dial("tcp", address)
+			if err != nil {
+				t.Fatal("Can't connect over WebSocket", err)
+			}
+
+			r := bufio.NewReader(c)
+			if l, _ := r.ReadString('\n'); l != ":irc.test NOTICE * :hi\r\n" {
+				t.Errorf("Expected the notice, got %q instead.", l)
+			}
+			if f := <-frames; f.opcode != wsPong || string(f.payload) != "ping?" {
+				t.Errorf("Expected the pong, got %+v instead.", f)
+			}
+
+			// the lines may be written in several parts
+			c.Write([]byte("CAP LS 302\r\nNICK "))
+			c.Write([]byte("gophirc\r\n"))
+			for _, expected := range []string{"CAP LS 302", "NICK gophirc"} {
+				f := <-frames
+				if f.opcode != test.opcode || string(f.payload) != expected {
+					t.Errorf("Expected %q with the opcode %d, got %q with %d instead.", expected, test.opcode, f.payload, f.opcode)
+				}
+			}
+
+			c.Close()
+			if f := <-frames; f.opcode != wsClose {
+				t.Errorf("Expected the close frame, got %+v instead.", f)
+			}
+		})
+	}
+}
+
+func TestWebSocketDialer_Handshake(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"not upgraded", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"},
+		{"wrong accept", "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: nope\r\n\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := listen(t, func(c net.Conn) {
+				http.ReadRequest(bufio.NewReader(c))
+				c.Write([]byte(test.reply))
+			})
+
+			d := WebSocketDialer(&net.Dialer{}, "ws://"+address, false)
+			if _, err := d.Dial("tcp", address); err == nil {
+				t.Error("Expected the handshake to fail, got nil instead.")
+			}
+		})
+	}
+}
+
+func TestIRC_ConnectWebSocket(t *testing.T) {
+	frames := make(chan *wsFrame, 10)
+	address := listen(t, func(c net.Conn) {
+		webSocketServer(c, WebSocketText, frames)
+	})
+
+	i := New(&config.Server{
+		WebSocket: config.WebSocket{URL: "ws://" + address + "/webirc"},
+		Nickname:  "gophirc",
+	})
+	if err := i.Connect(); err != nil {
+		t.Fatal("Can't connect over WebSocket", err)
+	}
+	defer i.conn.Close()
+
+	if _, ok := i.conn.(*wsConn); !ok {
+		t.Errorf("Expected a WebSocket connection, got %T instead.", i.conn)
+	}
+	i.SendRaw("NICK gophirc")
+	for f := range frames {
+		if f.opcode == wsText {
+			if string(f.payload) != "NICK gophirc" {
+				t.Errorf("Expected %q, got %q instead.", "NICK gophirc", f.payload)
+			}
+			break
+		}
+	}
+}